@@ -0,0 +1,104 @@
+package objectDB
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// WriteBatch defers the writing of the files of one or more tables until
+// Commit is called. This avoids that the files of related tables are only
+// partially written if a sequence of modifications fails in between. Only
+// the writing of the files is deferred, the modifications of the tables in
+// memory take effect immediately and are not rolled back.
+type WriteBatch struct {
+	m      sync.Mutex
+	tables []batchTable
+}
+
+type batchTable interface {
+	// commitBatch writes the modified files. If all files are written, the
+	// table leaves the batch and true is returned.
+	commitBatch() (bool, error)
+}
+
+// NewWriteBatch creates a new, empty WriteBatch.
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+// Commit writes all files modified since the tables were added to the batch.
+// All files are processed, and the first error is returned. Tables whose
+// files were written completely leave the batch and write their files
+// immediately again. Tables with failed files stay in the batch, so Commit
+// can be called again to retry.
+func (b *WriteBatch) Commit() error {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	var firstErr error
+	var remaining []batchTable
+	for _, t := range b.tables {
+		done, err := t.commitBatch()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if !done {
+			remaining = append(remaining, t)
+		}
+	}
+	b.tables = remaining
+	return firstErr
+}
+
+// Batch adds the table to the batch. Until Commit is called, modified files
+// are not written but collected in the batch. If the program ends before
+// Commit is called, the modifications are lost. A table can only be part of
+// a single batch at a time.
+func (t *Table[E]) Batch(b *WriteBatch) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	t.lock(LockConfig)
+	defer t.m.Unlock()
+
+	if t.batch == b {
+		return nil
+	}
+	if t.batch != nil {
+		return errors.New("batch: table is already part of another batch")
+	}
+	t.batch = b
+	t.batchFiles = map[string]bool{}
+	b.tables = append(b.tables, t)
+	return nil
+}
+
+func (t *Table[E]) commitBatch() (bool, error) {
+	t.lock(LockWrite)
+	defer t.m.Unlock()
+
+	var names []string
+	for name := range t.batchFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var firstErr error
+	for _, name := range names {
+		err := t.writeFile(name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			delete(t.batchFiles, name)
+		}
+	}
+
+	if len(t.batchFiles) > 0 {
+		return false, firstErr
+	}
+	t.batch = nil
+	t.batchFiles = nil
+	return true, nil
+}
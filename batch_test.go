@@ -0,0 +1,54 @@
+package objectDB
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWriteBatch(t *testing.T) {
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	orders := PersistMemory[time.Time]()
+	t1, err := New[time.Time](myMonthly, orders, nil, less)
+	assert.NoError(t, err)
+	failed := errors.New("failed")
+	items := &failingPersist{fails: 1, err: failed}
+	t2, err := New[time.Time](myMonthly, items, nil, less)
+	assert.NoError(t, err)
+
+	b := NewWriteBatch()
+	assert.NoError(t, t1.Batch(b))
+	assert.NoError(t, t2.Batch(b))
+	assert.NoError(t, t2.Batch(b))
+	assert.Error(t, t2.Batch(NewWriteBatch()))
+
+	assert.NoError(t, t1.Insert(date(2024, 1, 1)))
+	assert.NoError(t, t1.Insert(date(2024, 2, 1)))
+	assert.NoError(t, t2.Insert(date(2024, 1, 1)))
+	assert.NoError(t, t2.Insert(date(2024, 1, 2)))
+
+	// nothing is written before the commit
+	files, err := orders.(Lister).List()
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+	assert.EqualValues(t, 0, items.calls)
+
+	// the second table fails and stays in the batch
+	assert.ErrorIs(t, b.Commit(), failed)
+	files, err = orders.(Lister).List()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"test_2024_01", "test_2024_02"}, files)
+	assert.EqualValues(t, 1, items.calls)
+
+	assert.NoError(t, t2.Insert(date(2024, 1, 3)))
+	assert.EqualValues(t, 1, items.calls)
+	assert.NoError(t, b.Commit())
+	assert.EqualValues(t, 2, items.calls)
+
+	// after the commit the files are written immediately
+	assert.NoError(t, t2.Insert(date(2024, 1, 4)))
+	assert.EqualValues(t, 3, items.calls)
+	assert.NoError(t, b.Commit())
+	assert.EqualValues(t, 3, items.calls)
+}
@@ -0,0 +1,114 @@
+package objectDB
+
+import (
+	"reflect"
+)
+
+// DeepCopy creates a deep copy of src and stores it in dst. It can be passed
+// as the deepCopy function to New. Pointers, slices, maps and interfaces are
+// copied recursively. Pointers to the same value are copied to pointers to the
+// same copy, so cyclic data structures are supported. Unexported struct fields
+// are not accessible by reflection and are therefore copied shallowly. Types
+// like time.Time which keep their state in unexported fields are copied
+// correctly nevertheless. Because reflection is used, DeepCopy is much slower
+// than a hand-written copy function. If the table is modified frequently, a
+// hand-written function should be preferred.
+func DeepCopy[E any](dst, src *E) {
+	c := copier{visited: map[visitKey]reflect.Value{}}
+	c.copy(reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem())
+}
+
+// visitKey identifies an already copied pointer. The type is required because
+// a pointer to a struct and a pointer to its first field share the address.
+type visitKey struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+type copier struct {
+	visited map[visitKey]reflect.Value
+}
+
+func (c copier) copy(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Pointer:
+		if src.IsNil() {
+			dst.SetZero()
+			return
+		}
+		key := visitKey{ptr: src.Pointer(), typ: src.Type()}
+		if p, ok := c.visited[key]; ok {
+			dst.Set(p)
+			return
+		}
+		p := reflect.New(src.Type().Elem())
+		c.visited[key] = p
+		c.copy(p.Elem(), src.Elem())
+		dst.Set(p)
+	case reflect.Slice:
+		if src.IsNil() {
+			dst.SetZero()
+			return
+		}
+		s := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			c.copy(s.Index(i), src.Index(i))
+		}
+		dst.Set(s)
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			c.copy(dst.Index(i), src.Index(i))
+		}
+	case reflect.Map:
+		if src.IsNil() {
+			dst.SetZero()
+			return
+		}
+		m := reflect.MakeMapWithSize(src.Type(), src.Len())
+		it := src.MapRange()
+		for it.Next() {
+			k := reflect.New(src.Type().Key()).Elem()
+			c.copy(k, it.Key())
+			v := reflect.New(src.Type().Elem()).Elem()
+			c.copy(v, it.Value())
+			m.SetMapIndex(k, v)
+		}
+		dst.Set(m)
+	case reflect.Struct:
+		dst.Set(src)
+		for i := 0; i < src.NumField(); i++ {
+			if src.Type().Field(i).IsExported() {
+				c.copy(dst.Field(i), src.Field(i))
+			}
+		}
+	case reflect.Interface:
+		if src.IsNil() {
+			dst.SetZero()
+			return
+		}
+		v := reflect.New(src.Elem().Type()).Elem()
+		c.copy(v, src.Elem())
+		dst.Set(v)
+	default:
+		dst.Set(src)
+	}
+}
+
+// hasReferences returns true if values of the given type share memory if they
+// are copied by an assignment. Only exported struct fields are checked.
+func hasReferences(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+		return true
+	case reflect.Array:
+		return hasReferences(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.IsExported() && hasReferences(f.Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package objectDB
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type copyItem struct {
+	Date   time.Time
+	Values []int
+	Tags   map[string][]string
+	Next   *copyItem
+	Any    any
+	Arr    [2][]int
+	hidden int
+}
+
+func TestDeepCopy(t *testing.T) {
+	src := copyItem{
+		Date:   time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC),
+		Values: []int{1, 2, 3},
+		Tags:   map[string][]string{"a": {"b"}},
+		Next:   &copyItem{Values: []int{4}},
+		Any:    []int{5},
+		Arr:    [2][]int{{6}, {7}},
+		hidden: 8,
+	}
+	var dst copyItem
+	DeepCopy(&dst, &src)
+	assert.EqualValues(t, src, dst)
+
+	src.Values[0] = 0
+	src.Tags["a"][0] = "x"
+	src.Next.Values[0] = 0
+	src.Any.([]int)[0] = 0
+	src.Arr[0][0] = 0
+	assert.EqualValues(t, []int{1, 2, 3}, dst.Values)
+	assert.EqualValues(t, "b", dst.Tags["a"][0])
+	assert.EqualValues(t, 4, dst.Next.Values[0])
+	assert.EqualValues(t, []int{5}, dst.Any)
+	assert.EqualValues(t, 6, dst.Arr[0][0])
+	assert.EqualValues(t, 8, dst.hidden)
+}
+
+func TestDeepCopyCycle(t *testing.T) {
+	src := &copyItem{Values: []int{1}}
+	src.Next = src
+
+	var dst copyItem
+	DeepCopy(&dst, src)
+	assert.True(t, dst.Next != src)
+	assert.True(t, dst.Next.Next == dst.Next)
+	assert.EqualValues(t, []int{1}, dst.Next.Values)
+}
+
+func TestDeepCopyTable(t *testing.T) {
+	table, err := New[copyItem](SingleFile[copyItem]("copy"), nil, DeepCopy[copyItem], nil)
+	assert.NoError(t, err)
+
+	item := copyItem{Values: []int{1, 2, 3}}
+	assert.NoError(t, table.Insert(&item))
+	item.Values[0] = 0
+
+	var stored copyItem
+	assert.True(t, table.First(&stored, func(e *copyItem) bool { return true }))
+	assert.EqualValues(t, []int{1, 2, 3}, stored.Values)
+}
+
+func TestNewRequiresDeepCopy(t *testing.T) {
+	_, err := New[copyItem](SingleFile[copyItem]("copy"), nil, nil, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "deepCopy")
+
+	type values struct {
+		Date  time.Time
+		N     int
+		Arr   [3]float64
+		inner []int
+	}
+	_, err = New[values](SingleFile[values]("copy"), nil, nil, nil)
+	assert.NoError(t, err)
+
+	type withArray struct {
+		Arr [3][]int
+	}
+	_, err = New[withArray](SingleFile[withArray]("copy"), nil, nil, nil)
+	assert.Error(t, err)
+}
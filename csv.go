@@ -0,0 +1,213 @@
+package objectDB
+
+import (
+	"encoding"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// PersistCSV returns a Persist that stores objects in CSV format. Each element
+// is stored in a single row. E has to be a struct whose exported fields are
+// of a scalar kind (bool, integers, floats and strings) or implement
+// encoding.TextMarshaler and encoding.TextUnmarshaler like time.Time does.
+// Each field becomes a single column. If header is true, the first row
+// contains the field names, and the columns are identified by their name
+// when the files are read.
+func PersistCSV[E any](baseFolder, suffix string, header bool) Persist[E] {
+	return newPersistFiles[E](baseFolder, suffix, csvCodec[E]{header: header})
+}
+
+type csvCodec[E any] struct {
+	header bool
+}
+
+func (csvCodec[E]) kind() string {
+	return "csv"
+}
+
+var (
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// csvFields returns the indices and names of the fields which are stored
+// in the csv file.
+func csvFields(t reflect.Type) ([]int, []string, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("csv: type %v is not a struct", t)
+	}
+	var index []int
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if !isCSVType(f.Type) {
+			return nil, nil, fmt.Errorf("csv: field %s of type %v in %v can not be stored in a single column", f.Name, f.Type, t)
+		}
+		index = append(index, i)
+		names = append(names, f.Name)
+	}
+	return index, names, nil
+}
+
+func isCSVType(t reflect.Type) bool {
+	if t.Implements(textMarshalerType) && reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c csvCodec[E]) encode(w io.Writer, items []*E) error {
+	index, names, err := csvFields(reflect.TypeFor[E]())
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	if c.header {
+		err = cw.Write(names)
+		if err != nil {
+			return fmt.Errorf("could not write csv: %w", err)
+		}
+	}
+	row := make([]string, len(index))
+	for _, item := range items {
+		v := reflect.ValueOf(item).Elem()
+		for i, fi := range index {
+			row[i], err = formatCSV(v.Field(fi))
+			if err != nil {
+				return fmt.Errorf("csv: could not format field %s: %w", names[i], err)
+			}
+		}
+		err = cw.Write(row)
+		if err != nil {
+			return fmt.Errorf("could not write csv: %w", err)
+		}
+	}
+	cw.Flush()
+	if err = cw.Error(); err != nil {
+		return fmt.Errorf("could not write csv: %w", err)
+	}
+	return nil
+}
+
+func formatCSV(v reflect.Value) (string, error) {
+	if v.Type().Implements(textMarshalerType) {
+		b, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(b), err
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	default:
+		return "", fmt.Errorf("unsupported type %v", v.Type())
+	}
+}
+
+func (c csvCodec[E]) decode(r io.Reader) ([]*E, error) {
+	index, names, err := csvFields(reflect.TypeFor[E]())
+	if err != nil {
+		return nil, err
+	}
+
+	cr := csv.NewReader(r)
+	if !c.header {
+		cr.FieldsPerRecord = len(index)
+	} else {
+		h, err := cr.Read()
+		if err != nil {
+			return nil, fmt.Errorf("could not read csv header: %w", err)
+		}
+		byName := map[string]int{}
+		for i, n := range names {
+			byName[n] = index[i]
+		}
+		index = make([]int, len(h))
+		for i, n := range h {
+			fi, ok := byName[n]
+			if !ok {
+				return nil, fmt.Errorf("csv: unknown column %s", n)
+			}
+			index[i] = fi
+		}
+	}
+
+	var items []*E
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read csv file: %w", err)
+		}
+		var e E
+		v := reflect.ValueOf(&e).Elem()
+		for i, fi := range index {
+			err = parseCSV(v.Field(fi), row[i])
+			if err != nil {
+				line, _ := cr.FieldPos(i)
+				return nil, fmt.Errorf("csv: could not parse line %d, field %s: %w", line, v.Type().Field(fi).Name, err)
+			}
+		}
+		items = append(items, &e)
+	}
+}
+
+func parseCSV(v reflect.Value, s string) error {
+	if v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %v", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package objectDB
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+type flat struct {
+	Name   string
+	Count  int
+	Small  int8
+	Amount float64
+	Ok     bool
+	Id     uint32
+	Date   time.Time
+	hidden int
+}
+
+func TestCSV(t *testing.T) {
+	for _, header := range []bool{true, false} {
+		p := PersistCSV[flat]("testdata", "_db.csv", header)
+
+		d := time.Date(2024, 1, 5, 10, 30, 0, 0, time.UTC)
+		items := []*flat{
+			{Name: "a, \"quoted\"", Count: -5, Small: 3, Amount: 1.5, Ok: true, Id: 7, Date: d},
+			{Name: "b\nline", Count: 1 << 40, Amount: -0.1, Date: d.Add(time.Hour)},
+		}
+		assert.NoError(t, p.Persist("csv", items))
+
+		restored, err := p.Restore()
+		assert.NoError(t, err)
+		assert.EqualValues(t, items, restored)
+
+		assert.NoError(t, p.Persist("csv", nil))
+	}
+}
+
+func TestCSVHeader(t *testing.T) {
+	p := PersistCSV[flat]("testdata", "_db.csv", true)
+	assert.NoError(t, os.WriteFile("testdata/csv_db.csv", []byte("Count,Name\n5,hello\n"), 0644))
+	restored, err := p.Restore()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []*flat{{Name: "hello", Count: 5}}, restored)
+
+	assert.NoError(t, os.WriteFile("testdata/csv_db.csv", []byte("Count,Unknown\n5,hello\n"), 0644))
+	_, err = p.Restore()
+	assert.Error(t, err)
+
+	assert.NoError(t, os.WriteFile("testdata/csv_db.csv", []byte("Count,Name\nfive,hello\n"), 0644))
+	_, err = p.Restore()
+	assert.Error(t, err)
+
+	assert.NoError(t, p.Persist("csv", nil))
+}
+
+type nested struct {
+	Name  string
+	Inner struct {
+		A int
+	}
+}
+
+func TestCSVNested(t *testing.T) {
+	p := PersistCSV[nested]("testdata", "_db.csv", true)
+	err := p.Persist("csv", []*nested{{Name: "a"}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Inner")
+
+	files, err := os.ReadDir("testdata")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, len(files))
+}
@@ -0,0 +1,106 @@
+package objectDB
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Export writes all files of the table to w as a tar archive. The files are
+// created from the current in-memory state of the table, so the archive is
+// consistent even if there are pending delayed writes. The files are encoded
+// in the same way the Persist of the table writes them to disk. The Persist
+// of the table has to be file based, see Persist, otherwise an error is
+// returned.
+func (t *Table[E]) Export(w io.Writer) error {
+	sp, ok := t.persist.(streamPersist[E])
+	if !ok {
+		return errors.New("export: persist does not support export")
+	}
+
+	type file struct {
+		name string
+		data []byte
+	}
+
+	t.lock(LockRead)
+	groups := t.groupByFile()
+	var files []file
+	for name, items := range groups {
+		var b bytes.Buffer
+		err := sp.encode(&b, items)
+		if err != nil {
+			t.m.Unlock()
+			return fmt.Errorf("export: could not encode %s: %w", name, err)
+		}
+		files = append(files, file{name: sp.fileName(name), data: b.Bytes()})
+	}
+	t.m.Unlock()
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].name < files[j].name
+	})
+
+	now := time.Now()
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.name,
+			Size:     int64(len(f.data)),
+			Mode:     0644,
+			ModTime:  now,
+		})
+		if err != nil {
+			return fmt.Errorf("export: could not write tar header: %w", err)
+		}
+		_, err = tw.Write(f.data)
+		if err != nil {
+			return fmt.Errorf("export: could not write tar data: %w", err)
+		}
+	}
+	return tw.Close()
+}
+
+// Import reads a tar archive created by Table.Export and writes all files to
+// the base folder. Existing files with the same name are overwritten. After
+// the import, a table can be created by New using the base folder. The base
+// folder is created if it does not exist.
+func Import(r io.Reader, baseFolder string) error {
+	err := os.MkdirAll(baseFolder, 0755)
+	if err != nil {
+		return fmt.Errorf("import: could not create base folder: %w", err)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("import: could not read tar: %w", err)
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if h.Name == "" || strings.ContainsAny(h.Name, `/\`) || h.Name == "." || h.Name == ".." {
+			return fmt.Errorf("import: invalid file name %q", h.Name)
+		}
+
+		err = writeAtomic(path.Join(baseFolder, h.Name), 0644, false, func(w io.Writer) error {
+			_, err := io.Copy(w, tr)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("import: could not write %s: %w", h.Name, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package objectDB
+
+import (
+	"bytes"
+	"github.com/hneemann/objectDB/serialize"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	p := PersistCompressed(PersistSerializer[time.Time]("testdata", "_db.bin", serialize.New()))
+	table, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+	n := time.Now()
+	table.Insert(add(n, -24*30))
+	table.Insert(add(n, 0))
+	table.Insert(add(n, 1))
+	table.Insert(add(n, 24*30))
+
+	var b bytes.Buffer
+	assert.NoError(t, table.Export(&b))
+
+	// wipe the folder
+	files, err := os.ReadDir("testdata")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, len(files))
+	for _, f := range files {
+		assert.NoError(t, os.Remove("testdata/"+f.Name()))
+	}
+
+	assert.NoError(t, Import(&b, "testdata"))
+
+	table2, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, table2.Size())
+	var all1, all2 []time.Time
+	for e := range table.All {
+		all1 = append(all1, *e)
+	}
+	for e := range table2.All {
+		all2 = append(all2, *e)
+	}
+	for i := range all1 {
+		assert.True(t, all1[i].Equal(all2[i]))
+	}
+
+	a := table2.Match(func(e *time.Time) bool { return true })
+	for range a.Size() {
+		assert.NoError(t, a.Delete(0))
+	}
+}
+
+func TestExportNoPersist(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, nil)
+	assert.NoError(t, err)
+	var b bytes.Buffer
+	assert.Error(t, table.Export(&b))
+}
@@ -0,0 +1,81 @@
+package objectDB
+
+// hooks holds the lifecycle hooks of a table. The hooks are called
+// synchronously while the table is locked. So a hook must not call any method
+// of the table, and no long-running operations should be done in a hook. The
+// elements passed to the hooks must not be modified, except by the
+// BeforeInsert hook, which receives the copy that is going to be stored.
+type hooks[E any] struct {
+	beforeInsert func(*E) error
+	beforeUpdate func(*E) error
+	beforeDelete func(*E) error
+	afterInsert  func(*E)
+	afterUpdate  func(*E)
+	afterDelete  func(*E)
+}
+
+func (h *hooks[E]) after(op Operation, e *E) {
+	var f func(*E)
+	switch op {
+	case OpInsert:
+		f = h.afterInsert
+	case OpUpdate:
+		f = h.afterUpdate
+	case OpDelete:
+		f = h.afterDelete
+	}
+	if f != nil {
+		f(e)
+	}
+}
+
+// OnBeforeInsert sets a hook which is called before an element is inserted.
+// If the hook returns an error, the element is not inserted and the error is
+// returned by Insert. The hook is called while the table is locked, so it must
+// not call any method of the table. The hook receives the copy of the element
+// which is going to be stored and may modify it. All other hooks must not
+// modify the element they receive.
+func (t *Table[E]) OnBeforeInsert(hook func(*E) error) {
+	t.lock(LockConfig)
+	defer t.m.Unlock()
+	t.hooks.beforeInsert = hook
+}
+
+// OnBeforeUpdate sets a hook which is called with the new value before an
+// element is updated. If the hook returns an error, the element is not
+// updated and the error is returned by Update.
+func (t *Table[E]) OnBeforeUpdate(hook func(*E) error) {
+	t.lock(LockConfig)
+	defer t.m.Unlock()
+	t.hooks.beforeUpdate = hook
+}
+
+// OnBeforeDelete sets a hook which is called before an element is deleted.
+// If the hook returns an error, the element is not deleted and the error is
+// returned by Delete.
+func (t *Table[E]) OnBeforeDelete(hook func(*E) error) {
+	t.lock(LockConfig)
+	defer t.m.Unlock()
+	t.hooks.beforeDelete = hook
+}
+
+// OnAfterInsert sets a hook which is called after an element was inserted.
+func (t *Table[E]) OnAfterInsert(hook func(*E)) {
+	t.lock(LockConfig)
+	defer t.m.Unlock()
+	t.hooks.afterInsert = hook
+}
+
+// OnAfterUpdate sets a hook which is called after an element was updated.
+func (t *Table[E]) OnAfterUpdate(hook func(*E)) {
+	t.lock(LockConfig)
+	defer t.m.Unlock()
+	t.hooks.afterUpdate = hook
+}
+
+// OnAfterDelete sets a hook which is called after an element was deleted.
+func (t *Table[E]) OnAfterDelete(hook func(*E)) {
+	t.lock(LockConfig)
+	defer t.m.Unlock()
+	t.hooks.afterDelete = hook
+}
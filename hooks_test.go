@@ -0,0 +1,69 @@
+package objectDB
+
+import (
+	"errors"
+	"github.com/hneemann/objectDB/serialize"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHooksVeto(t *testing.T) {
+	table, err := New[time.Time](myMonthly, PersistSerializer[time.Time]("testdata", "_db.bin", serialize.New()), nil, nil)
+	assert.NoError(t, err)
+
+	veto := errors.New("veto")
+	table.OnBeforeInsert(func(e *time.Time) error { return veto })
+
+	n := time.Now()
+	assert.ErrorIs(t, table.Insert(&n), veto)
+	assert.EqualValues(t, 0, table.Size())
+	assert.EqualValues(t, 0, table.Version())
+
+	// nothing is persisted
+	files, err := os.ReadDir("testdata")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, len(files))
+
+	table.OnBeforeInsert(nil)
+	assert.NoError(t, table.Insert(&n))
+
+	table.OnBeforeDelete(func(e *time.Time) error { return veto })
+	table.OnBeforeUpdate(func(e *time.Time) error { return veto })
+	r := table.Match(func(e *time.Time) bool { return true })
+	version := table.Version()
+	assert.ErrorIs(t, r.Update(0, add(n, 1)), veto)
+	assert.ErrorIs(t, r.Delete(0), veto)
+	assert.EqualValues(t, version, table.Version())
+	assert.EqualValues(t, 1, table.Size())
+
+	var e time.Time
+	assert.NoError(t, r.Get(&e, 0))
+	assert.True(t, n.Equal(e))
+
+	table.OnBeforeDelete(nil)
+	assert.NoError(t, r.Delete(0))
+}
+
+func TestHooksAfter(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, nil)
+	assert.NoError(t, err)
+
+	var log []string
+	table.OnBeforeInsert(func(e *time.Time) error {
+		log = append(log, "beforeInsert")
+		return nil
+	})
+	table.OnAfterInsert(func(e *time.Time) { log = append(log, "afterInsert") })
+	table.OnAfterUpdate(func(e *time.Time) { log = append(log, "afterUpdate") })
+	table.OnAfterDelete(func(e *time.Time) { log = append(log, "afterDelete") })
+
+	n := time.Now()
+	assert.NoError(t, table.Insert(&n))
+	r := table.Match(func(e *time.Time) bool { return true })
+	assert.NoError(t, r.Update(0, add(n, 1)))
+	assert.NoError(t, r.Delete(0))
+
+	assert.EqualValues(t, []string{"beforeInsert", "afterInsert", "afterUpdate", "afterDelete"}, log)
+}
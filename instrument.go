@@ -0,0 +1,103 @@
+package objectDB
+
+import "time"
+
+// PersistInstrumented returns a Persist that calls the callback after each
+// call of the inner Persist. The callback receives the operation, which is
+// "persist", "append", "restore", "list" or "stat", the name of the file, the
+// duration of the call and the returned error. For Restore and List the name
+// is empty. If the inner Persist implements Lister or Stater, so does the
+// returned Persist, and appends are forwarded if the inner Persist implements
+// Appender. The callback can be used to collect metrics like the latency or
+// the error rate. It is called synchronously, so it should return quickly.
+func PersistInstrumented[E any](inner Persist[E], callback func(op string, file string, dur time.Duration, err error)) Persist[E] {
+	p := persistInstrumented[E]{inner: inner, callback: callback}
+	_, isLister := inner.(Lister)
+	_, isStater := inner.(Stater)
+	switch {
+	case isLister && isStater:
+		return instrumentedListerStater[E]{p}
+	case isLister:
+		return instrumentedLister[E]{p}
+	case isStater:
+		return instrumentedStater[E]{p}
+	default:
+		return p
+	}
+}
+
+type persistInstrumented[E any] struct {
+	inner    Persist[E]
+	callback func(op string, file string, dur time.Duration, err error)
+}
+
+func (p persistInstrumented[E]) Persist(name string, items []*E) error {
+	start := time.Now()
+	err := p.inner.Persist(name, items)
+	p.callback("persist", name, time.Since(start), err)
+	return err
+}
+
+// Append forwards the call to the inner Persist if it implements Appender.
+// Otherwise ErrAppendNotSupported is returned and the callback is not called.
+func (p persistInstrumented[E]) Append(name string, e *E) error {
+	a, ok := p.inner.(Appender[E])
+	if !ok {
+		return ErrAppendNotSupported
+	}
+	start := time.Now()
+	err := a.Append(name, e)
+	p.callback("append", name, time.Since(start), err)
+	return err
+}
+
+func (p persistInstrumented[E]) Restore() ([]*E, error) {
+	start := time.Now()
+	items, err := p.inner.Restore()
+	p.callback("restore", "", time.Since(start), err)
+	return items, err
+}
+
+// list is only called if the inner Persist implements Lister
+func (p persistInstrumented[E]) list() ([]string, error) {
+	start := time.Now()
+	names, err := p.inner.(Lister).List()
+	p.callback("list", "", time.Since(start), err)
+	return names, err
+}
+
+// stat is only called if the inner Persist implements Stater
+func (p persistInstrumented[E]) stat(name string) (time.Time, error) {
+	start := time.Now()
+	mod, err := p.inner.(Stater).Stat(name)
+	p.callback("stat", name, time.Since(start), err)
+	return mod, err
+}
+
+type instrumentedLister[E any] struct {
+	persistInstrumented[E]
+}
+
+func (p instrumentedLister[E]) List() ([]string, error) {
+	return p.list()
+}
+
+type instrumentedStater[E any] struct {
+	persistInstrumented[E]
+}
+
+func (p instrumentedStater[E]) Stat(name string) (time.Time, error) {
+	return p.stat(name)
+}
+
+type instrumentedListerStater[E any] struct {
+	persistInstrumented[E]
+}
+
+func (p instrumentedListerStater[E]) List() ([]string, error) {
+	return p.list()
+}
+
+func (p instrumentedListerStater[E]) Stat(name string) (time.Time, error) {
+	return p.stat(name)
+}
@@ -0,0 +1,79 @@
+package objectDB
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type call struct {
+	op   string
+	file string
+	err  error
+}
+
+func TestPersistInstrumented(t *testing.T) {
+	failed := errors.New("failed")
+	var calls []call
+	p := PersistInstrumented[time.Time](&failingPersist{fails: 1, err: failed}, func(op string, file string, dur time.Duration, err error) {
+		assert.True(t, dur >= 0)
+		calls = append(calls, call{op: op, file: file, err: err})
+	})
+
+	_, err := p.Restore()
+	assert.NoError(t, err)
+	assert.ErrorIs(t, p.Persist("a", nil), failed)
+	assert.NoError(t, p.Persist("b", nil))
+
+	assert.EqualValues(t, []call{
+		{op: "restore"},
+		{op: "persist", file: "a", err: failed},
+		{op: "persist", file: "b"},
+	}, calls)
+}
+
+func TestPersistInstrumentedForward(t *testing.T) {
+	var calls []call
+	callback := func(op string, file string, dur time.Duration, err error) {
+		calls = append(calls, call{op: op, file: file, err: err})
+	}
+
+	// failingPersist implements none of the optional interfaces
+	p := PersistInstrumented[time.Time](&failingPersist{}, callback)
+	_, ok := p.(Lister)
+	assert.False(t, ok)
+	_, ok = p.(Stater)
+	assert.False(t, ok)
+	assert.ErrorIs(t, p.(Appender[time.Time]).Append("a", date(2024, 1, 1)), ErrAppendNotSupported)
+	assert.Nil(t, calls)
+
+	// the memory persist implements Lister and Stater
+	p = PersistInstrumented(PersistMemory[time.Time](), callback)
+	assert.NoError(t, p.Persist("a", []*time.Time{date(2024, 1, 1)}))
+	files, err := p.(Lister).List()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"a"}, files)
+	mod, err := p.(Stater).Stat("a")
+	assert.NoError(t, err)
+	assert.False(t, mod.IsZero())
+	assert.EqualValues(t, []call{
+		{op: "persist", file: "a"},
+		{op: "list"},
+		{op: "stat", file: "a"},
+	}, calls)
+
+	// a file based persist is also able to append
+	calls = nil
+	p = PersistInstrumented(PersistJSONL[time.Time]("testdata", "_db.jsonl"), callback)
+	assert.NoError(t, p.(Appender[time.Time]).Append("l", date(2024, 1, 1)))
+	restored, err := p.Restore()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []*time.Time{date(2024, 1, 1)}, restored)
+	assert.NoError(t, p.Persist("l", nil))
+	assert.EqualValues(t, []call{
+		{op: "append", file: "l"},
+		{op: "restore"},
+		{op: "persist", file: "l"},
+	}, calls)
+}
@@ -1,14 +1,36 @@
 package objectDB
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"os"
+	"reflect"
+	"slices"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	// ErrVersionChanged is returned if the table was modified after a Result
+	// was created. The operation can be retried by creating a new Result.
+	ErrVersionChanged = errors.New("table has changed")
+	// ErrOrderViolation is returned if an update would violate the order of
+	// the table.
+	ErrOrderViolation = errors.New("order violation")
+	// ErrIndexOutOfRange is returned if an element of a Result is accessed
+	// by an index which is negative or not less than the size of the Result.
+	ErrIndexOutOfRange = errors.New("index out of range")
+	// ErrDeepCopy is returned if the deepCopy function panics.
+	ErrDeepCopy = errors.New("deep copy failed")
+	// ErrAppendOnly is returned if an element of an append only table would
+	// be modified or removed, see WithAppendOnly.
+	ErrAppendOnly = errors.New("table is append only")
+)
+
 type Table[E any] struct {
 	m            sync.Mutex
 	nameProvider NameProvider[E]
@@ -16,236 +38,1634 @@ type Table[E any] struct {
 	orderLess    func(e1, e2 *E) bool
 	deepCopy     func(dst *E, src *E)
 	data         []*E
-	version      int
+	version      atomic.Int64
 	delayedWrite *delayHandler[E]
+	shutdown     *shutdown
+	subscribers  []*subscriber[E]
+	hooks        hooks[E]
+	debug        bool
+	wal          *os.File
+	walDirty     bool
+	clock        clock
+	matchCache   map[string]cachedMatch
+	batch        *WriteBatch
+	batchFiles   map[string]bool
+	lockStats    *lockStats
+	migration    func(*E) error
+	maxSize      int
+	appendOnly   bool
+	relaxedReads bool
+	revisions    map[*E]uint64
+	pruneLimit   int
+	needsID      func(*E) bool
+	assignID     func(*E)
+}
+
+// cachedMatch is a result of MatchCached together with the version of the
+// table it was created from.
+type cachedMatch struct {
+	tableIndex []int
+	version    int
+}
+
+// Version returns the version of the table. The version is incremented by
+// every operation that adds, removes or reorders elements: inserts,
+// deletions, Move, Replace, ReplaceFile, Reindex and the eviction of elements
+// exceeding the maximum size. Updates of single elements in place, as done by
+// Result.Update, Result.Modify, UpdateMatch and UpdateToken, do not change it;
+// use a Token to detect those. The version never decreases: an operation
+// which is rolled back because the write failed still increments it. No lock
+// is required to read the version.
+func (t *Table[E]) Version() int {
+	return int(t.version.Load())
 }
 
 // Size returns the number of elements in the table.
 func (t *Table[E]) Size() int {
-	t.m.Lock()
+	t.lock(LockRead)
 	defer t.m.Unlock()
 
 	return len(t.data)
 }
 
-// Insert adds a new element to the table.
+// Insert adds a new element to the table. If the file could not be written,
+// the element is not inserted.
 func (t *Table[E]) Insert(e *E) error {
-	t.m.Lock()
+	_, err := t.insertIf(e, nil)
+	return err
+}
+
+// InsertVal works like Insert, but takes the element by value. The table
+// stores a deep copy, so later changes to the value do not affect the table.
+func (t *Table[E]) InsertVal(e E) error {
+	return t.Insert(&e)
+}
+
+// InsertUnique adds a new element to the table if there is no element which
+// is equal to it according to the equal function. It returns true if the
+// element was inserted. If the table is sorted, equal is only called for the
+// elements which are neither less nor greater than the new element, so equal
+// elements must not differ in sort order. Otherwise, all elements are checked.
+// The same restrictions as for the accept function of Match apply to equal.
+func (t *Table[E]) InsertUnique(e *E, equal func(a, b *E) bool) (bool, error) {
+	return t.insertIf(e, equal)
+}
+
+// insertIf inserts the element. If equal is not nil, the element is only
+// inserted if there is no equal element in the table.
+func (t *Table[E]) insertIf(e *E, equal func(a, b *E) bool) (bool, error) {
+	t.lock(LockInsert)
+	defer t.m.Unlock()
+
+	var deepCopy E
+	err := t.safeCopy(&deepCopy, e)
+	if err != nil {
+		return false, err
+	}
+	if t.hooks.beforeInsert != nil {
+		err := t.hooks.beforeInsert(&deepCopy)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if equal != nil && t.contains(&deepCopy, equal) {
+		return false, nil
+	}
+	if t.appendOnly && t.orderLess != nil && len(t.data) > 0 && t.orderLess(&deepCopy, t.data[len(t.data)-1]) {
+		return false, fmt.Errorf("insert: element is less than the last element: %w", ErrAppendOnly)
+	}
+	assigned := false
+	if t.needsID != nil && t.needsID(&deepCopy) {
+		t.assignID(&deepCopy)
+		assigned = true
+	}
+
+	err = t.logWAL(walRecord[E]{Op: walInsert, New: &deepCopy})
+	if err != nil {
+		return false, err
+	}
+	err = t.insert(&deepCopy)
+	if err != nil {
+		return false, err
+	}
+	t.version.Add(1)
+	err = t.commit(OpInsert, &deepCopy, func() {
+		t.remove(slices.Index(t.data, &deepCopy))
+	})
+	if err == nil || t.delayedWrite != nil {
+		// the element was inserted, there was no rollback
+		evictErr := t.evict()
+		if err == nil {
+			err = evictErr
+		}
+		if assigned {
+			copyErr := t.safeCopy(e, &deepCopy)
+			if err == nil {
+				err = copyErr
+			}
+		}
+	}
+	return true, t.checkOrder(err, &deepCopy)
+}
+
+// evict removes the first elements of the table, which are the smallest ones
+// if the table is sorted, if the table contains more elements than allowed by
+// SetMaxSize. The caller has to hold the lock.
+func (t *Table[E]) evict() error {
+	if t.maxSize <= 0 || len(t.data) <= t.maxSize || t.appendOnly {
+		return nil
+	}
+
+	evicted := slices.Clone(t.data[:len(t.data)-t.maxSize])
+	for _, e := range evicted {
+		err := t.logWAL(walRecord[E]{Op: walDelete, Old: e})
+		if err != nil {
+			return err
+		}
+	}
+	t.data = slices.Delete(t.data, 0, len(evicted))
+	t.version.Add(1)
+
+	err := t.persistFiles(t.filesOf(evicted))
+	for _, e := range evicted {
+		t.publish(OpDelete, e)
+	}
+	return err
+}
+
+// SetMaxSize limits the number of elements in the table to n. If an Insert or
+// InsertUnique exceeds the limit, the first elements of the table are evicted,
+// which are the smallest ones if the table is sorted. This turns the table
+// into a rolling window. The files of the evicted elements are persisted, and
+// the subscribers are notified about the deletion, but the lifecycle hooks are
+// not called. Other operations like Replace or Move do not evict elements. If
+// the table already contains more than n elements, they are evicted at once,
+// and an error is returned if the files could not be written. If n is 0,
+// there is no limit. This is the default.
+func (t *Table[E]) SetMaxSize(n int) error {
+	t.lock(LockWrite)
+	defer t.m.Unlock()
+
+	t.maxSize = n
+	err := t.evict()
+	if err != nil {
+		return fmt.Errorf("set max size: %w", err)
+	}
+	return nil
+}
+
+// contains returns true if there is an element equal to e. The caller has to
+// hold the lock.
+func (t *Table[E]) contains(e *E, equal func(a, b *E) bool) bool {
+	if t.orderLess == nil {
+		for _, en := range t.data {
+			if equal(en, e) {
+				return true
+			}
+		}
+		return false
+	}
+
+	i := sort.Search(len(t.data), func(i int) bool {
+		return !t.orderLess(t.data[i], e)
+	})
+	for ; i < len(t.data) && !t.orderLess(e, t.data[i]); i++ {
+		if equal(t.data[i], e) {
+			return true
+		}
+	}
+	return false
+}
+
+// insert inserts the element at the correct position. An element which is
+// equal in sort order to existing elements is inserted behind them, so that
+// equal elements keep their insertion order. The caller has to hold the lock.
+func (t *Table[E]) insert(e *E) error {
+	if t.orderLess == nil || len(t.data) == 0 || !t.orderLess(e, t.data[len(t.data)-1]) {
+		t.data = append(t.data, e)
+		return nil
+	}
+
+	for i, en := range t.data {
+		if t.orderLess(e, en) {
+			t.data = slices.Insert(t.data, i, e)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("impossible insert state: element of file %s is less than the last of %d elements, but not less than any element; the less function is not a consistent ordering", t.fileName(e), len(t.data))
+}
+
+// remove removes the element at the given index. The caller has to hold the
+// lock.
+func (t *Table[E]) remove(index int) {
+	copy(t.data[index:], t.data[index+1:])
+	t.data[len(t.data)-1] = nil
+	t.data = t.data[:len(t.data)-1]
+}
+
+// Move replaces the first element that matches the match function by the
+// given element. In contrast to Result.Update, the new element may have a
+// different position in the table, so that the field the table is sorted by
+// can be changed. The element is deep copied. The files containing the old
+// and the new element are persisted. For the hooks and the subscribers this
+// is an update. The same restrictions as for the accept function of Match
+// apply to the match function. If a file could not be written, the element
+// is moved nevertheless and the error is returned.
+func (t *Table[E]) Move(match func(*E) bool, e *E) error {
+	if t.appendOnly {
+		return fmt.Errorf("move: %w", ErrAppendOnly)
+	}
+
+	t.lock(LockUpdate)
 	defer t.m.Unlock()
 
+	index := -1
+	for i, en := range t.data {
+		if match(en) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return errors.New("move: no matching element found")
+	}
+
 	var deepCopy E
-	t.deepCopy(&deepCopy, e)
-	if t.orderLess == nil || len(t.data) == 0 || (t.orderLess != nil && t.orderLess(t.data[len(t.data)-1], &deepCopy)) {
-		t.data = append(t.data, &deepCopy)
-		t.version++
-		return t.persistItem(&deepCopy)
+	err := t.safeCopy(&deepCopy, e)
+	if err != nil {
+		return fmt.Errorf("move: %w", err)
+	}
+	if t.hooks.beforeUpdate != nil {
+		err := t.hooks.beforeUpdate(&deepCopy)
+		if err != nil {
+			return err
+		}
+	}
+
+	old := t.data[index]
+	err = t.logWAL(walRecord[E]{Op: walUpdate, Old: old, New: &deepCopy})
+	if err != nil {
+		return err
+	}
+	t.remove(index)
+	err = t.insert(&deepCopy)
+	if err != nil {
+		t.data = append(t.data, nil)
+		copy(t.data[index+1:], t.data[index:])
+		t.data[index] = old
+		return fmt.Errorf("move: %w", err)
+	}
+	t.version.Add(1)
+
+	var names []string
+	if t.persist != nil {
+		names = append(names, t.nameProvider.ToFile(old))
+		if n := t.nameProvider.ToFile(&deepCopy); n != names[0] {
+			names = append(names, n)
+		}
+	}
+	err = t.persistFiles(names)
+	t.publish(OpUpdate, &deepCopy)
+	t.hooks.after(OpUpdate, &deepCopy)
+	return t.checkOrder(err, &deepCopy)
+}
+
+// fileName returns the file name of the element used in error messages
+func (t *Table[E]) fileName(e *E) string {
+	if t.nameProvider == nil {
+		return "<none>"
+	}
+	return t.nameProvider.ToFile(e)
+}
+
+// DebugChecks enables additional consistency checks which are expensive and
+// are intended to be used during development. If enabled, after each insert
+// it is checked that the table is still sorted, and for each modification it
+// is checked that SameFile and ToFile of the NameProvider agree. Also, the less
+// function passed to Result.Order is called with deep copies of the elements.
+func (t *Table[E]) DebugChecks() {
+	t.lock(LockConfig)
+	defer t.m.Unlock()
+
+	t.debug = true
+}
+
+// checkOrder checks if the table is still sorted, if the debug checks are
+// enabled. If err is not nil, it is returned unchanged. The caller has to hold
+// the lock.
+func (t *Table[E]) checkOrder(err error, e *E) error {
+	if err != nil || !t.debug || t.orderLess == nil {
+		return err
+	}
+	for i := 1; i < len(t.data); i++ {
+		if t.orderLess(t.data[i], t.data[i-1]) {
+			return fmt.Errorf("insert: table not sorted at index %d after inserting element of file %s; the less function is not a consistent ordering", i, t.fileName(e))
+		}
+	}
+	return nil
+}
+
+// delete deletes the element at the given index. It returns the version of
+// the table after the call and whether the element was deleted. If the delete
+// was rolled back, the element is back at the given index but the version is
+// still incremented. An error of an earlier delayed write is returned even
+// if the element was deleted.
+func (t *Table[E]) delete(index int, version int) (int, bool, error) {
+	if t.appendOnly {
+		return version, false, fmt.Errorf("delete: %w", ErrAppendOnly)
+	}
+
+	t.lock(LockDelete)
+	defer t.m.Unlock()
+
+	if t.Version() != version {
+		return version, false, fmt.Errorf("delete: %w", ErrVersionChanged)
+	}
+
+	e := t.data[index]
+	if t.hooks.beforeDelete != nil {
+		err := t.hooks.beforeDelete(e)
+		if err != nil {
+			return version, false, err
+		}
+	}
+
+	err := t.logWAL(walRecord[E]{Op: walDelete, Old: e})
+	if err != nil {
+		return version, false, err
+	}
+	t.remove(index)
+	t.version.Add(1)
+	deleted := true
+	err = t.commit(OpDelete, e, func() {
+		// the file still contains the element
+		t.data = slices.Insert(t.data, index, e)
+		deleted = false
+	})
+	return t.Version(), deleted, err
+}
+
+// deleteAll deletes the elements at the given sorted and distinct indices.
+// Each affected file is persisted only once. The returned version is used
+// as described for delete.
+func (t *Table[E]) deleteAll(indices []int, version int) (int, error) {
+	if t.appendOnly {
+		return version, fmt.Errorf("delete: %w", ErrAppendOnly)
+	}
+
+	t.lock(LockDelete)
+	defer t.m.Unlock()
+
+	if t.Version() != version {
+		return version, fmt.Errorf("delete: %w", ErrVersionChanged)
+	}
+
+	deleted := make([]*E, len(indices))
+	for i, index := range indices {
+		deleted[i] = t.data[index]
+	}
+	if t.hooks.beforeDelete != nil {
+		for _, e := range deleted {
+			err := t.hooks.beforeDelete(e)
+			if err != nil {
+				return version, err
+			}
+		}
+	}
+	for _, e := range deleted {
+		err := t.logWAL(walRecord[E]{Op: walDelete, Old: e})
+		if err != nil {
+			return version, err
+		}
+	}
+
+	data := t.data[:0]
+	j := 0
+	for i, e := range t.data {
+		if j < len(indices) && indices[j] == i {
+			j++
+		} else {
+			data = append(data, e)
+		}
+	}
+	clear(t.data[len(data):])
+	t.data = data
+	t.version.Add(1)
+
+	err := t.persistFiles(t.filesOf(deleted))
+	for _, e := range deleted {
+		t.publish(OpDelete, e)
+		t.hooks.after(OpDelete, e)
+	}
+	return t.Version(), err
+}
+
+func (t *Table[E]) update(index int, version int, e *E) error {
+	t.lock(LockUpdate)
+	defer t.m.Unlock()
+
+	if t.Version() != version {
+		return fmt.Errorf("update: %w", ErrVersionChanged)
+	}
+
+	return t.updateAt(index, e)
+}
+
+// modify applies the mutate function to a copy of the element at the given
+// index and updates the element with the copy.
+func (t *Table[E]) modify(index int, version int, mutate func(*E)) error {
+	t.lock(LockUpdate)
+	defer t.m.Unlock()
+
+	if t.Version() != version {
+		return fmt.Errorf("modify: %w", ErrVersionChanged)
+	}
+
+	var e E
+	err := t.safeCopy(&e, t.data[index])
+	if err != nil {
+		return fmt.Errorf("modify: %w", err)
 	}
+	mutate(&e)
+	return t.updateAt(index, &e)
+}
+
+// UpdateMatch updates the first element that matches the match function with
+// the given element. As with Result.Update, the update must not change the
+// position of the element in the sort order, otherwise ErrOrderViolation is
+// returned. Because the element is searched and updated while the table is
+// locked, ErrVersionChanged can not occur. It returns true if an element was
+// updated and false if no matching element was found or an error occurred.
+// The same restrictions as for the accept function of Match apply to match.
+func (t *Table[E]) UpdateMatch(match func(*E) bool, e *E) (bool, error) {
+	t.lock(LockUpdate)
+	defer t.m.Unlock()
 
 	for i, en := range t.data {
-		if t.orderLess(&deepCopy, en) {
-			t.data = append(t.data, &deepCopy)
-			copy(t.data[i+1:], t.data[i:])
-			t.data[i] = &deepCopy
-			t.version++
-			return t.persistItem(&deepCopy)
+		if match(en) {
+			err := t.updateAt(i, e)
+			return err == nil, err
+		}
+	}
+	return false, nil
+}
+
+// UpdateMatchVal works like UpdateMatch, but takes the element by value.
+func (t *Table[E]) UpdateMatchVal(match func(*E) bool, e E) (bool, error) {
+	return t.UpdateMatch(match, &e)
+}
+
+// updateAt updates the element at the given index. The caller has to hold the
+// lock.
+func (t *Table[E]) updateAt(index int, e *E) error {
+	if t.appendOnly {
+		return fmt.Errorf("update: %w", ErrAppendOnly)
+	}
+	if t.orderLess != nil {
+		// elements which are equal in sort order can stay where they are
+		ok1 := index == 0 || !t.orderLess(e, t.data[index-1])
+		ok2 := index == len(t.data)-1 || !t.orderLess(t.data[index+1], e)
+		if !ok1 || !ok2 {
+			return fmt.Errorf("update: %w", ErrOrderViolation)
+		}
+	}
+	if t.hooks.beforeUpdate != nil {
+		err := t.hooks.beforeUpdate(e)
+		if err != nil {
+			return err
+		}
+	}
+	var n E
+	err := t.safeCopy(&n, e)
+	if err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+	err = t.logWAL(walRecord[E]{Op: walUpdate, Old: t.data[index], New: e})
+	if err != nil {
+		return err
+	}
+	// the copy is owned by the table, so a plain assignment is sufficient
+	old := *t.data[index]
+	*t.data[index] = n
+	t.bumpRevision(t.data[index])
+	// an update does not change the version, but the element may not match
+	// anymore
+	clear(t.matchCache)
+
+	return t.commit(OpUpdate, t.data[index], func() {
+		*t.data[index] = old
+		t.revisions[t.data[index]]--
+	})
+}
+
+// Replace replaces all elements of the table by the given elements. The
+// elements are deep copied. The table is modified in a single step, so there
+// is no point in time where the table is empty. All files which contain
+// elements of the old or the new set are written, and files which contain no
+// elements anymore are removed. The subscribers are notified about the
+// deletion of all old and the insertion of all new elements, but the
+// lifecycle hooks are not called. A failed write is not rolled back, the
+// table contains the new elements even if an error is returned.
+func (t *Table[E]) Replace(es []*E) error {
+	if t.appendOnly {
+		return fmt.Errorf("replace: %w", ErrAppendOnly)
+	}
+
+	data := make([]*E, len(es))
+	for i, e := range es {
+		var c E
+		err := t.safeCopy(&c, e)
+		if err != nil {
+			return fmt.Errorf("replace: %w", err)
+		}
+		data[i] = &c
+	}
+	if t.orderLess != nil {
+		sort.SliceStable(data, func(i, j int) bool {
+			return t.orderLess(data[i], data[j])
+		})
+	}
+
+	t.lock(LockReplace)
+	defer t.m.Unlock()
+
+	var names []string
+	if t.persist != nil {
+		files := map[string]bool{}
+		for _, e := range t.data {
+			files[t.nameProvider.ToFile(e)] = true
+		}
+		for _, e := range data {
+			files[t.nameProvider.ToFile(e)] = true
+		}
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	err := t.logWAL(walRecord[E]{Op: walReplace, All: data})
+	if err != nil {
+		return err
+	}
+	old := t.data
+	t.data = data
+	t.version.Add(1)
+
+	for _, e := range old {
+		t.publish(OpDelete, e)
+	}
+	for _, e := range data {
+		t.publish(OpInsert, e)
+	}
+
+	return t.persistFiles(names)
+}
+
+// ReplaceFile replaces all elements stored in the given file by the given
+// elements. The elements are deep copied and all of them have to be stored in
+// this file, otherwise an error is returned. The elements of all other files
+// are not touched, and only the given file is written. As with Replace, the
+// subscribers are notified, but the lifecycle hooks are not called, and the
+// elements are replaced even if the file could not be written.
+func (t *Table[E]) ReplaceFile(fileName string, es []*E) error {
+	if t.appendOnly {
+		return fmt.Errorf("replace file: %w", ErrAppendOnly)
+	}
+	if t.nameProvider == nil {
+		return errors.New("replace file: the table is not persisted")
+	}
+	data := make([]*E, len(es))
+	for i, e := range es {
+		var c E
+		err := t.safeCopy(&c, e)
+		if err != nil {
+			return fmt.Errorf("replace file: %w", err)
+		}
+		if name := t.nameProvider.ToFile(&c); name != fileName {
+			return fmt.Errorf("replace file: element %d belongs to file %s, not to %s", i, name, fileName)
+		}
+		data[i] = &c
+	}
+
+	t.lock(LockReplace)
+	defer t.m.Unlock()
+
+	err := t.logWAL(walRecord[E]{Op: walReplace, File: fileName, All: data})
+	if err != nil {
+		return err
+	}
+
+	var old []*E
+	kept := make([]*E, 0, len(t.data)+len(data))
+	for _, e := range t.data {
+		if t.nameProvider.ToFile(e) == fileName {
+			old = append(old, e)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	t.data = append(kept, data...)
+	if t.orderLess != nil {
+		sort.SliceStable(t.data, func(i, j int) bool {
+			return t.orderLess(t.data[i], t.data[j])
+		})
+	}
+	t.version.Add(1)
+
+	for _, e := range old {
+		t.publish(OpDelete, e)
+	}
+	for _, e := range data {
+		t.publish(OpInsert, e)
+	}
+
+	return t.persistFiles([]string{fileName})
+}
+
+// SyncFileGroup makes the given file contain exactly the given elements,
+// regardless of whether the file already exists. It is intended for jobs
+// which regularly import complete files from another source. If fileName is
+// empty, the file is determined by the first element. All elements have to
+// belong to the same file. Apart from that, it behaves like ReplaceFile.
+func (t *Table[E]) SyncFileGroup(fileName string, es []*E) error {
+	if fileName == "" {
+		if len(es) == 0 {
+			return errors.New("sync file group: neither a file name nor elements given")
+		}
+		if t.nameProvider == nil {
+			return errors.New("sync file group: the table is not persisted")
+		}
+		fileName = t.nameProvider.ToFile(es[0])
+	}
+	return t.ReplaceFile(fileName, es)
+}
+
+// Reindex restores all elements from the files again and replaces the elements
+// of the table by them. This allows to pick up modifications made to the files
+// outside the application. If a write delay is set, the pending writes are
+// done first, which overwrites the external modifications of these files, and
+// the WAL is truncated. The
+// migration function passed to New is applied again. If the files could not be
+// restored, the table is left unchanged. If some files were skipped, the
+// remaining elements are used and an error wrapping the SkippedFilesError is
+// returned. As with Replace, the subscribers are notified, but the lifecycle
+// hooks are not called.
+func (t *Table[E]) Reindex() error {
+	if t.persist == nil {
+		return errors.New("reindex: the table is not persisted")
+	}
+
+	t.lock(LockReplace)
+	defer t.m.Unlock()
+
+	err := t.flushLocked()
+	if err != nil {
+		return fmt.Errorf("reindex: %w", err)
+	}
+
+	data, err := restore(t.persist, t.nameProvider, t.migration)
+	var skipped *SkippedFilesError
+	if err != nil && !errors.As(err, &skipped) {
+		return fmt.Errorf("reindex: %w", err)
+	}
+	if t.orderLess != nil {
+		sort.SliceStable(data, func(i, j int) bool {
+			return t.orderLess(data[i], data[j])
+		})
+	}
+
+	old := t.data
+	t.data = data
+	t.version.Add(1)
+
+	for _, e := range old {
+		t.publish(OpDelete, e)
+	}
+	for _, e := range data {
+		t.publish(OpInsert, e)
+	}
+	return err
+}
+
+// All calls the yield function for each element in the table. No long-running
+// operations should be done in the yield function, as the table is locked during
+// the call. The elements are deep copied before the yield function is called.
+// A panic of the deepCopy function is passed on to the caller, but the table
+// stays usable. Use Match and Result.Iter to receive it as an error instead.
+func (t *Table[E]) All(yield func(*E) bool) {
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	for _, en := range t.data {
+		var e E
+		t.deepCopy(&e, en)
+		if !yield(&e) {
+			break
+		}
+	}
+}
+
+// AllReverse calls the yield function for each element in the table in
+// reverse order. If the table is sorted, the elements are passed from the
+// greatest to the least. The same restrictions as for All apply.
+func (t *Table[E]) AllReverse(yield func(*E) bool) {
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	for i := len(t.data) - 1; i >= 0; i-- {
+		var e E
+		t.deepCopy(&e, t.data[i])
+		if !yield(&e) {
+			break
+		}
+	}
+}
+
+// Match returns a Result that contains all elements that match the accept
+// function. For performance reasons, the accept function is called with the not
+// yet deep copied elements. So the accept function is not allowed to modify the
+// elements. No long-running operations should be done in the accept function,
+// because the table is locked during the call.
+func (t *Table[E]) Match(accept func(*E) bool) Result[E] {
+	query := func() []int { return t.matchIndices(accept, 0) }
+
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	return newResult(query(), t, query)
+}
+
+// MatchN works like Match, but stops after n matching elements are found. So
+// if the table is sorted, the Result contains the first n matching elements in
+// sort order. If n is zero or negative, all matching elements are returned.
+func (t *Table[E]) MatchN(n int, accept func(*E) bool) Result[E] {
+	query := func() []int { return t.matchIndices(accept, n) }
+
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	return newResult(query(), t, query)
+}
+
+// matchIndices returns the indices of the first n elements that match the
+// accept function. If n is zero or negative, all matching elements are
+// returned. The caller has to hold the lock.
+func (t *Table[E]) matchIndices(accept func(*E) bool, n int) []int {
+	var m []int
+	for i, en := range t.data {
+		if accept(en) {
+			m = append(m, i)
+			if len(m) == n {
+				break
+			}
+		}
+	}
+	return m
+}
+
+// MatchCached works like Match, but the matching indices are cached under the
+// given key. As long as the table is not modified, a further call with the
+// same key returns the cached result without calling the accept function.
+// Every modification of the table, including updates, invalidates the cache.
+// The caller has to
+// make sure that the same key is always used with an equivalent accept
+// function.
+func (t *Table[E]) MatchCached(key string, accept func(*E) bool) Result[E] {
+	query := func() []int { return t.matchIndices(accept, 0) }
+
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	if c, ok := t.matchCache[key]; ok && c.version == t.Version() {
+		// the result modifies its indices on delete, so a copy is required
+		m := make([]int, len(c.tableIndex))
+		copy(m, c.tableIndex)
+		return newResult(m, t, query)
+	}
+
+	m := query()
+
+	if t.matchCache == nil {
+		t.matchCache = map[string]cachedMatch{}
+	}
+	for k, c := range t.matchCache {
+		if c.version != t.Version() {
+			delete(t.matchCache, k)
+		}
+	}
+	c := make([]int, len(m))
+	copy(c, m)
+	t.matchCache[key] = cachedMatch{tableIndex: c, version: t.Version()}
+
+	return newResult(m, t, query)
+}
+
+// Range returns a Result that contains all elements e with low <= e < high
+// according to the order of the table. If low is nil, the range starts at the
+// first element; if high is nil, the range ends at the last element. The
+// bounds are found by a binary search, so this is much faster than a Match
+// with a corresponding accept function. If accept functions are given, only
+// the elements in the range that are accepted by all of them are included.
+// The same restrictions as for the accept function of Match apply. An error
+// is returned if the table is not sorted because no less function was given
+// to New.
+func (t *Table[E]) Range(low, high *E, accept ...func(*E) bool) (Result[E], error) {
+	low, high = t.copyOf(low), t.copyOf(high)
+	query := func() []int { return t.rangeIndices(low, high, accept) }
+
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	if t.orderLess == nil {
+		return Result[E]{}, errors.New("range: table is not sorted")
+	}
+
+	return newResult(query(), t, query), nil
+}
+
+// rangeIndices returns the indices of the elements in the given range which
+// are accepted by all accept functions. The caller has to hold the lock.
+func (t *Table[E]) rangeIndices(low, high *E, accept []func(*E) bool) []int {
+	start := 0
+	if low != nil {
+		start = sort.Search(len(t.data), func(i int) bool {
+			return !t.orderLess(t.data[i], low)
+		})
+	}
+	end := len(t.data)
+	if high != nil {
+		end = sort.Search(len(t.data), func(i int) bool {
+			return !t.orderLess(t.data[i], high)
+		})
+	}
+
+	var m []int
+	for i := start; i < end; i++ {
+		ok := true
+		for _, a := range accept {
+			if !a(t.data[i]) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			m = append(m, i)
+		}
+	}
+	return m
+}
+
+// After returns a Result containing up to n elements which are greater than
+// last according to the order of the table. If last is nil, the first n
+// elements are returned. Passing the last element of a page as last to the
+// next call allows to page through the table. Because the position is
+// determined by the content of last and not by an offset, this also works if
+// elements are inserted or deleted between the calls. An error is returned if
+// the table is not sorted because no less function was given to New.
+func (t *Table[E]) After(last *E, n int) (Result[E], error) {
+	last = t.copyOf(last)
+	query := func() []int { return t.afterIndices(last, n) }
+
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	if t.orderLess == nil {
+		return Result[E]{}, errors.New("after: table is not sorted")
+	}
+
+	return newResult(query(), t, query), nil
+}
+
+// afterIndices returns the indices of up to n elements which are greater than
+// last. The caller has to hold the lock.
+func (t *Table[E]) afterIndices(last *E, n int) []int {
+	start := 0
+	if last != nil {
+		start = sort.Search(len(t.data), func(i int) bool {
+			return t.orderLess(last, t.data[i])
+		})
+	}
+	end := min(start+max(n, 0), len(t.data))
+
+	var m []int
+	for i := start; i < end; i++ {
+		m = append(m, i)
+	}
+	return m
+}
+
+// copyOf returns a deep copy of the element, or nil if e is nil.
+func (t *Table[E]) copyOf(e *E) *E {
+	if e == nil {
+		return nil
+	}
+	var c E
+	t.deepCopy(&c, e)
+	return &c
+}
+
+// First returns the first element that matches the accept function. For
+// performance reasons, the accept function is called with the not yet deep
+// copied elements. So the accept function is not allowed to modify the elements.
+// No long-running operations should be done in the accept function, because the
+// table is locked during the call.
+func (t *Table[E]) First(dst *E, accept func(*E) bool) bool {
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	for _, en := range t.data {
+		if accept(en) {
+			t.deepCopy(dst, en)
+			return true
+		}
+	}
+	return false
+}
+
+// At copies the element at position i to dst. If the table is sorted, this is
+// the i-th element in sort order. If i is out of range, false is returned.
+// In contrast to Result.Get, no version check is done, and the positions of
+// the elements shift as the table is modified.
+func (t *Table[E]) At(dst *E, i int) bool {
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	if i < 0 || i >= len(t.data) {
+		return false
+	}
+	t.deepCopy(dst, t.data[i])
+	return true
+}
+
+// Position returns the index at which the element would be inserted by
+// Insert. The position is determined by a binary search. If the element is
+// not less than the greatest element, the size of the table is returned. If
+// the table is not sorted, new elements are appended, so the size of the
+// table is returned as well.
+func (t *Table[E]) Position(e *E) int {
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	if t.orderLess == nil {
+		return len(t.data)
+	}
+	return sort.Search(len(t.data), func(i int) bool {
+		return t.orderLess(e, t.data[i])
+	})
+}
+
+// Min copies the least element of a sorted table to dst. If the table is
+// empty or not sorted, false is returned.
+func (t *Table[E]) Min(dst *E) bool {
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	if t.orderLess == nil || len(t.data) == 0 {
+		return false
+	}
+	t.deepCopy(dst, t.data[0])
+	return true
+}
+
+// Max copies the greatest element of a sorted table to dst. If the table is
+// empty or not sorted, false is returned.
+func (t *Table[E]) Max(dst *E) bool {
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	if t.orderLess == nil || len(t.data) == 0 {
+		return false
+	}
+	t.deepCopy(dst, t.data[len(t.data)-1])
+	return true
+}
+
+// Exists returns true if there is an element that matches the accept
+// function. In contrast to First, no element is copied. The accept function
+// is not allowed to modify the elements. No long-running operations should be
+// done in the accept function, because the table is locked during the call.
+func (t *Table[E]) Exists(accept func(*E) bool) bool {
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	for _, en := range t.data {
+		if accept(en) {
+			return true
+		}
+	}
+	return false
+}
+
+// FirstVal works like First, but returns a deep copy of the element found.
+func (t *Table[E]) FirstVal(accept func(*E) bool) (E, bool) {
+	var e E
+	ok := t.First(&e, accept)
+	return e, ok
+}
+
+func (t *Table[E]) copy(dest *E, n, version int) error {
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	return t.copyAt(dest, n, version)
+}
+
+// copyAt copies the element at the given index. The caller has to hold the
+// lock.
+func (t *Table[E]) copyAt(dest *E, n, version int) error {
+	if n < 0 || n >= len(t.data) {
+		return fmt.Errorf("copy: %w", ErrIndexOutOfRange)
+	}
+
+	if t.Version() != version && !t.relaxedReads {
+		return fmt.Errorf("copy: %w", ErrVersionChanged)
+	}
+
+	err := t.safeCopy(dest, t.data[n])
+	if err != nil {
+		return fmt.Errorf("copy: %w", err)
+	}
+	return nil
+}
+
+// safeCopy calls the deepCopy function. If it panics, the panic is converted
+// into an error wrapping ErrDeepCopy.
+func (t *Table[E]) safeCopy(dst *E, src *E) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrDeepCopy, r)
+		}
+	}()
+	t.deepCopy(dst, src)
+	return nil
+}
+
+// commit persists the modified element, publishes the change to the
+// subscribers and calls the after hook. If the file could not be written
+// and rollback is not nil, rollback is called to undo the modification, and
+// the change is not published. If the write delay is active, an error
+// refers to an earlier write, so there is no rollback in this case. Only the
+// modifications of a single element are rolled back this way. Operations
+// which write several files, like Move, Replace, ReplaceFile and
+// Result.DeleteAll, keep the modification of the table if a write fails. The
+// caller has to hold the lock.
+func (t *Table[E]) commit(op Operation, e *E, rollback func()) error {
+	err := t.persistItem(op, e)
+	if err != nil && rollback != nil && t.delayedWrite == nil {
+		rollback()
+		return err
+	}
+	t.publish(op, e)
+	t.hooks.after(op, e)
+	return err
+}
+
+// persistItem persists the file containing the given element. If the element
+// was inserted and the persist is able to append it to the file, the file is
+// not rewritten.
+func (t *Table[E]) persistItem(op Operation, e *E) error {
+	if t.persist == nil {
+		return nil
+	}
+
+	name := t.nameProvider.ToFile(e)
+	if t.debug {
+		err := t.checkNameProvider(e, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.batch != nil {
+		t.batchFiles[name] = true
+		return nil
+	}
+
+	if t.delayedWrite == nil {
+		if a, ok := t.persist.(Appender[E]); ok && op == OpInsert {
+			err := a.Append(name, e)
+			if !errors.Is(err, ErrAppendNotSupported) {
+				return err
+			}
+		}
+
+		var p []*E
+		for _, en := range t.data {
+			if t.nameProvider.SameFile(en, e) {
+				p = append(p, en)
+			}
+		}
+		return t.persist.Persist(name, p)
+	} else {
+		return t.delayedWrite.modified(name)
+	}
+}
+
+// filesOf returns the sorted names of the files containing the given
+// elements. If the table is not persisted, nil is returned.
+func (t *Table[E]) filesOf(es []*E) []string {
+	if t.persist == nil {
+		return nil
+	}
+	files := map[string]bool{}
+	for _, e := range es {
+		files[t.nameProvider.ToFile(e)] = true
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkNameProvider checks if SameFile and ToFile of the name provider agree
+// for the given element and all elements in the table. The caller has to hold
+// the lock.
+func (t *Table[E]) checkNameProvider(e *E, name string) error {
+	for _, en := range t.data {
+		enName := t.nameProvider.ToFile(en)
+		if t.nameProvider.SameFile(en, e) != (enName == name) {
+			return fmt.Errorf("inconsistent name provider: SameFile and ToFile disagree for elements of the files %s and %s", name, enName)
+		}
+	}
+	return nil
+}
+
+// groupByFile returns the elements grouped by the file they are stored in.
+// The caller has to hold the lock.
+func (t *Table[E]) groupByFile() map[string][]*E {
+	files := map[string][]*E{}
+	for _, en := range t.data {
+		name := t.nameProvider.ToFile(en)
+		files[name] = append(files[name], en)
+	}
+	return files
+}
+
+func (t *Table[E]) order(tableIndex []int, less func(e1, e2 *E) bool, version int) ([]int, error) {
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	if t.Version() != version {
+		return nil, fmt.Errorf("order: %w", ErrVersionChanged)
+	}
+
+	return t.sortIndices(tableIndex, less), nil
+}
+
+// sortIndices returns a copy of the indices sorted by the less function. The
+// caller has to hold the lock.
+func (t *Table[E]) sortIndices(tableIndex []int, less func(e1, e2 *E) bool) []int {
+	elem := func(n int) *E { return t.data[n] }
+	if t.debug {
+		// the less function is called with copies, so that it can not modify
+		// the elements stored in the table
+		copies := make(map[int]*E, len(tableIndex))
+		for _, n := range tableIndex {
+			var e E
+			t.deepCopy(&e, t.data[n])
+			copies[n] = &e
+		}
+		elem = func(n int) *E { return copies[n] }
+	}
+
+	so := make([]int, len(tableIndex))
+	copy(so, tableIndex)
+	sort.SliceStable(so, func(i, j int) bool {
+		return less(elem(so[i]), elem(so[j]))
+	})
+	return so
+}
+
+// SetWriteDelay sets the delay in seconds for persisting changes to disk. If sec
+// is 0, changes are written immediately. This is the default. If sec is greater
+// than 0, changes are written after sec seconds of inactivity. If this method is
+// called, the Shutdown method must be called before the program exits, otherwise
+// changes may be lost. If the write delay is already active, only the delay is
+// changed and the pending changes are kept. If sec is 0, the pending changes
+// are written before the method returns, and a write error is logged.
+func (t *Table[E]) SetWriteDelay(sec int) {
+	t.lock(LockConfig)
+	dw := t.delayedWrite
+	if dw != nil && sec > 0 {
+		t.m.Unlock()
+		dw.setDelay(sec)
+		return
+	}
+	if sec > 0 {
+		t.delayedWrite = newDelayHandler[E](t, sec, t.clock)
+	} else {
+		t.delayedWrite = nil
+	}
+	t.m.Unlock()
+
+	if dw != nil {
+		// the lock is released, because the pending files are written
+		err := dw.shutdown()
+		if err != nil {
+			log.Println(err)
 		}
 	}
-
-	return errors.New("impossible insert state")
 }
 
-func (t *Table[E]) delete(index int, version int) error {
-	t.m.Lock()
+func (t *Table[E]) writeFiles(name string) error {
+	t.lock(LockWrite)
 	defer t.m.Unlock()
 
-	if t.version != version {
-		return fmt.Errorf("delete: table has changed")
-	}
-
-	e := t.data[index]
-	copy(t.data[index:], t.data[index+1:])
-	t.data[len(t.data)-1] = nil
-	t.data = t.data[:len(t.data)-1]
-	t.version++
-	return t.persistItem(e)
+	return t.writeFile(name)
 }
 
-func (t *Table[E]) update(index int, version int, e *E) error {
-	t.m.Lock()
-	defer t.m.Unlock()
+// writeFile writes the file with the given name. The caller has to hold the
+// lock.
+func (t *Table[E]) writeFile(name string) error {
+	list := make([]*E, 0)
+	for _, en := range t.data {
+		if t.nameProvider.ToFile(en) == name {
+			list = append(list, en)
+		}
+	}
+	err := t.persist.Persist(name, list)
+	if err != nil {
+		return err
+	}
+	return t.walWritten(name)
+}
 
-	if t.version != version {
-		return fmt.Errorf("update: table has changed")
+// persistFiles persists the files with the given names. If the write delay is
+// active, the files are only marked as modified. All files are processed, and
+// the first error is returned. The caller has to hold the lock.
+func (t *Table[E]) persistFiles(names []string) error {
+	if t.persist == nil {
+		return nil
 	}
 
-	if t.orderLess != nil {
-		ok1 := index == 0 || t.orderLess(t.data[index-1], e)
-		ok2 := index == len(t.data)-1 || t.orderLess(e, t.data[index+1])
-		if !ok1 || !ok2 {
-			return fmt.Errorf("update: order violation")
+	var firstErr error
+	for _, name := range names {
+		var err error
+		if t.batch != nil {
+			t.batchFiles[name] = true
+		} else if t.delayedWrite == nil {
+			err = t.writeFile(name)
+		} else {
+			err = t.delayedWrite.modified(name)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
 		}
 	}
-	t.deepCopy(t.data[index], e)
-
-	return t.persistItem(e)
+	return firstErr
 }
 
-// All calls the yield function for each element in the table. No long-running
-// operations should be done in the yield function, as the table is locked during
-// the call. The elements are deep copied before the yield function is called.
-func (t *Table[E]) All(yield func(*E) bool) {
-	t.m.Lock()
+// Files returns the sorted names of the files the elements of the table are
+// stored in. If the table is not persisted, nil is returned.
+func (t *Table[E]) Files() []string {
+	t.lock(LockRead)
 	defer t.m.Unlock()
 
+	if t.nameProvider == nil {
+		return nil
+	}
+
+	files := map[string]bool{}
 	for _, en := range t.data {
-		var e E
-		t.deepCopy(&e, en)
-		if !yield(&e) {
-			break
-		}
+		files[t.nameProvider.ToFile(en)] = true
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
 
-// Match returns a Result that contains all elements that match the accept
-// function. For performance reasons, the accept function is called with the not
-// yet deep copied elements. So the accept function is not allowed to modify the
-// elements. No long-running operations should be done in the accept function,
-// because the table is locked during the call.
-func (t *Table[E]) Match(accept func(*E) bool) Result[E] {
-	t.m.Lock()
+// FileModTimes returns the time each file containing elements of the table
+// was last written. This allows e.g. a synchronization to skip unchanged
+// files. A file with pending delayed writes or pending writes of a WriteBatch
+// is dirty and reported with the zero time. The Persist has to implement
+// Stater.
+func (t *Table[E]) FileModTimes() (map[string]time.Time, error) {
+	s, ok := t.persist.(Stater)
+	if !ok {
+		return nil, errors.New("file mod times: the persist does not implement Stater")
+	}
+
+	t.lock(LockRead)
 	defer t.m.Unlock()
 
-	var m []int
-	for i, en := range t.data {
-		if accept(en) {
-			m = append(m, i)
+	times := map[string]time.Time{}
+	for name := range t.groupByFile() {
+		if t.pending(name) {
+			times[name] = time.Time{}
+			continue
+		}
+		mt, err := s.Stat(name)
+		if err != nil {
+			return nil, fmt.Errorf("file mod times: %w", err)
 		}
+		times[name] = mt
 	}
-	return newResult(m, t)
+	return times, nil
 }
 
-// First returns the first element that matches the accept function. For
-// performance reasons, the accept function is called with the not yet deep
-// copied elements. So the accept function is not allowed to modify the elements.
-// No long-running operations should be done in the accept function, because the
-// table is locked during the call.
-func (t *Table[E]) First(dst *E, accept func(*E) bool) bool {
-	t.m.Lock()
+// ForEachFile calls the yield function for each file the elements of the
+// table are stored in, with deep copies of the elements stored in that file.
+// The files are processed in the order of their names. If yield returns
+// false, the iteration stops. The table is locked during the iteration. If
+// the table is not persisted, yield is not called.
+func (t *Table[E]) ForEachFile(yield func(name string, elems []*E) bool) {
+	t.lock(LockRead)
 	defer t.m.Unlock()
 
-	for _, en := range t.data {
-		if accept(en) {
-			t.deepCopy(dst, en)
-			return true
+	if t.nameProvider == nil {
+		return
+	}
+
+	files := t.groupByFile()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		elems := make([]*E, len(files[name]))
+		for i, en := range files[name] {
+			var e E
+			t.deepCopy(&e, en)
+			elems[i] = &e
+		}
+		if !yield(name, elems) {
+			return
 		}
 	}
-	return false
 }
 
-func (t *Table[E]) copy(dest *E, n, version int) error {
-	t.m.Lock()
+// Compact writes all files from the current state of the table. If the
+// Persist implements Lister, files which contain no elements anymore are
+// removed. This can be used to repair the files after a crash or to remove
+// files created by other means. All files are processed, and the first error
+// is returned.
+func (t *Table[E]) Compact() error {
+	t.lock(LockReplace)
 	defer t.m.Unlock()
 
-	if n < 0 || n >= len(t.data) {
-		return fmt.Errorf("copy: index out of range")
+	if t.persist == nil {
+		return nil
 	}
 
-	if t.version != version {
-		return fmt.Errorf("copy: table has changed")
+	files := t.groupByFile()
+	if l, ok := t.persist.(Lister); ok {
+		stored, err := l.List()
+		if err != nil {
+			return fmt.Errorf("compact: %w", err)
+		}
+		for _, name := range stored {
+			if _, ok := files[name]; !ok {
+				files[name] = nil
+			}
+		}
 	}
 
-	t.deepCopy(dest, t.data[n])
-
-	return nil
+	return t.writeGroups(files)
 }
 
-func (t *Table[E]) persistItem(e *E) error {
-	if t.persist == nil {
-		return nil
+// writeGroups writes the given elements to the files immediately, without
+// regard to a write delay. All files are processed, and the first error is
+// returned. The caller has to hold the lock.
+func (t *Table[E]) writeGroups(files map[string][]*E) error {
+	var names []string
+	for name := range files {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	if t.delayedWrite == nil {
-		var p []*E
-		for _, en := range t.data {
-			if t.nameProvider.SameFile(en, e) {
-				p = append(p, en)
-			}
+	var firstErr error
+	for _, name := range names {
+		err := t.persist.Persist(name, files[name])
+		if err == nil {
+			err = t.walWritten(name)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
 		}
-		name := t.nameProvider.ToFile(e)
-		return t.persist.Persist(name, p)
-	} else {
-		return t.delayedWrite.modified(t.nameProvider.ToFile(e))
 	}
+	return firstErr
 }
 
-func (t *Table[E]) order(tableIndex []int, less func(e1, e2 *E) bool, version int) ([]int, error) {
-	t.m.Lock()
+// Rebucket replaces the name provider of the table and stores all elements in
+// the files given by the new name provider. This allows e.g. to switch from
+// monthly to daily files. All new files are written before the old files
+// which are not used anymore are removed, so a file is never removed after it
+// was written, even if old and new file names overlap. If a new file could
+// not be written, the old name provider is restored and the old files are
+// written again. Pending delayed writes are done first. The elements are not
+// modified, so the version of the table does not change.
+func (t *Table[E]) Rebucket(newProvider NameProvider[E]) error {
+	if t.persist == nil {
+		return errors.New("rebucket: the table is not persisted")
+	}
+
+	t.lock(LockReplace)
 	defer t.m.Unlock()
 
-	if t.version != version {
-		return nil, fmt.Errorf("order: table has changed")
+	// the names of pending files refer to the old name provider
+	err := t.flushLocked()
+	if err != nil {
+		return fmt.Errorf("rebucket: %w", err)
 	}
 
-	so := make([]int, len(tableIndex))
-	copy(so, tableIndex)
-	sort.Slice(so, func(i, j int) bool {
-		return less(t.data[so[i]], t.data[so[j]])
-	})
-	return so, nil
-}
+	oldProvider := t.nameProvider
+	oldFiles := t.groupByFile()
+	t.nameProvider = newProvider
+	newFiles := t.groupByFile()
 
-// SetWriteDelay sets the delay in seconds for persisting changes to disk. If sec
-// is 0, changes are written immediately. This is the default. If sec is greater
-// than 0, changes are written after sec seconds of inactivity. If this method is
-// called, the Shutdown method must be called before the program exits, otherwise
-// changes may be lost.
-func (t *Table[E]) SetWriteDelay(sec int) {
-	t.m.Lock()
-	defer t.m.Unlock()
+	err = t.writeGroups(newFiles)
+	if err != nil {
+		t.nameProvider = oldProvider
+		rErr := t.writeGroups(oldFiles)
+		if rErr == nil {
+			rErr = t.writeGroups(unused(newFiles, oldFiles))
+		}
+		if rErr != nil {
+			return fmt.Errorf("rebucket: %w; the old files could not be restored: %w", err, rErr)
+		}
+		return fmt.Errorf("rebucket: %w", err)
+	}
 
-	if t.delayedWrite != nil {
-		t.delayedWrite.shutdown()
-		t.delayedWrite = nil
+	err = t.writeGroups(unused(oldFiles, newFiles))
+	if err != nil {
+		return fmt.Errorf("rebucket: could not remove old file: %w", err)
 	}
+	return nil
+}
 
-	if sec > 0 {
-		t.delayedWrite = newDelayHandler[E](t, sec)
+// unused returns the names of the files in files which are not contained in
+// keep, each mapped to no elements, so that writing them removes the files.
+func unused[E any](files, keep map[string][]*E) map[string][]*E {
+	u := map[string][]*E{}
+	for name := range files {
+		if _, ok := keep[name]; !ok {
+			u[name] = nil
+		}
 	}
+	return u
 }
 
-func (t *Table[E]) writeFiles(name string) error {
-	t.m.Lock()
-	defer t.m.Unlock()
+// Flush writes all pending changes to disk immediately. If the write delay
+// is not used, this method does nothing. In contrast to Shutdown, the write
+// delay stays active. If writing a file fails, the first error is returned
+// and the failed files are kept pending.
+func (t *Table[E]) Flush() error {
+	t.lock(LockRead)
+	dw := t.delayedWrite
+	t.m.Unlock()
 
-	list := make([]*E, 0)
-	for _, en := range t.data {
-		if t.nameProvider.ToFile(en) == name {
-			list = append(list, en)
+	if dw == nil {
+		return nil
+	}
+	err := dw.flush()
+	if err != nil {
+		return err
+	}
+	t.truncateWAL()
+	return nil
+}
+
+// flushLocked writes all pending changes to disk like Flush, but without
+// releasing the lock in between. So no other modification can take place
+// between the writing of the files and the operation of the caller, which
+// would get lost if the caller reads the files again. The caller has to hold
+// the lock.
+func (t *Table[E]) flushLocked() error {
+	dw := t.delayedWrite
+	if dw == nil {
+		return nil
+	}
+	var firstErr error
+	for _, name := range dw.pendingNames() {
+		err := t.writeFile(name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			dw.written(name, nil)
 		}
 	}
-	return t.persist.Persist(name, list)
+	if firstErr != nil {
+		return firstErr
+	}
+	t.clearWAL()
+	return nil
+}
+
+// Shutdown waits without a time limit until all changes are written to disk.
+//
+// Deprecated: Use ShutdownContext, which allows to limit the time to wait.
+func (t *Table[E]) Shutdown() error {
+	return t.ShutdownContext(context.Background())
+}
+
+// shutdown is a shutdown whose files are still written in the background
+// because the caller of ShutdownContext has stopped waiting.
+type shutdown struct {
+	done chan struct{}
+	// err is valid after done is closed
+	err error
 }
 
-// Shutdown must be called before the program exits, if write delay was used,
-// otherwise changes may be lost. It waits until all changes are written to disk.
-// If the write delay was not used, this method does nothing. After this method
-// is called, the table is still usable, but changes are written immediately.
-func (t *Table[E]) Shutdown() {
+// ShutdownContext must be called before the program exits, if write delay was
+// used, otherwise changes may be lost. It waits until all changes are written
+// to disk. If the write delay was not used, this method does nothing. After
+// this method is called, the table is still usable, but changes are written
+// immediately. If writing a file fails, the first error is returned. If the
+// context is done before all files are written, e.g. because a write blocks,
+// the context error is returned and the files are written in the background.
+// A further call of ShutdownContext then waits for this background write and
+// returns its error.
+func (t *Table[E]) ShutdownContext(ctx context.Context) error {
 	log.Println("shutdown table")
-	t.m.Lock()
+	t.lock(LockConfig)
 	dw := t.delayedWrite
 	t.delayedWrite = nil
+	prev := t.shutdown
+	s := &shutdown{done: make(chan struct{})}
+	t.shutdown = s
 	t.m.Unlock()
 
-	if dw != nil {
-		dw.shutdown()
+	go func() {
+		defer close(s.done)
+		if prev != nil {
+			<-prev.done
+			s.err = prev.err
+		}
+		if dw != nil {
+			err := dw.shutdown()
+			if s.err == nil {
+				s.err = err
+			}
+			if dw.pending() == 0 {
+				t.truncateWAL()
+			}
+		}
+		t.closeWAL()
+	}()
+
+	select {
+	case <-s.done:
+		t.lock(LockConfig)
+		if t.shutdown == s {
+			t.shutdown = nil
+		}
+		t.m.Unlock()
+		log.Println("table shutdown completed")
+		return s.err
+	case <-ctx.Done():
+		return fmt.Errorf("shutdown: %w", ctx.Err())
+	}
+}
+
+// LastWriteError returns the error of the last failed delayed write which was
+// not yet reported. Such an error is otherwise returned by the next
+// modification of the table. If the write delay is not used, nil is returned.
+func (t *Table[E]) LastWriteError() error {
+	t.lock(LockRead)
+	dw := t.delayedWrite
+	t.m.Unlock()
+
+	if dw == nil {
+		return nil
 	}
-	log.Println("table shutdown completed")
+	dw.m.Lock()
+	defer dw.m.Unlock()
+	return dw.lastError
+}
+
+// clock provides the time used by the delay handler. It allows the tests to
+// control the time.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
 }
 
 type delayHandler[E any] struct {
 	m         sync.Mutex
 	table     *Table[E]
+	clock     clock
 	sec       int
 	nameMap   map[string]time.Time
 	lastError error
@@ -253,11 +1673,12 @@ type delayHandler[E any] struct {
 	ack       chan struct{}
 }
 
-func newDelayHandler[E any](table *Table[E], sec int) *delayHandler[E] {
+func newDelayHandler[E any](table *Table[E], sec int, clock clock) *delayHandler[E] {
 	done := make(chan struct{})
 	ack := make(chan struct{})
 	dh := &delayHandler[E]{
 		table:   table,
+		clock:   clock,
 		sec:     sec,
 		nameMap: make(map[string]time.Time),
 		done:    done,
@@ -266,12 +1687,15 @@ func newDelayHandler[E any](table *Table[E], sec int) *delayHandler[E] {
 	go func() {
 		for {
 			select {
-			case <-time.After(time.Second * time.Duration(sec)):
+			case <-clock.After(dh.delay()):
 				names := dh.getModifiedNameList()
 				for _, name := range names {
 					err := dh.table.writeFiles(name)
 					dh.written(name, err)
 				}
+				if len(names) > 0 {
+					dh.table.truncateWAL()
+				}
 			case <-done:
 				close(ack)
 				return
@@ -282,11 +1706,28 @@ func newDelayHandler[E any](table *Table[E], sec int) *delayHandler[E] {
 	return dh
 }
 
+// delay returns the write delay.
+func (h *delayHandler[E]) delay() time.Duration {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	return time.Second * time.Duration(h.sec)
+}
+
+// setDelay changes the write delay. It takes effect for files modified from
+// now on and for the next check of the pending files.
+func (h *delayHandler[E]) setDelay(sec int) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.sec = sec
+}
+
 func (h *delayHandler[E]) modified(file string) error {
 	h.m.Lock()
 	defer h.m.Unlock()
 
-	h.nameMap[file] = time.Now().Add(time.Second * time.Duration(h.sec))
+	h.nameMap[file] = h.clock.Now().Add(time.Second * time.Duration(h.sec))
 	if h.lastError != nil {
 		err := h.lastError
 		h.lastError = nil
@@ -299,7 +1740,7 @@ func (h *delayHandler[E]) getModifiedNameList() []string {
 	h.m.Lock()
 	defer h.m.Unlock()
 
-	now := time.Now()
+	now := h.clock.Now()
 	var names []string
 	for name, t := range h.nameMap {
 		if now.After(t) {
@@ -320,40 +1761,255 @@ func (h *delayHandler[E]) written(name string, err error) {
 	}
 }
 
-func (h *delayHandler[E]) shutdown() {
+// isPending returns true if the given file has a pending write.
+func (h *delayHandler[E]) isPending(name string) bool {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	_, ok := h.nameMap[name]
+	return ok
+}
+
+// pendingNames returns the names of all files with a pending write.
+func (h *delayHandler[E]) pendingNames() []string {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	names := make([]string, 0, len(h.nameMap))
+	for name := range h.nameMap {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (h *delayHandler[E]) pending() int {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	return len(h.nameMap)
+}
+
+func (h *delayHandler[E]) flush() error {
+	var firstErr error
+	for _, name := range h.pendingNames() {
+		err := h.table.writeFiles(name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			h.m.Lock()
+			delete(h.nameMap, name)
+			h.m.Unlock()
+		}
+	}
+	return firstErr
+}
+
+// shutdown stops the delay handler and writes all pending files. The first
+// error is returned.
+func (h *delayHandler[E]) shutdown() error {
 	close(h.done)
 	<-h.ack
 
+	var firstErr error
 	for name := range h.nameMap {
 		err := h.table.writeFiles(name)
 		if err != nil {
 			log.Println(err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			delete(h.nameMap, name)
 		}
 	}
+	return firstErr
 }
 
 // New creates a new Table. The nameProvider is used to create a file name for
 // each element. The persist parameter is used to store the data on disk. The
 // deepCopy function is used to create a deep copy of an element. If nil, a
-// simple copy is used. The less function is used to sort the elements. If nil,
-// no sorting is done.
-func New[E any](nameProvider NameProvider[E], persist Persist[E], deepCopy func(dst *E, src *E), less func(e1, e2 *E) bool) (*Table[E], error) {
-	if deepCopy == nil {
-		deepCopy = func(dst *E, src *E) {
-			*dst = *src
-		}
+// simple copy is used. In this case an error is returned if the exported
+// fields of E contain pointers, slices, maps or interfaces, because a simple
+// copy would share this data with the caller. If the deepCopy function
+// panics, the methods returning an error return an error wrapping ErrDeepCopy,
+// the other methods pass the panic on. The table stays usable in both cases.
+// The less function is used to sort the elements. If nil, no sorting is done.
+// Elements which are equal in
+// sort order keep their insertion order. If the persist skips corrupt
+// files, see PersistSkipCorrupt, and files have been skipped, the table is
+// returned together with an error wrapping the *SkippedFilesError. Be aware
+// that a skipped file is overwritten as soon as an element belonging to it is
+// modified. Further options like WithMigration can be passed.
+func New[E any](nameProvider NameProvider[E], persist Persist[E], deepCopy func(dst *E, src *E), less func(e1, e2 *E) bool, opts ...Option[E]) (*Table[E], error) {
+	deepCopy, err := checkDeepCopy(deepCopy)
+	if err != nil {
+		return nil, err
+	}
+
+	o := options[E]{strictVersioning: true}
+	for _, opt := range opts {
+		opt(&o)
 	}
 
 	var e []*E
+	var restoreErr error
 	if persist != nil {
-		var err error
-		e, err = persist.Restore()
-		if err != nil {
+		e, restoreErr = restore(persist, nameProvider, o.migration)
+		var skipped *SkippedFilesError
+		if restoreErr != nil && !errors.As(restoreErr, &skipped) {
+			return nil, restoreErr
+		}
+	}
+
+	t := newTable(nameProvider, persist, deepCopy, less, e)
+	t.migration = o.migration
+	t.appendOnly = o.appendOnly
+	t.relaxedReads = !o.strictVersioning
+	t.needsID = o.needsID
+	t.assignID = o.assignID
+	if o.lockStats {
+		t.lockStats = &lockStats{stats: map[LockOp]LockStat{}}
+	}
+	return t, restoreErr
+}
+
+// restore restores the elements and applies the migration function to them.
+// If some files were skipped, the remaining elements are returned together
+// with an error wrapping the SkippedFilesError.
+func restore[E any](persist Persist[E], nameProvider NameProvider[E], migration func(*E) error) ([]*E, error) {
+	e, err := persist.Restore()
+	if err != nil {
+		var skipped *SkippedFilesError
+		if !errors.As(err, &skipped) {
 			return nil, fmt.Errorf("could not restore db: %w", err)
 		}
+		err = fmt.Errorf("could not restore db completely: %w", err)
+	}
+
+	if migration != nil {
+		for _, en := range e {
+			mErr := migration(en)
+			if mErr != nil {
+				return nil, fmt.Errorf("could not migrate element of file %s: %w", nameProvider.ToFile(en), mErr)
+			}
+		}
+	}
+	return e, err
+}
+
+// Option is an option which can be passed to New.
+type Option[E any] func(o *options[E])
+
+type options[E any] struct {
+	migration        func(*E) error
+	lockStats        bool
+	appendOnly       bool
+	strictVersioning bool
+	needsID          func(*E) bool
+	assignID         func(*E)
+}
+
+// WithMigration sets a function which is called for each restored element
+// before the elements are sorted. It allows to adapt elements written by an
+// older version of the application. If the function returns an error, New
+// fails. The migrated elements are written to disk as soon as their files are
+// modified.
+func WithMigration[E any](migration func(*E) error) Option[E] {
+	return func(o *options[E]) {
+		o.migration = migration
+	}
+}
+
+// WithLockStats enables the recording of lock statistics, which can be
+// obtained by LockStats. This allows to find out how often and how long the
+// operations of the table wait for each other. The recording causes a small
+// overhead for each operation.
+func WithLockStats[E any]() Option[E] {
+	return func(o *options[E]) {
+		o.lockStats = true
+	}
+}
+
+// WithAppendOnly makes the table append only. Elements can only be inserted,
+// all operations which modify or remove elements, like Result.Update,
+// Result.Delete, Move or Replace, return an error wrapping ErrAppendOnly. If
+// the table is sorted, a new element must not be less than the last element
+// of the table. If the Persist implements Appender, e.g. PersistJSONL, and no
+// write delay is set, the inserted elements are appended to the files without
+// rewriting them. A limit set by SetMaxSize is ignored.
+func WithAppendOnly[E any]() Option[E] {
+	return func(o *options[E]) {
+		o.appendOnly = true
+	}
+}
+
+// WithStrictVersioning sets the versioning policy of the Results. By default,
+// versioning is strict: Each access to a Result returns ErrVersionChanged if
+// the table was modified after the Result was created. If strict is false,
+// the methods of a Result which only read elements, like Get, Iter or First,
+// return the element found at the recorded index instead, as long as the
+// index is still in range. Be aware that this element can be a different one
+// than the one matched, if elements were inserted or deleted in front of it,
+// and that it may not match anymore. Methods which modify elements, like
+// Delete or Update, still return ErrVersionChanged, as do Order and Contains.
+func WithStrictVersioning[E any](strict bool) Option[E] {
+	return func(o *options[E]) {
+		o.strictVersioning = strict
+	}
+}
+
+// WithIDAssigner sets functions to assign IDs to new elements. If needsID
+// returns true for an element passed to Insert, InsertUnique or InsertVal,
+// assignID is called to set its ID. Both functions are called while the table
+// is locked, after the BeforeInsert hook was called and only if the element is
+// going to be stored, so a simple counter can be used to create unique IDs and
+// no ID is used up by an element rejected by InsertUnique. If the element was
+// inserted, the stored element, including the assigned ID, is written back to
+// the element passed to Insert or InsertUnique.
+func WithIDAssigner[E any](needsID func(*E) bool, assignID func(*E)) Option[E] {
+	return func(o *options[E]) {
+		o.needsID = needsID
+		o.assignID = assignID
 	}
+}
+
+// NewFromData creates a new Table which is not persisted and contains deep
+// copies of the given elements. The deepCopy and less functions are used as
+// described for New.
+func NewFromData[E any](data []*E, deepCopy func(dst *E, src *E), less func(e1, e2 *E) bool) (*Table[E], error) {
+	deepCopy, err := checkDeepCopy(deepCopy)
+	if err != nil {
+		return nil, err
+	}
+
+	e := make([]*E, len(data))
+	for i, d := range data {
+		var c E
+		deepCopy(&c, d)
+		e[i] = &c
+	}
+
+	return newTable(nil, nil, deepCopy, less, e), nil
+}
+
+// checkDeepCopy returns the deepCopy function to be used.
+func checkDeepCopy[E any](deepCopy func(dst *E, src *E)) (func(dst *E, src *E), error) {
+	if deepCopy != nil {
+		return deepCopy, nil
+	}
+	if t := reflect.TypeFor[E](); hasReferences(t) {
+		return nil, fmt.Errorf("type %v contains pointers, slices, maps or interfaces, so a deepCopy function is required; use DeepCopy[E] or a hand-written function", t)
+	}
+	return func(dst *E, src *E) {
+		*dst = *src
+	}, nil
+}
+
+func newTable[E any](nameProvider NameProvider[E], persist Persist[E], deepCopy func(dst *E, src *E), less func(e1, e2 *E) bool, e []*E) *Table[E] {
 	if less != nil {
-		sort.Slice(e, func(i, j int) bool {
+		sort.SliceStable(e, func(i, j int) bool {
 			return less(e[i], e[j])
 		})
 	}
@@ -364,5 +2020,6 @@ func New[E any](nameProvider NameProvider[E], persist Persist[E], deepCopy func(
 		deepCopy:     deepCopy,
 		orderLess:    less,
 		data:         e,
-	}, nil
+		clock:        realClock{},
+	}
 }
@@ -1,8 +1,13 @@
 package objectDB
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/hneemann/objectDB/serialize"
 	"os"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 
@@ -87,6 +92,24 @@ func TestStorageSerializer(t *testing.T) {
 	assert.NoError(t, a.Delete(0))
 }
 
+func TestStorageGob(t *testing.T) {
+	table, err := New[time.Time](myMonthly, PersistGob[time.Time]("testdata", "_db.gob"), nil, nil)
+	assert.NoError(t, err)
+	n := time.Now()
+
+	table.Insert(add(n, -24*30))
+	table.Insert(add(n, 0))
+	table.Insert(add(n, 24*30))
+
+	table2, err := New[time.Time](myMonthly, PersistGob[time.Time]("testdata", "_db.gob"), nil, nil)
+	assert.NoError(t, err)
+	a := table2.Match(func(e *time.Time) bool { return true })
+	assert.EqualValues(t, 3, a.Size())
+	assert.NoError(t, a.Delete(0))
+	assert.NoError(t, a.Delete(0))
+	assert.NoError(t, a.Delete(0))
+}
+
 func TestInsert(t *testing.T) {
 	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
 	assert.NoError(t, err)
@@ -166,9 +189,73 @@ func TestUpdate(t *testing.T) {
 
 }
 
+// fakeClock is a clock whose time only advances if Advance is called.
+type fakeClock struct {
+	m       sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now()}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	c := make(chan time.Time, 1)
+	f.waiters = append(f.waiters, fakeWaiter{at: f.now.Add(d), c: c})
+	return c
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.now = f.now.Add(d)
+	var waiters []fakeWaiter
+	for _, w := range f.waiters {
+		if w.at.After(f.now) {
+			waiters = append(waiters, w)
+		} else {
+			w.c <- f.now
+		}
+	}
+	f.waiters = waiters
+}
+
+// advanceUntil advances the clock in steps of a second until cond is true.
+func (f *fakeClock) advanceUntil(t *testing.T, cond func() bool) {
+	assert.Eventually(t, func() bool {
+		f.Advance(time.Second)
+		return cond()
+	}, 5*time.Second, time.Millisecond)
+}
+
+func fileCount(t *testing.T) int {
+	files, err := os.ReadDir("testdata")
+	assert.NoError(t, err)
+	return len(files)
+}
+
 func TestStorageSerializerDelay(t *testing.T) {
 	table, err := New[time.Time](myMonthly, PersistSerializer[time.Time]("testdata", "_db.bin", serialize.New()), nil, nil)
 	assert.NoError(t, err)
+	clock := newFakeClock()
+	table.clock = clock
 	table.SetWriteDelay(2)
 
 	// add some vales
@@ -182,13 +269,8 @@ func TestStorageSerializerDelay(t *testing.T) {
 	assert.NoError(t, err)
 	assert.EqualValues(t, 0, len(files))
 
-	// wait
-	time.Sleep(5 * time.Second)
-
-	// folder contains a file
-	files, err = os.ReadDir("testdata")
-	assert.NoError(t, err)
-	assert.EqualValues(t, 1, len(files))
+	// wait until the folder contains a file
+	clock.advanceUntil(t, func() bool { return fileCount(t) == 1 })
 
 	// delete entries
 	a := table.Match(func(e *time.Time) bool { return true })
@@ -202,13 +284,9 @@ func TestStorageSerializerDelay(t *testing.T) {
 	assert.NoError(t, err)
 	assert.EqualValues(t, 1, len(files))
 
-	// wait again
-	time.Sleep(5 * time.Second)
-
-	// folder empty
-	files, err = os.ReadDir("testdata")
-	assert.NoError(t, err)
-	assert.EqualValues(t, 0, len(files))
+	// wait until the folder is empty
+	clock.advanceUntil(t, func() bool { return fileCount(t) == 0 })
+	table.Shutdown()
 }
 
 func TestStorageSerializerDelayShutdown(t *testing.T) {
@@ -241,3 +319,1491 @@ func TestStorageSerializerDelayShutdown(t *testing.T) {
 	assert.NoError(t, err)
 	assert.EqualValues(t, 0, len(files))
 }
+
+type stringer struct {
+	S string
+}
+
+func (s stringer) String() string {
+	return s.S
+}
+
+type withInterface struct {
+	N int
+	S fmt.Stringer
+}
+
+func TestAtomicWrite(t *testing.T) {
+	np := SingleFile[withInterface]("atomic")
+	good := PersistSerializer[withInterface]("testdata", "_db.bin", serialize.New().Register(stringer{}))
+	bad := PersistSerializer[withInterface]("testdata", "_db.bin", serialize.New())
+
+	items := []*withInterface{{N: 1, S: stringer{S: "a"}}, {N: 2, S: stringer{S: "b"}}}
+	assert.NoError(t, good.Persist(np.ToFile(items[0]), items))
+
+	// the unregistered interface causes an error after the first bytes are written
+	assert.Error(t, bad.Persist(np.ToFile(items[0]), items))
+
+	// no temp file is left behind
+	files, err := os.ReadDir("testdata")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, len(files))
+
+	restored, err := good.Restore()
+	assert.NoError(t, err)
+	assert.EqualValues(t, items, restored)
+
+	assert.NoError(t, good.Persist(np.ToFile(items[0]), nil))
+}
+
+func TestStorageSerializerFlush(t *testing.T) {
+	table, err := New[time.Time](myMonthly, PersistSerializer[time.Time]("testdata", "_db.bin", serialize.New()), nil, nil)
+	assert.NoError(t, err)
+	table.SetWriteDelay(10)
+	defer table.Shutdown()
+
+	n := time.Now()
+	table.Insert(add(n, 0))
+	table.Insert(add(n, 1))
+
+	// folder still empty
+	files, err := os.ReadDir("testdata")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, len(files))
+
+	assert.NoError(t, table.Flush())
+
+	// folder contains a file
+	files, err = os.ReadDir("testdata")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, len(files))
+
+	// delete entries
+	a := table.Match(func(e *time.Time) bool { return true })
+	assert.NoError(t, a.Delete(0))
+	assert.NoError(t, a.Delete(0))
+	assert.NoError(t, table.Flush())
+
+	// folder empty
+	files, err = os.ReadDir("testdata")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, len(files))
+}
+
+func TestVersionChanged(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	r := table.Match(func(e *time.Time) bool { return true })
+	assert.NoError(t, table.Insert(add(n, 20)))
+
+	var e time.Time
+	assert.ErrorIs(t, r.Get(&e, 0), ErrVersionChanged)
+	assert.ErrorIs(t, r.Delete(0), ErrVersionChanged)
+	assert.ErrorIs(t, r.Update(0, &n), ErrVersionChanged)
+	_, err = r.Order(func(a, b *time.Time) bool { return b.Before(*a) })
+	assert.ErrorIs(t, err, ErrVersionChanged)
+
+	r = table.Match(func(e *time.Time) bool { return true })
+	err = r.Update(0, add(n, 5))
+	assert.ErrorIs(t, err, ErrOrderViolation)
+	assert.False(t, errors.Is(err, ErrVersionChanged))
+}
+
+func TestMapResult(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := time.Date(2024, 1, 5, 10, 0, 0, 0, time.UTC)
+	table.Insert(add(n, 1))
+	table.Insert(add(n, 0))
+
+	r := table.Match(func(e *time.Time) bool { return true })
+	s, err := MapResult(r, func(e *time.Time) string { return e.Format(time.Kitchen) })
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"10:00AM", "11:00AM"}, s)
+
+	table.Insert(add(n, 2))
+	_, err = MapResult(r, func(e *time.Time) string { return e.Format(time.Kitchen) })
+	assert.ErrorIs(t, err, ErrVersionChanged)
+}
+
+func TestReduceResult(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	r := table.Match(func(e *time.Time) bool { return true })
+	sum, err := ReduceResult(r, 0, func(s int, e *time.Time) int { return s + int(e.Sub(n)/time.Hour) })
+	assert.NoError(t, err)
+	assert.EqualValues(t, 45, sum)
+
+	table.Insert(add(n, 10))
+	_, err = ReduceResult(r, 0, func(s int, e *time.Time) int { return s + 1 })
+	assert.ErrorIs(t, err, ErrVersionChanged)
+}
+
+func TestReplace(t *testing.T) {
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	p := PersistSerializer[time.Time]("testdata", "_db.bin", serialize.New())
+	table, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+
+	n := time.Date(2024, 5, 15, 12, 0, 0, 0, time.Local)
+	table.Insert(add(n, -24*30))
+	table.Insert(add(n, 0))
+
+	files, err := os.ReadDir("testdata")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, len(files))
+
+	version := table.Version()
+	assert.NoError(t, table.Replace([]*time.Time{add(n, 24*30+1), add(n, 1), add(n, 24*30)}))
+	assert.EqualValues(t, version+1, table.Version())
+
+	// april is gone, may and june are present
+	files, err = os.ReadDir("testdata")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, len(files))
+	assert.EqualValues(t, "test_2024_05_db.bin", files[0].Name())
+	assert.EqualValues(t, "test_2024_06_db.bin", files[1].Name())
+
+	table2, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+	var all []time.Time
+	for e := range table2.All {
+		all = append(all, *e)
+	}
+	assert.EqualValues(t, 3, len(all))
+	assert.True(t, all[0].Equal(*add(n, 1)))
+	assert.True(t, all[1].Equal(*add(n, 24*30)))
+	assert.True(t, all[2].Equal(*add(n, 24*30+1)))
+
+	assert.NoError(t, table.Replace(nil))
+	files, err = os.ReadDir("testdata")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, len(files))
+}
+
+func TestGetVal(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	r := table.Match(func(e *time.Time) bool { return true })
+	for i := range r.Size() {
+		pick, err := r.GetVal(i)
+		assert.NoError(t, err)
+		assert.EqualValues(t, n.Add(time.Hour*time.Duration(i)), pick)
+	}
+	_, err = r.GetVal(10)
+	assert.Error(t, err)
+}
+
+func TestFirstVal(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	found, ok := table.FirstVal(func(e *time.Time) bool { return true })
+	assert.True(t, ok)
+	assert.EqualValues(t, n, found)
+
+	_, ok = table.FirstVal(func(e *time.Time) bool { return false })
+	assert.False(t, ok)
+}
+
+func TestSeq(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	r := table.Match(func(e *time.Time) bool { return true })
+	var all []time.Time
+	for e, err := range r.Seq() {
+		assert.NoError(t, err)
+		all = append(all, e)
+	}
+	assert.EqualValues(t, 10, len(all))
+	for i, e := range all {
+		assert.EqualValues(t, n.Add(time.Hour*time.Duration(i)), e)
+	}
+
+	table.Insert(add(n, 10))
+	count := 0
+	for e, err := range r.Seq() {
+		assert.ErrorIs(t, err, ErrVersionChanged)
+		assert.True(t, e.IsZero())
+		count++
+	}
+	assert.EqualValues(t, 1, count)
+}
+
+func TestIterNoAliasing(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	r := table.Match(func(e *time.Time) bool { return true })
+	var all []*time.Time
+	for e, err := range r.Iter {
+		assert.NoError(t, err)
+		all = append(all, e)
+	}
+	assert.EqualValues(t, 10, len(all))
+	for i, e := range all {
+		assert.EqualValues(t, n.Add(time.Hour*time.Duration(i)), *e)
+		if i > 0 {
+			assert.True(t, all[i-1] != e)
+		}
+	}
+}
+
+func TestToSlice(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	r := table.Match(func(e *time.Time) bool { return e.Before(n.Add(time.Hour * 3)) })
+	s, err := r.ToSlice()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []time.Time{*add(n, 0), *add(n, 1), *add(n, 2)}, s)
+	assert.EqualValues(t, []int{0, 1, 2}, r.IndicesCopy())
+
+	indices := r.IndicesCopy()
+	indices[0] = 5
+	assert.EqualValues(t, []int{0, 1, 2}, r.IndicesCopy())
+
+	assert.NoError(t, table.Insert(add(n, 10)))
+	_, err = r.ToSlice()
+	assert.ErrorIs(t, err, ErrVersionChanged)
+}
+
+func TestResultAnyAll(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+	r := table.Match(func(e *time.Time) bool { return true })
+
+	calls := 0
+	found, err := r.Any(func(e *time.Time) bool {
+		calls++
+		return e.Equal(n.Add(time.Hour * 2))
+	})
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.EqualValues(t, 3, calls)
+
+	calls = 0
+	all, err := r.All(func(e *time.Time) bool {
+		calls++
+		return e.Before(n.Add(time.Hour * 4))
+	})
+	assert.NoError(t, err)
+	assert.False(t, all)
+	assert.EqualValues(t, 5, calls)
+
+	all, err = r.All(func(e *time.Time) bool { return !e.Before(n) })
+	assert.NoError(t, err)
+	assert.True(t, all)
+
+	empty := table.Match(func(e *time.Time) bool { return false })
+	found, err = empty.Any(func(e *time.Time) bool { return true })
+	assert.NoError(t, err)
+	assert.False(t, found)
+	all, err = empty.All(func(e *time.Time) bool { return false })
+	assert.NoError(t, err)
+	assert.True(t, all)
+
+	assert.NoError(t, table.Insert(add(n, 10)))
+	_, err = r.Any(func(e *time.Time) bool { return false })
+	assert.ErrorIs(t, err, ErrVersionChanged)
+	_, err = r.All(func(e *time.Time) bool { return true })
+	assert.ErrorIs(t, err, ErrVersionChanged)
+}
+
+func TestAt(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	var e time.Time
+	assert.True(t, table.At(&e, 0))
+	assert.EqualValues(t, n, e)
+	assert.True(t, table.At(&e, 9))
+	assert.EqualValues(t, *add(n, 9), e)
+	assert.False(t, table.At(&e, 10))
+	assert.False(t, table.At(&e, -1))
+
+	// positions shift if the table is modified
+	assert.NoError(t, table.Insert(add(n, -1)))
+	assert.True(t, table.At(&e, 0))
+	assert.EqualValues(t, *add(n, -1), e)
+}
+
+func TestAllReverse(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	fillTable(table)
+
+	var forward []time.Time
+	for e := range table.All {
+		forward = append(forward, *e)
+	}
+	var reverse []time.Time
+	for e := range table.AllReverse {
+		reverse = append(reverse, *e)
+	}
+	slices.Reverse(reverse)
+	assert.EqualValues(t, forward, reverse)
+
+	count := 0
+	for range table.AllReverse {
+		count++
+		if count == 3 {
+			break
+		}
+	}
+	assert.EqualValues(t, 3, count)
+}
+
+func TestResultValidRefresh(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	late := func(e *time.Time) bool { return !e.Before(*add(n, 7)) }
+	r := table.Match(late)
+	assert.True(t, r.Valid())
+	assert.EqualValues(t, 3, r.Size())
+
+	assert.NoError(t, table.Insert(add(n, 10)))
+	assert.False(t, r.Valid())
+	var e time.Time
+	assert.ErrorIs(t, r.Get(&e, 0), ErrVersionChanged)
+
+	r, err = r.Refresh()
+	assert.NoError(t, err)
+	assert.True(t, r.Valid())
+	assert.EqualValues(t, 4, r.Size())
+	assert.NoError(t, r.Get(&e, 3))
+	assert.EqualValues(t, *add(n, 10), e)
+
+	// an ordered result is refreshed in the same order
+	o, err := r.Order(func(a, b *time.Time) bool { return b.Before(*a) })
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(add(n, 11)))
+	assert.False(t, o.Valid())
+	o, err = o.Refresh()
+	assert.NoError(t, err)
+	ordered, err := o.ToSlice()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []time.Time{*add(n, 11), *add(n, 10), *add(n, 9), *add(n, 8), *add(n, 7)}, ordered)
+
+	// the range bounds are copied
+	low := *add(n, 9)
+	rr, err := table.Range(&low, nil)
+	assert.NoError(t, err)
+	low = n
+	assert.NoError(t, table.Insert(add(n, 12)))
+	rr, err = rr.Refresh()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, rr.Size())
+
+	var empty Result[time.Time]
+	assert.False(t, empty.Valid())
+	_, err = empty.Refresh()
+	assert.Error(t, err)
+}
+
+func TestMatchN(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	calls := 0
+	odd := func(e *time.Time) bool {
+		calls++
+		return (e.Sub(n)/time.Hour)%2 == 1
+	}
+
+	r := table.MatchN(2, odd)
+	rs, err := r.ToSlice()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []time.Time{*add(n, 1), *add(n, 3)}, rs)
+	assert.EqualValues(t, 4, calls)
+
+	calls = 0
+	r = table.MatchN(10, odd)
+	assert.EqualValues(t, 5, r.Size())
+	assert.EqualValues(t, 10, calls)
+
+	r = table.MatchN(0, odd)
+	assert.EqualValues(t, 5, r.Size())
+	r = table.MatchN(-1, odd)
+	assert.EqualValues(t, 5, r.Size())
+}
+
+func TestMatchCached(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	calls := 0
+	accept := func(e *time.Time) bool {
+		calls++
+		return (e.Sub(n)/time.Hour)%2 == 0
+	}
+
+	r := table.MatchCached("even", accept)
+	assert.Equal(t, 5, r.Size())
+	assert.Equal(t, 10, calls)
+
+	// cache hit, accept is not called
+	r = table.MatchCached("even", accept)
+	assert.Equal(t, 5, r.Size())
+	assert.Equal(t, 10, calls)
+
+	// deleting from the result must not modify the cached entry
+	assert.NoError(t, r.Delete(0))
+	assert.Equal(t, 4, r.Size())
+
+	// the delete modified the table, so the cache is invalid
+	r = table.MatchCached("even", accept)
+	assert.Equal(t, 4, r.Size())
+	assert.Equal(t, 19, calls)
+
+	assert.NoError(t, table.Insert(add(n, 10)))
+	r = table.MatchCached("even", accept)
+	assert.Equal(t, 5, r.Size())
+	assert.Equal(t, 29, calls)
+
+	val, err := r.GetVal(4)
+	assert.NoError(t, err)
+	assert.EqualValues(t, *add(n, 10), val)
+}
+
+func TestMatchCachedUpdate(t *testing.T) {
+	table, err := New[person](nil, nil, nil, nil)
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, table.Insert(&person{Date: *date(2024, 1, i+1), Name: "a"}))
+	}
+
+	isA := func(p *person) bool { return p.Name == "a" }
+	r := table.MatchCached("a", isA)
+	assert.Equal(t, 10, r.Size())
+
+	ok, err := table.UpdateMatch(isA, &person{Date: *date(2024, 1, 1), Name: "b"})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	r = table.MatchCached("a", isA)
+	assert.Equal(t, 9, r.Size())
+
+	assert.NoError(t, r.Modify(0, func(p *person) { p.Name = "c" }))
+	r = table.MatchCached("a", isA)
+	assert.Equal(t, 8, r.Size())
+}
+
+func TestFiles(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{}, table.Files())
+
+	assert.NoError(t, table.Insert(date(2024, 2, 1)))
+	assert.NoError(t, table.Insert(date(2024, 1, 1)))
+	assert.NoError(t, table.Insert(date(2024, 1, 2)))
+	assert.EqualValues(t, []string{"test_2024_01", "test_2024_02"}, table.Files())
+
+	table, err = NewFromData[time.Time]([]*time.Time{date(2024, 1, 1)}, nil, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, table.Files())
+}
+
+func TestAfter(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	page, err := table.After(nil, 4)
+	assert.NoError(t, err)
+	p, err := page.ToSlice()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []time.Time{n, *add(n, 1), *add(n, 2), *add(n, 3)}, p)
+
+	// delete the last and an already seen element, the next page is not affected
+	r := table.Match(func(e *time.Time) bool { return e.Equal(*add(n, 3)) || e.Equal(*add(n, 1)) })
+	assert.NoError(t, r.Delete(1))
+	assert.NoError(t, r.Delete(0))
+
+	page, err = table.After(&p[3], 4)
+	assert.NoError(t, err)
+	p, err = page.ToSlice()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []time.Time{*add(n, 4), *add(n, 5), *add(n, 6), *add(n, 7)}, p)
+
+	page, err = table.After(&p[3], 4)
+	assert.NoError(t, err)
+	p, err = page.ToSlice()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []time.Time{*add(n, 8), *add(n, 9)}, p)
+
+	page, err = table.After(&p[1], 4)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, page.Size())
+
+	unsorted, err := New[time.Time](myMonthly, nil, nil, nil)
+	assert.NoError(t, err)
+	_, err = unsorted.After(nil, 4)
+	assert.Error(t, err)
+}
+
+func TestVersionConcurrent(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := time.Now()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			last := 0
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				v := table.Version()
+				if v < last {
+					t.Errorf("version decreased from %d to %d", last, v)
+					return
+				}
+				last = v
+			}
+		}()
+	}
+
+	for i := range 100 {
+		assert.NoError(t, table.Insert(add(n, i)))
+	}
+	close(done)
+	wg.Wait()
+
+	assert.EqualValues(t, 100, table.Version())
+}
+
+func TestInsertUnique(t *testing.T) {
+	sameMinute := func(a, b *time.Time) bool { return a.Truncate(time.Minute).Equal(b.Truncate(time.Minute)) }
+	for _, less := range []func(a, b *time.Time) bool{
+		func(a, b *time.Time) bool { return a.Truncate(time.Minute).Before(b.Truncate(time.Minute)) },
+		nil,
+	} {
+		p := &failingPersist{}
+		table, err := New[time.Time](myMonthly, p, nil, less)
+		assert.NoError(t, err)
+
+		n := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+		for i := range 3 {
+			ok, err := table.InsertUnique(add(n, i), sameMinute)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		}
+		assert.EqualValues(t, 3, p.calls)
+
+		dup := n.Add(time.Hour + time.Second)
+		ok, err := table.InsertUnique(&dup, sameMinute)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.EqualValues(t, 3, table.Size())
+		assert.EqualValues(t, 3, p.calls)
+
+		other := n.Add(time.Hour + time.Minute)
+		ok, err = table.InsertUnique(&other, sameMinute)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.EqualValues(t, 4, table.Size())
+		assert.EqualValues(t, 4, p.calls)
+	}
+}
+
+func TestIndexOutOfRange(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	r := table.Match(func(e *time.Time) bool { return e.Before(*add(n, 3)) })
+	for _, i := range []int{-1, 3, 100} {
+		var e time.Time
+		assert.ErrorIs(t, r.Get(&e, i), ErrIndexOutOfRange)
+		assert.ErrorIs(t, r.Delete(i), ErrIndexOutOfRange)
+		assert.ErrorIs(t, r.Update(i, &n), ErrIndexOutOfRange)
+	}
+	assert.EqualValues(t, 10, table.Size())
+	assert.NoError(t, r.Delete(2))
+	assert.EqualValues(t, 9, table.Size())
+}
+
+func TestDeleteAll(t *testing.T) {
+	p := &failingPersist{}
+	table, err := New[time.Time](myMonthly, p, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+	for i := range 8 {
+		assert.NoError(t, table.Insert(add(n, i)))
+	}
+	p.calls = 0
+
+	r := table.Match(func(e *time.Time) bool { return e.After(n) && e.Before(*add(n, 7)) })
+	assert.EqualValues(t, 6, r.Size())
+	assert.NoError(t, r.DeleteAll([]int{4, 0, 2, 2}))
+	assert.EqualValues(t, 1, p.calls)
+	assert.EqualValues(t, 5, table.Size())
+
+	// the result is still usable
+	rs, err := r.ToSlice()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []time.Time{*add(n, 2), *add(n, 4), *add(n, 6)}, rs)
+	assert.NoError(t, r.Delete(1))
+
+	var all []time.Time
+	for e := range table.All {
+		all = append(all, *e)
+	}
+	assert.EqualValues(t, []time.Time{n, *add(n, 2), *add(n, 6), *add(n, 7)}, all)
+
+	assert.ErrorIs(t, r.DeleteAll([]int{0, 2}), ErrIndexOutOfRange)
+	assert.NoError(t, table.Insert(add(n, 8)))
+	assert.ErrorIs(t, r.DeleteAll([]int{0}), ErrVersionChanged)
+	assert.EqualValues(t, 5, table.Size())
+}
+
+func TestForEachFile(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(date(2024, 2, 1)))
+	assert.NoError(t, table.Insert(date(2024, 1, 2)))
+	assert.NoError(t, table.Insert(date(2024, 3, 1)))
+	assert.NoError(t, table.Insert(date(2024, 1, 1)))
+
+	var names []string
+	var groups [][]*time.Time
+	for name, elems := range table.ForEachFile {
+		names = append(names, name)
+		groups = append(groups, elems)
+	}
+	assert.EqualValues(t, []string{"test_2024_01", "test_2024_02", "test_2024_03"}, names)
+	assert.EqualValues(t, [][]*time.Time{
+		{date(2024, 1, 1), date(2024, 1, 2)},
+		{date(2024, 2, 1)},
+		{date(2024, 3, 1)},
+	}, groups)
+
+	// the elements are copies
+	*groups[0][0] = time.Time{}
+	var e time.Time
+	assert.True(t, table.At(&e, 0))
+	assert.EqualValues(t, *date(2024, 1, 1), e)
+
+	count := 0
+	for range table.ForEachFile {
+		count++
+		break
+	}
+	assert.EqualValues(t, 1, count)
+}
+
+func TestExists(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	assert.True(t, table.Exists(func(e *time.Time) bool { return e.Equal(n.Add(time.Hour * 5)) }))
+	assert.False(t, table.Exists(func(e *time.Time) bool { return e.Equal(n.Add(time.Hour * 11)) }))
+}
+
+type benchItem struct {
+	N    int
+	Data []int
+}
+
+func copyBenchItem(dst *benchItem, src *benchItem) {
+	dst.N = src.N
+	dst.Data = append([]int{}, src.Data...)
+}
+
+func benchTable(b *testing.B) *Table[benchItem] {
+	table, err := New[benchItem](SingleFile[benchItem]("bench"), nil, copyBenchItem, nil)
+	assert.NoError(b, err)
+	data := make([]int, 1000)
+	for i := 0; i < 10000; i++ {
+		table.Insert(&benchItem{N: i, Data: data})
+	}
+	return table
+}
+
+func BenchmarkExists(b *testing.B) {
+	table := benchTable(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		table.Exists(func(e *benchItem) bool { return e.N == 10 })
+	}
+}
+
+func BenchmarkFirst(b *testing.B) {
+	table := benchTable(b)
+	b.ResetTimer()
+	var found benchItem
+	for i := 0; i < b.N; i++ {
+		table.First(&found, func(e *benchItem) bool { return e.N == 10 })
+	}
+}
+
+func TestRange(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	for lo := -1; lo <= 11; lo++ {
+		for hi := lo; hi <= 11; hi++ {
+			low, high := add(n, lo), add(n, hi)
+			r, err := table.Range(low, high)
+			assert.NoError(t, err)
+			naive := table.Match(func(e *time.Time) bool { return !e.Before(*low) && e.Before(*high) })
+			assert.EqualValues(t, naive.tableIndex, r.tableIndex)
+		}
+	}
+
+	r, err := table.Range(nil, add(n, 3))
+	assert.NoError(t, err)
+	assert.EqualValues(t, []int{0, 1, 2}, r.tableIndex)
+
+	r, err = table.Range(add(n, 7), nil)
+	assert.NoError(t, err)
+	assert.EqualValues(t, []int{7, 8, 9}, r.tableIndex)
+
+	r, err = table.Range(nil, nil, func(e *time.Time) bool { return e.Sub(n)%(2*time.Hour) == 0 })
+	assert.NoError(t, err)
+	assert.EqualValues(t, []int{0, 2, 4, 6, 8}, r.tableIndex)
+
+	unsorted, err := New[time.Time](myMonthly, nil, nil, nil)
+	assert.NoError(t, err)
+	_, err = unsorted.Range(nil, nil)
+	assert.Error(t, err)
+}
+
+func TestBrokenOrder(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return false })
+	assert.NoError(t, err)
+
+	// all elements are equal in sort order, so they are appended
+	n := time.Date(2024, 5, 15, 12, 0, 0, 0, time.Local)
+	assert.NoError(t, table.Insert(&n))
+	assert.NoError(t, table.Insert(&n))
+	assert.EqualValues(t, 2, table.Size())
+
+	table, err = New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return true })
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(&n))
+	assert.NoError(t, table.Insert(&n))
+
+	table.DebugChecks()
+	err = table.Insert(&n)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not sorted")
+}
+
+func TestOrderVersion(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	r := table.Match(func(e *time.Time) bool { return true })
+	o, err := r.Order(func(a, b *time.Time) bool { return b.Before(*a) })
+	assert.NoError(t, err)
+
+	// the ordered result shares the version of the original result
+	assert.NoError(t, table.Insert(add(n, 20)))
+	var e time.Time
+	assert.ErrorIs(t, o.Get(&e, 0), ErrVersionChanged)
+	_, err = r.Order(func(a, b *time.Time) bool { return b.Before(*a) })
+	assert.ErrorIs(t, err, ErrVersionChanged)
+}
+
+func TestOrderDebugCopy(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+	table.DebugChecks()
+
+	r := table.Match(func(e *time.Time) bool { return true })
+	o, err := r.Order(func(a, b *time.Time) bool {
+		// a misbehaving less function modifying the elements
+		*a = a.Add(time.Hour * 100)
+		return b.Before(*a)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, o.Size())
+
+	i := 0
+	for e := range table.All {
+		assert.EqualValues(t, *add(n, i), *e)
+		i++
+	}
+}
+
+// allSame is an inconsistent NameProvider: SameFile claims that all elements
+// are stored in the same file, but ToFile creates monthly files.
+type allSame struct {
+	NameProvider[time.Time]
+}
+
+func (allSame) SameFile(*time.Time, *time.Time) bool {
+	return true
+}
+
+func TestInconsistentNameProvider(t *testing.T) {
+	p := PersistJSON[time.Time]("testdata", "_db.json")
+	table, err := New[time.Time](allSame{myMonthly}, p, nil, nil)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, p.Persist("test_2024_05", nil))
+		assert.NoError(t, p.Persist("test_2024_06", nil))
+	}()
+
+	n := time.Date(2024, 5, 15, 12, 0, 0, 0, time.Local)
+	assert.NoError(t, table.Insert(&n))
+
+	table.DebugChecks()
+	err = table.Insert(add(n, 24*30))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "inconsistent name provider")
+}
+
+func TestUpdateMatch(t *testing.T) {
+	byDate := func(a, b *person) bool { return a.Date.Before(b.Date) }
+	table, err := New[person](nil, nil, nil, byDate)
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(&person{Date: *date(2024, 1, 1), Name: "Alice"}))
+	assert.NoError(t, table.Insert(&person{Date: *date(2024, 1, 2), Name: "Bob"}))
+	assert.NoError(t, table.Insert(&person{Date: *date(2024, 1, 3), Name: "Carol"}))
+
+	isBob := func(p *person) bool { return p.Name == "Bob" }
+	ok, err := table.UpdateMatch(isBob, &person{Date: *date(2024, 1, 2), Name: "Bob"})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = table.UpdateMatch(isBob, &person{Date: date(2024, 1, 2).Add(time.Hour), Name: "Robert"})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	p, found := table.FirstVal(func(p *person) bool { return p.Name == "Robert" })
+	assert.True(t, found)
+	assert.EqualValues(t, date(2024, 1, 2).Add(time.Hour), p.Date)
+
+	ok, err = table.UpdateMatch(isBob, &person{Name: "Bob"})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = table.UpdateMatch(func(p *person) bool { return p.Name == "Alice" }, &person{Date: *date(2024, 2, 1), Name: "Alice"})
+	assert.ErrorIs(t, err, ErrOrderViolation)
+	assert.False(t, ok)
+	assert.EqualValues(t, 3, table.Size())
+}
+
+func TestInsertVal(t *testing.T) {
+	table, err := New[benchItem](nil, nil, DeepCopy[benchItem], func(a, b *benchItem) bool { return a.N < b.N })
+	assert.NoError(t, err)
+
+	item := benchItem{N: 1, Data: []int{1, 2, 3}}
+	assert.NoError(t, table.InsertVal(item))
+	item.Data[0] = 0
+	item.N = 2
+
+	found, ok := table.FirstVal(func(e *benchItem) bool { return true })
+	assert.True(t, ok)
+	assert.EqualValues(t, benchItem{N: 1, Data: []int{1, 2, 3}}, found)
+
+	ok, err = table.UpdateMatchVal(func(e *benchItem) bool { return e.N == 1 }, benchItem{N: 1, Data: item.Data})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	item.Data[1] = 0
+
+	found, ok = table.FirstVal(func(e *benchItem) bool { return true })
+	assert.True(t, ok)
+	assert.EqualValues(t, benchItem{N: 1, Data: []int{0, 2, 3}}, found)
+}
+
+func TestMaxSize(t *testing.T) {
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	p := PersistJSON[time.Time]("testdata", "_db.json")
+	table, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, table.Replace(nil))
+	}()
+
+	assert.NoError(t, table.SetMaxSize(3))
+	for m := time.January; m <= time.August; m++ {
+		assert.NoError(t, table.Insert(date(2024, m, 1)))
+	}
+	// an element smaller than all others is evicted at once
+	assert.NoError(t, table.Insert(date(2024, 2, 1)))
+	assert.EqualValues(t, 3, table.Size())
+	var dates []time.Time
+	table.All(func(e *time.Time) bool {
+		dates = append(dates, *e)
+		return true
+	})
+	assert.EqualValues(t, []time.Time{*date(2024, 6, 1), *date(2024, 7, 1), *date(2024, 8, 1)}, dates)
+	files, err := p.(Lister).List()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"test_2024_06", "test_2024_07", "test_2024_08"}, files)
+
+	assert.NoError(t, table.SetMaxSize(0))
+	assert.NoError(t, table.Insert(date(2024, 9, 1)))
+	assert.EqualValues(t, 4, table.Size())
+
+	// lowering the limit evicts at once
+	assert.NoError(t, table.SetMaxSize(2))
+	assert.EqualValues(t, 2, table.Size())
+	files, err = p.(Lister).List()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"test_2024_08", "test_2024_09"}, files)
+}
+
+func TestDeepCopyPanic(t *testing.T) {
+	fail := false
+	deepCopy := func(dst, src *time.Time) {
+		if fail {
+			var p *time.Time
+			*dst = *p
+		}
+		*dst = *src
+	}
+	table, err := New[time.Time](myMonthly, nil, deepCopy, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+	n := fillTable(table)
+	r := table.Match(func(e *time.Time) bool { return true })
+
+	fail = true
+	assert.ErrorIs(t, table.Insert(add(n, 20)), ErrDeepCopy)
+	var e time.Time
+	assert.ErrorIs(t, r.Get(&e, 0), ErrDeepCopy)
+	assert.ErrorIs(t, r.Update(0, add(n, 0)), ErrDeepCopy)
+	assert.ErrorIs(t, table.Replace([]*time.Time{add(n, 0)}), ErrDeepCopy)
+	assert.Panics(t, func() {
+		table.All(func(*time.Time) bool { return true })
+	})
+
+	// the table is still usable
+	fail = false
+	assert.EqualValues(t, 10, table.Size())
+	assert.NoError(t, r.Get(&e, 0))
+	assert.EqualValues(t, n, e)
+	assert.NoError(t, table.Insert(add(n, 20)))
+	assert.EqualValues(t, 11, table.Size())
+}
+
+type withID struct {
+	ID   int
+	Name string
+}
+
+func TestIDAssigner(t *testing.T) {
+	lastID := 0
+	table, err := New[withID](nil, nil, nil, nil, WithIDAssigner(
+		func(e *withID) bool { return e.ID == 0 },
+		func(e *withID) {
+			lastID++
+			e.ID = lastID
+		}))
+	assert.NoError(t, err)
+
+	a := withID{Name: "a"}
+	assert.NoError(t, table.Insert(&a))
+	assert.EqualValues(t, 1, a.ID)
+	assert.NoError(t, table.Insert(&withID{ID: 42, Name: "b"}))
+	assert.NoError(t, table.InsertVal(withID{Name: "c"}))
+	ok, err := table.InsertUnique(&withID{Name: "d"}, func(a, b *withID) bool { return a.Name == b.Name })
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	var items []withID
+	table.All(func(e *withID) bool {
+		items = append(items, *e)
+		return true
+	})
+	assert.EqualValues(t, []withID{{1, "a"}, {42, "b"}, {2, "c"}, {3, "d"}}, items)
+
+	// a rejected element gets no ID
+	e := withID{Name: "d"}
+	ok, err = table.InsertUnique(&e, func(a, b *withID) bool { return a.Name == b.Name })
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.EqualValues(t, 0, e.ID)
+	assert.EqualValues(t, 3, lastID)
+}
+
+func TestMove(t *testing.T) {
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	p := PersistSerializer[time.Time]("testdata", "_db.bin", serialize.New())
+	table, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+
+	n := time.Date(2024, 5, 15, 12, 0, 0, 0, time.Local)
+	table.Insert(add(n, 0))
+	table.Insert(add(n, 1))
+	table.Insert(add(n, 2))
+
+	files, err := os.ReadDir("testdata")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, len(files))
+
+	version := table.Version()
+	moved := add(n, 24*30)
+	assert.NoError(t, table.Move(func(e *time.Time) bool { return e.Equal(n) }, moved))
+	assert.EqualValues(t, version+1, table.Version())
+
+	var all []time.Time
+	for e := range table.All {
+		all = append(all, *e)
+	}
+	assert.EqualValues(t, []time.Time{*add(n, 1), *add(n, 2), *moved}, all)
+
+	// the element moved to the june file
+	files, err = os.ReadDir("testdata")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, len(files))
+
+	table2, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, table2.Size())
+	assert.True(t, table2.Exists(func(e *time.Time) bool { return e.Equal(*moved) }))
+	assert.False(t, table2.Exists(func(e *time.Time) bool { return e.Equal(n) }))
+
+	assert.Error(t, table.Move(func(e *time.Time) bool { return false }, moved))
+
+	assert.NoError(t, table.Replace(nil))
+}
+
+func TestNewFromData(t *testing.T) {
+	n := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+	data := []*time.Time{add(n, 2), add(n, 0), add(n, 1)}
+	table, err := NewFromData[time.Time](data, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	*data[1] = n.Add(time.Hour * 10)
+
+	r := table.Match(func(e *time.Time) bool { return true })
+	all, err := r.ToSlice()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []time.Time{*add(n, 0), *add(n, 1), *add(n, 2)}, all)
+
+	assert.NoError(t, table.Insert(add(n, 3)))
+	assert.EqualValues(t, 4, table.Size())
+
+	items := []*copyItem{{Values: []int{1}}}
+	_, err = NewFromData[copyItem](items, nil, nil)
+	assert.Error(t, err)
+
+	ct, err := NewFromData[copyItem](items, DeepCopy[copyItem], nil)
+	assert.NoError(t, err)
+	items[0].Values[0] = 2
+	var stored copyItem
+	assert.True(t, ct.First(&stored, func(e *copyItem) bool { return true }))
+	assert.EqualValues(t, []int{1}, stored.Values)
+}
+
+func TestDeleteRollback(t *testing.T) {
+	failed := errors.New("failed")
+	p := &failingPersist{err: failed}
+	table, err := New[time.Time](myMonthly, p, nil, nil)
+	assert.NoError(t, err)
+	for d := 1; d <= 3; d++ {
+		assert.NoError(t, table.Insert(date(2024, 1, d)))
+	}
+	version := table.Version()
+
+	r := table.Match(func(*time.Time) bool { return true })
+	p.fails = p.calls + 1
+	assert.ErrorIs(t, r.Delete(1), failed)
+	assert.EqualValues(t, 3, table.Size())
+	assert.Greater(t, table.Version(), version)
+	assert.True(t, r.Valid())
+	var e time.Time
+	assert.NoError(t, r.Get(&e, 1))
+	assert.EqualValues(t, *date(2024, 1, 2), e)
+
+	// the retry deletes the element
+	assert.NoError(t, r.Delete(1))
+	assert.EqualValues(t, 2, table.Size())
+	assert.True(t, table.At(&e, 1))
+	assert.EqualValues(t, *date(2024, 1, 3), e)
+}
+
+func TestInsertRollback(t *testing.T) {
+	failed := errors.New("failed")
+	p := &failingPersist{err: failed}
+	table, err := New[time.Time](myMonthly, p, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(date(2024, 1, 1)))
+	assert.NoError(t, table.Insert(date(2024, 1, 3)))
+	version := table.Version()
+
+	p.fails = p.calls + 1
+	assert.ErrorIs(t, table.Insert(date(2024, 1, 2)), failed)
+	assert.EqualValues(t, 2, table.Size())
+	assert.Greater(t, table.Version(), version)
+	var e time.Time
+	assert.True(t, table.At(&e, 1))
+	assert.EqualValues(t, *date(2024, 1, 3), e)
+
+	inserted, err := table.InsertUnique(date(2024, 1, 2), func(a, b *time.Time) bool { return a.Equal(*b) })
+	assert.NoError(t, err)
+	assert.True(t, inserted)
+	assert.EqualValues(t, 3, table.Size())
+}
+
+func TestUpdateRollback(t *testing.T) {
+	failed := errors.New("failed")
+	p := &failingPersist{err: failed}
+	table, err := New[time.Time](myMonthly, p, nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(date(2024, 1, 1)))
+
+	r := table.Match(func(*time.Time) bool { return true })
+	p.fails = p.calls + 1
+	assert.ErrorIs(t, r.Update(0, date(2024, 1, 2)), failed)
+	var e time.Time
+	assert.NoError(t, r.Get(&e, 0))
+	assert.EqualValues(t, *date(2024, 1, 1), e)
+
+	assert.NoError(t, r.Update(0, date(2024, 1, 2)))
+	assert.NoError(t, r.Get(&e, 0))
+	assert.EqualValues(t, *date(2024, 1, 2), e)
+}
+
+func TestResultContains(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, nil)
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	r := table.Match(func(e *time.Time) bool { return !e.Before(*add(n, 5)) })
+	ok, err := r.Contains(func(e *time.Time) bool { return e.Equal(*add(n, 7)) })
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	// present in the table but not in the result
+	ok, err = r.Contains(func(e *time.Time) bool { return e.Equal(*add(n, 2)) })
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	empty := table.Match(func(e *time.Time) bool { return false })
+	assert.NoError(t, table.Insert(add(n, 20)))
+	_, err = r.Contains(func(e *time.Time) bool { return true })
+	assert.ErrorIs(t, err, ErrVersionChanged)
+	_, err = empty.Contains(func(e *time.Time) bool { return true })
+	assert.ErrorIs(t, err, ErrVersionChanged)
+}
+
+func TestResultFirst(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, nil)
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	r := table.Match(func(e *time.Time) bool { return !e.Before(*add(n, 5)) })
+	var e time.Time
+	ok, err := r.First(&e)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.EqualValues(t, *add(n, 5), e)
+
+	empty := table.Match(func(e *time.Time) bool { return false })
+	ok, err = empty.First(&e)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, table.Insert(add(n, 20)))
+	ok, err = r.First(&e)
+	assert.ErrorIs(t, err, ErrVersionChanged)
+	assert.False(t, ok)
+	_, err = empty.First(&e)
+	assert.ErrorIs(t, err, ErrVersionChanged)
+
+	var zero Result[time.Time]
+	ok, err = zero.First(&e)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestStrictVersioning(t *testing.T) {
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	strict, err := New[time.Time](myMonthly, nil, nil, less)
+	assert.NoError(t, err)
+	relaxed, err := New[time.Time](myMonthly, nil, nil, less, WithStrictVersioning[time.Time](false))
+	assert.NoError(t, err)
+
+	for _, table := range []*Table[time.Time]{strict, relaxed} {
+		n := fillTable(table)
+		r := table.Match(func(e *time.Time) bool { return !e.Before(*add(n, 5)) })
+		// an unrelated insert behind the matched elements
+		assert.NoError(t, table.Insert(add(n, 20)))
+
+		var e time.Time
+		err = r.Get(&e, 0)
+		if table == strict {
+			assert.ErrorIs(t, err, ErrVersionChanged)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.EqualValues(t, *add(n, 5), e)
+		assert.ErrorIs(t, r.Delete(0), ErrVersionChanged)
+		assert.ErrorIs(t, r.Update(0, add(n, 5)), ErrVersionChanged)
+
+		// an insert in front of the matched elements makes the result stale
+		assert.NoError(t, table.Insert(add(n, -1)))
+		assert.NoError(t, r.Get(&e, 0))
+		assert.EqualValues(t, *add(n, 4), e)
+
+		// an empty result stays readable
+		empty := table.Match(func(e *time.Time) bool { return false })
+		assert.NoError(t, table.Insert(add(n, 30)))
+		found, err := empty.First(&e)
+		assert.NoError(t, err)
+		assert.False(t, found)
+
+		assert.NoError(t, table.Replace(nil))
+		assert.Error(t, r.Get(&e, 0))
+	}
+}
+
+func TestChangeWriteDelay(t *testing.T) {
+	table, err := New[time.Time](myMonthly, PersistSerializer[time.Time]("testdata", "_db.bin", serialize.New()), nil, nil)
+	assert.NoError(t, err)
+	clock := newFakeClock()
+	table.clock = clock
+	table.SetWriteDelay(10)
+
+	assert.NoError(t, table.Insert(date(2024, 1, 1)))
+	table.SetWriteDelay(2)
+	assert.EqualValues(t, 0, fileCount(t))
+
+	// the pending file is written although the delay was changed
+	clock.advanceUntil(t, func() bool { return fileCount(t) == 1 })
+
+	// disabling the delay writes the pending files
+	assert.NoError(t, table.Insert(date(2024, 2, 1)))
+	assert.EqualValues(t, 1, fileCount(t))
+	table.SetWriteDelay(0)
+	assert.EqualValues(t, 2, fileCount(t))
+
+	assert.NoError(t, table.Replace(nil))
+}
+
+// blockingPersist blocks each Persist call until release is closed.
+type blockingPersist struct {
+	release chan struct{}
+	written chan string
+	err     error
+}
+
+func (b blockingPersist) Persist(name string, _ []*time.Time) error {
+	<-b.release
+	if b.written != nil {
+		b.written <- name
+	}
+	return b.err
+}
+
+func (b blockingPersist) Restore() ([]*time.Time, error) {
+	return nil, nil
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	p := blockingPersist{release: make(chan struct{})}
+	table, err := New[time.Time](myMonthly, p, nil, nil)
+	assert.NoError(t, err)
+	table.SetWriteDelay(10)
+	assert.NoError(t, table.Insert(date(2024, 1, 1)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, table.ShutdownContext(ctx), context.DeadlineExceeded)
+
+	// the file is written in the background as soon as the write is released
+	close(p.release)
+	assert.NoError(t, table.ShutdownContext(context.Background()))
+	assert.NoError(t, table.Insert(date(2024, 1, 2)))
+}
+
+func TestShutdownTimeoutWait(t *testing.T) {
+	failed := errors.New("failed")
+	p := blockingPersist{release: make(chan struct{}), written: make(chan string, 10), err: failed}
+	table, err := New[time.Time](myMonthly, p, nil, nil)
+	assert.NoError(t, err)
+	table.SetWriteDelay(10)
+	assert.NoError(t, table.Insert(date(2024, 1, 1)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, table.ShutdownContext(ctx), context.DeadlineExceeded)
+
+	// the next call waits for the write running in the background and
+	// returns its error
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(p.release)
+	}()
+	assert.ErrorIs(t, table.ShutdownContext(context.Background()), failed)
+	assert.EqualValues(t, 1, len(p.written))
+	assert.EqualValues(t, "test_2024_01", <-p.written)
+
+	// the error is reported only once
+	assert.NoError(t, table.ShutdownContext(context.Background()))
+}
+
+func TestDeleteTwoResults(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	even := func(e *time.Time) bool { return e.Sub(n)/time.Hour%2 == 0 }
+	r1 := table.Match(even)
+	r2 := table.Match(func(e *time.Time) bool { return !even(e) })
+
+	assert.NoError(t, r1.Delete(0))
+	assert.NoError(t, r1.Delete(0))
+	assert.EqualValues(t, table.Version(), r1.version)
+	assert.ErrorIs(t, r2.Delete(0), ErrVersionChanged)
+	assert.EqualValues(t, 5, r2.Size())
+
+	r2, err = r2.Refresh()
+	assert.NoError(t, err)
+	assert.NoError(t, r2.Delete(4))
+	assert.ErrorIs(t, r1.Delete(0), ErrVersionChanged)
+
+	r1, err = r1.Refresh()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, r1.Size())
+	assert.NoError(t, r1.DeleteAll([]int{0, 2}))
+	var e time.Time
+	assert.NoError(t, r1.Get(&e, 0))
+	assert.EqualValues(t, *add(n, 6), e)
+	assert.EqualValues(t, 5, table.Size())
+}
+
+func TestDeleteWithDelayedError(t *testing.T) {
+	failed := errors.New("failed")
+	table, err := New[time.Time](myMonthly, &failingPersist{fails: 1, err: failed}, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+	clock := newFakeClock()
+	table.clock = clock
+	table.SetWriteDelay(2)
+	defer table.Shutdown()
+
+	n := fillTable(table)
+	clock.advanceUntil(t, func() bool { return table.LastWriteError() != nil })
+
+	// the delete takes effect although the earlier error is reported
+	r := table.Match(func(e *time.Time) bool { return true })
+	assert.ErrorIs(t, r.Delete(0), failed)
+	assert.EqualValues(t, 9, r.Size())
+	assert.EqualValues(t, 9, table.Size())
+	assert.NoError(t, r.Delete(0))
+	var e time.Time
+	assert.NoError(t, r.Get(&e, 0))
+	assert.EqualValues(t, *add(n, 2), e)
+}
+
+func TestDeleteAfterInsert(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	n := fillTable(table)
+
+	r := table.Match(func(e *time.Time) bool { return true })
+	assert.NoError(t, r.Delete(0))
+	// the insert shifts the elements, so the result must not be used anymore
+	assert.NoError(t, table.Insert(add(n, -1)))
+	assert.False(t, r.Valid())
+	assert.ErrorIs(t, r.Delete(0), ErrVersionChanged)
+	assert.EqualValues(t, 10, table.Size())
+
+	r, err = r.Refresh()
+	assert.NoError(t, err)
+	assert.NoError(t, r.Delete(0))
+	assert.True(t, r.Valid())
+	assert.NoError(t, r.Delete(0))
+	var e time.Time
+	assert.True(t, table.At(&e, 0))
+	assert.EqualValues(t, *add(n, 2), e)
+}
+
+func TestMinMax(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+	var e time.Time
+	assert.False(t, table.Min(&e))
+	assert.False(t, table.Max(&e))
+
+	n := fillTable(table)
+	assert.True(t, table.Min(&e))
+	assert.EqualValues(t, *add(n, 0), e)
+	assert.True(t, table.Max(&e))
+	assert.EqualValues(t, *add(n, 9), e)
+
+	unsorted, err := New[time.Time](myMonthly, nil, nil, nil)
+	assert.NoError(t, err)
+	fillTable(unsorted)
+	assert.False(t, unsorted.Min(&e))
+	assert.False(t, unsorted.Max(&e))
+}
+
+func TestPosition(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, table.Position(add(time.Now(), 0)))
+
+	n := fillTable(table)
+	assert.EqualValues(t, 0, table.Position(add(n, -1)))
+	between := n.Add(3*time.Hour + time.Minute)
+	assert.EqualValues(t, 4, table.Position(&between))
+	assert.EqualValues(t, 10, table.Position(add(n, 20)))
+
+	e := n.Add(5*time.Hour + time.Minute)
+	p := table.Position(&e)
+	assert.NoError(t, table.Insert(&e))
+	var at time.Time
+	assert.True(t, table.At(&at, p))
+	assert.EqualValues(t, e, at)
+
+	unsorted, err := New[time.Time](myMonthly, nil, nil, nil)
+	assert.NoError(t, err)
+	fillTable(unsorted)
+	assert.EqualValues(t, 10, unsorted.Position(add(n, -1)))
+}
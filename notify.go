@@ -0,0 +1,129 @@
+package objectDB
+
+import (
+	"log"
+	"sync"
+)
+
+// Operation is the kind of modification of a table
+type Operation int
+
+const (
+	// OpInsert is used if an element was inserted
+	OpInsert Operation = iota
+	// OpUpdate is used if an element was updated
+	OpUpdate
+	// OpDelete is used if an element was deleted
+	OpDelete
+)
+
+func (o Operation) String() string {
+	switch o {
+	case OpInsert:
+		return "insert"
+	case OpUpdate:
+		return "update"
+	case OpDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// Change describes a modification of the table
+type Change[E any] struct {
+	// Op is the kind of modification
+	Op Operation
+	// Element is a deep copy of the inserted, updated or deleted element
+	Element E
+}
+
+// Subscribe returns a channel which receives a Change for every modification
+// of the table. The changes are delivered in the order they are made. Writers
+// are never blocked by a slow subscriber, because the changes are queued for
+// each subscriber. The returned function unsubscribes and closes the channel.
+func (t *Table[E]) Subscribe() (<-chan Change[E], func()) {
+	s := &subscriber[E]{
+		signal: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		ch:     make(chan Change[E]),
+	}
+	go s.run()
+
+	t.lock(LockConfig)
+	t.subscribers = append(t.subscribers, s)
+	t.m.Unlock()
+
+	var once sync.Once
+	return s.ch, func() {
+		once.Do(func() {
+			t.lock(LockConfig)
+			for i, su := range t.subscribers {
+				if su == s {
+					t.subscribers = append(t.subscribers[:i], t.subscribers[i+1:]...)
+					break
+				}
+			}
+			t.m.Unlock()
+			close(s.done)
+		})
+	}
+}
+
+// publish sends the change to all subscribers. The caller has to hold the
+// lock. This method does not block. If the element can not be copied, the
+// change is not published, because the modification is already done.
+func (t *Table[E]) publish(op Operation, e *E) {
+	for _, s := range t.subscribers {
+		c := Change[E]{Op: op}
+		err := t.safeCopy(&c.Element, e)
+		if err != nil {
+			log.Println("publish:", err)
+			return
+		}
+		s.publish(c)
+	}
+}
+
+type subscriber[E any] struct {
+	m      sync.Mutex
+	queue  []Change[E]
+	signal chan struct{}
+	done   chan struct{}
+	ch     chan Change[E]
+}
+
+func (s *subscriber[E]) publish(c Change[E]) {
+	s.m.Lock()
+	s.queue = append(s.queue, c)
+	s.m.Unlock()
+
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber[E]) run() {
+	defer close(s.ch)
+	for {
+		s.m.Lock()
+		q := s.queue
+		s.queue = nil
+		s.m.Unlock()
+
+		for _, c := range q {
+			select {
+			case s.ch <- c:
+			case <-s.done:
+				return
+			}
+		}
+
+		select {
+		case <-s.signal:
+		case <-s.done:
+			return
+		}
+	}
+}
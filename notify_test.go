@@ -0,0 +1,99 @@
+package objectDB
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+
+	ch, unsubscribe := table.Subscribe()
+
+	n := time.Now()
+	assert.NoError(t, table.Insert(add(n, 1)))
+	assert.NoError(t, table.Insert(add(n, 2)))
+
+	r := table.Match(func(e *time.Time) bool { return true })
+	assert.NoError(t, r.Update(0, add(n, 0)))
+	assert.NoError(t, r.Delete(1))
+
+	expected := []Change[time.Time]{
+		{Op: OpInsert, Element: *add(n, 1)},
+		{Op: OpInsert, Element: *add(n, 2)},
+		{Op: OpUpdate, Element: *add(n, 0)},
+		{Op: OpDelete, Element: *add(n, 2)},
+	}
+	for _, e := range expected {
+		c := <-ch
+		assert.EqualValues(t, e, c)
+	}
+
+	unsubscribe()
+	_, ok := <-ch
+	assert.False(t, ok)
+
+	// no further events after unsubscribe
+	assert.NoError(t, table.Insert(add(n, 3)))
+	unsubscribe()
+}
+
+func TestSubscribeDeepCopyPanic(t *testing.T) {
+	fail := false
+	deepCopy := func(dst, src *time.Time) {
+		if fail {
+			var p *time.Time
+			*dst = *p
+		}
+		*dst = *src
+	}
+	table, err := New[time.Time](myMonthly, nil, deepCopy, nil)
+	assert.NoError(t, err)
+	ch, unsubscribe := table.Subscribe()
+	defer unsubscribe()
+
+	n := time.Now()
+	assert.NoError(t, table.Insert(add(n, 1)))
+	assert.EqualValues(t, Change[time.Time]{Op: OpInsert, Element: *add(n, 1)}, <-ch)
+
+	// the delete itself does not copy, so only the publishing fails
+	fail = true
+	r := table.Match(func(e *time.Time) bool { return true })
+	assert.NoError(t, r.Delete(0))
+	assert.EqualValues(t, 0, table.Size())
+
+	fail = false
+	assert.NoError(t, table.Insert(add(n, 2)))
+	assert.EqualValues(t, Change[time.Time]{Op: OpInsert, Element: *add(n, 2)}, <-ch)
+}
+
+func TestSubscribeSlow(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, nil)
+	assert.NoError(t, err)
+
+	ch, unsubscribe := table.Subscribe()
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	n := time.Now()
+	go func() {
+		for i := 0; i < 1000; i++ {
+			table.Insert(add(n, i))
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writer blocked by subscriber")
+	}
+
+	for i := 0; i < 1000; i++ {
+		c := <-ch
+		assert.EqualValues(t, OpInsert, c.Op)
+		assert.EqualValues(t, *add(n, i), c.Element)
+	}
+}
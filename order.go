@@ -0,0 +1,19 @@
+package objectDB
+
+// OrderBy creates a less function as required by New and Result.Order from
+// the given three-way comparators. Each comparator returns a negative number
+// if a is less than b, zero if they are equal and a positive number otherwise,
+// like cmp.Compare does. The comparators are evaluated in the given order, and
+// the first one returning a non-zero value decides. So the second comparator
+// is only used if the first one considers the elements equal, and so on.
+// Descending order is obtained by swapping a and b in a comparator.
+func OrderBy[E any](cmps ...func(a, b *E) int) func(a, b *E) bool {
+	return func(a, b *E) bool {
+		for _, c := range cmps {
+			if r := c(a, b); r != 0 {
+				return r < 0
+			}
+		}
+		return false
+	}
+}
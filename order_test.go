@@ -0,0 +1,101 @@
+package objectDB
+
+import (
+	"cmp"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+type person struct {
+	Date time.Time
+	Name string
+}
+
+func TestOrderBy(t *testing.T) {
+	byDate := func(a, b *person) int { return a.Date.Compare(b.Date) }
+	byNameDesc := func(a, b *person) int { return strings.Compare(b.Name, a.Name) }
+	less := OrderBy(byDate, byNameDesc)
+
+	d1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	d2 := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	assert.True(t, less(&person{d1, "a"}, &person{d2, "b"}))
+	assert.False(t, less(&person{d2, "a"}, &person{d1, "b"}))
+	// equal dates fall through to the name in descending order
+	assert.True(t, less(&person{d1, "b"}, &person{d1, "a"}))
+	assert.False(t, less(&person{d1, "a"}, &person{d1, "b"}))
+	// all equal
+	assert.False(t, less(&person{d1, "a"}, &person{d1, "a"}))
+	assert.False(t, OrderBy[person]()(&person{d1, "a"}, &person{d2, "b"}))
+
+	table, err := New[person](SingleFile[person]("p"), nil, nil, less)
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(&person{d2, "a"}))
+	assert.NoError(t, table.Insert(&person{d1, "a"}))
+	assert.NoError(t, table.Insert(&person{d1, "c"}))
+	assert.NoError(t, table.Insert(&person{d2, "b"}))
+	assert.NoError(t, table.Insert(&person{d1, "b"}))
+
+	var names []string
+	for p := range table.All {
+		names = append(names, p.Date.Format("02")+p.Name)
+	}
+	assert.EqualValues(t, []string{"01c", "01b", "01a", "02b", "02a"}, names)
+
+	byLen := OrderBy(func(a, b *string) int { return cmp.Compare(len(*a), len(*b)) })
+	x, y := "x", "yy"
+	assert.True(t, byLen(&x, &y))
+}
+
+func TestModify(t *testing.T) {
+	table, err := New[person](SingleFile[person]("p"), nil, nil, func(a, b *person) bool { return a.Date.Before(b.Date) })
+	assert.NoError(t, err)
+	d1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	d2 := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	// the first three elements are equal in sort order
+	assert.NoError(t, table.Replace([]*person{{d1, "a"}, {d1, "b"}, {d1, "c"}, {d2, "d"}}))
+
+	// the element in the middle has neighbors with the same date
+	r := table.Match(func(p *person) bool { return p.Name == "b" })
+	assert.NoError(t, r.Modify(0, func(p *person) { p.Name = "B" }))
+	p, err := r.GetVal(0)
+	assert.NoError(t, err)
+	assert.EqualValues(t, person{d1, "B"}, p)
+
+	assert.ErrorIs(t, r.Modify(0, func(p *person) { p.Date = d2.Add(time.Hour) }), ErrOrderViolation)
+	p, err = r.GetVal(0)
+	assert.NoError(t, err)
+	assert.EqualValues(t, d1, p.Date)
+
+	assert.ErrorIs(t, r.Modify(1, func(p *person) {}), ErrIndexOutOfRange)
+	assert.NoError(t, table.Insert(&person{d2.Add(time.Hour), "e"}))
+	assert.ErrorIs(t, r.Modify(0, func(p *person) {}), ErrVersionChanged)
+}
+
+func TestStableOrder(t *testing.T) {
+	table, err := New[person](SingleFile[person]("p"), nil, nil, func(a, b *person) bool { return a.Date.Before(b.Date) })
+	assert.NoError(t, err)
+	d1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	d2 := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	d3 := time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)
+	for i, d := range []time.Time{d2, d1, d2, d3, d1, d2, d1, d3} {
+		assert.NoError(t, table.Insert(&person{d, string(rune('a' + i))}))
+	}
+
+	var names []string
+	for p := range table.All {
+		names = append(names, p.Date.Format("02")+p.Name)
+	}
+	assert.EqualValues(t, []string{"01b", "01e", "01g", "02a", "02c", "02f", "03d", "03h"}, names)
+
+	r := table.Match(func(p *person) bool { return true })
+	o, err := r.Order(func(a, b *person) bool { return b.Date.Before(a.Date) })
+	assert.NoError(t, err)
+	names = nil
+	for p, err := range o.Seq() {
+		assert.NoError(t, err)
+		names = append(names, p.Date.Format("02")+p.Name)
+	}
+	assert.EqualValues(t, []string{"03d", "03h", "02a", "02c", "02f", "01b", "01e", "01g"}, names)
+}
@@ -2,15 +2,24 @@ package objectDB
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/hneemann/objectDB/serialize"
+	"hash/fnv"
 	"io"
+	"io/fs"
 	"log"
+	"math"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -52,6 +61,175 @@ func (m monthly[E]) ToFile(e *E) string {
 	return m.prefix + strconv.Itoa(d.Year()) + "_" + strconv.Itoa(mo)
 }
 
+// Daily returns a NameProvider that stores objects in daily files.
+// The prefix is added to the file name.
+func Daily[E any](prefix string, dateFunc func(*E) time.Time) NameProvider[E] {
+	if prefix != "" {
+		prefix += "_"
+	}
+	return daily[E]{dateFunc: dateFunc, prefix: prefix}
+}
+
+type daily[E any] struct {
+	dateFunc func(*E) time.Time
+	prefix   string
+}
+
+func (d daily[E]) SameFile(e1, e2 *E) bool {
+	d1 := d.dateFunc(e1)
+	d2 := d.dateFunc(e2)
+	return (d1.Year() == d2.Year()) && (d1.YearDay() == d2.YearDay())
+}
+
+func (d daily[E]) ToFile(e *E) string {
+	da := d.dateFunc(e)
+	return d.prefix + strconv.Itoa(da.Year()) + "_" + pad2(int(da.Month())) + "_" + pad2(da.Day())
+}
+
+// Weekly returns a NameProvider that stores objects in weekly files.
+// The ISO 8601 week is used, so the file name contains the ISO year, which
+// can differ from the calendar year at the beginning and the end of a year.
+// The prefix is added to the file name.
+func Weekly[E any](prefix string, dateFunc func(*E) time.Time) NameProvider[E] {
+	if prefix != "" {
+		prefix += "_"
+	}
+	return weekly[E]{dateFunc: dateFunc, prefix: prefix}
+}
+
+type weekly[E any] struct {
+	dateFunc func(*E) time.Time
+	prefix   string
+}
+
+func (w weekly[E]) SameFile(e1, e2 *E) bool {
+	y1, w1 := w.dateFunc(e1).ISOWeek()
+	y2, w2 := w.dateFunc(e2).ISOWeek()
+	return (y1 == y2) && (w1 == w2)
+}
+
+func (w weekly[E]) ToFile(e *E) string {
+	y, we := w.dateFunc(e).ISOWeek()
+	return w.prefix + strconv.Itoa(y) + "_W" + pad2(we)
+}
+
+// Yearly returns a NameProvider that stores objects in yearly files.
+// The prefix is added to the file name.
+func Yearly[E any](prefix string, dateFunc func(*E) time.Time) NameProvider[E] {
+	if prefix != "" {
+		prefix += "_"
+	}
+	return yearly[E]{dateFunc: dateFunc, prefix: prefix}
+}
+
+type yearly[E any] struct {
+	dateFunc func(*E) time.Time
+	prefix   string
+}
+
+func (y yearly[E]) SameFile(e1, e2 *E) bool {
+	return y.dateFunc(e1).Year() == y.dateFunc(e2).Year()
+}
+
+func (y yearly[E]) ToFile(e *E) string {
+	return y.prefix + strconv.Itoa(y.dateFunc(e).Year())
+}
+
+func pad2(i int) string {
+	if i < 10 {
+		return "0" + strconv.Itoa(i)
+	}
+	return strconv.Itoa(i)
+}
+
+// ByKey returns a NameProvider that stores all objects with the same key in
+// the same file. The key returned by keyFunc is used as the file name, so it
+// has to be a valid file name. This allows arbitrary buckets, e.g. a file for
+// each tenant or region. The prefix is added to the file name.
+func ByKey[E any](prefix string, keyFunc func(*E) string) NameProvider[E] {
+	if prefix != "" {
+		prefix += "_"
+	}
+	return byKey[E]{prefix: prefix, keyFunc: keyFunc}
+}
+
+type byKey[E any] struct {
+	prefix  string
+	keyFunc func(*E) string
+}
+
+func (b byKey[E]) SameFile(e1, e2 *E) bool {
+	return b.keyFunc(e1) == b.keyFunc(e2)
+}
+
+func (b byKey[E]) ToFile(e *E) string {
+	return b.prefix + b.keyFunc(e)
+}
+
+// Sharded returns a NameProvider that distributes the objects over the given
+// number of files. The file is selected by the FNV hash of the key returned by
+// keyFunc. The files are named shard_<n>.
+func Sharded[E any](shards int, keyFunc func(*E) string) NameProvider[E] {
+	if shards < 1 {
+		shards = 1
+	}
+	return sharded[E]{shards: uint32(shards), keyFunc: keyFunc}
+}
+
+type sharded[E any] struct {
+	shards  uint32
+	keyFunc func(*E) string
+}
+
+func (s sharded[E]) shard(e *E) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s.keyFunc(e)))
+	return h.Sum32() % s.shards
+}
+
+func (s sharded[E]) SameFile(e1, e2 *E) bool {
+	return s.shard(e1) == s.shard(e2)
+}
+
+func (s sharded[E]) ToFile(e *E) string {
+	return "shard_" + strconv.Itoa(int(s.shard(e)))
+}
+
+// RollingFile returns a NameProvider that stores at most maxPerFile objects in
+// each file. The files are named prefix_0, prefix_1 and so on. The file is
+// selected by the sequence number returned by seqFunc, which has to be stored
+// in the object, e.g. an id assigned at creation time. A file index derived
+// from a running count would not be stable, because deleting an object or
+// restarting the application would move the remaining objects to other files.
+// Sequence numbers must not be negative.
+func RollingFile[E any](prefix string, maxPerFile int, seqFunc func(*E) int) NameProvider[E] {
+	if maxPerFile < 1 {
+		maxPerFile = 1
+	}
+	if prefix != "" {
+		prefix += "_"
+	}
+	return rollingFile[E]{prefix: prefix, maxPerFile: maxPerFile, seqFunc: seqFunc}
+}
+
+type rollingFile[E any] struct {
+	prefix     string
+	maxPerFile int
+	seqFunc    func(*E) int
+}
+
+func (r rollingFile[E]) bucket(e *E) int {
+	return r.seqFunc(e) / r.maxPerFile
+}
+
+func (r rollingFile[E]) SameFile(e1, e2 *E) bool {
+	return r.bucket(e1) == r.bucket(e2)
+}
+
+func (r rollingFile[E]) ToFile(e *E) string {
+	return r.prefix + strconv.Itoa(r.bucket(e))
+}
+
 // SingleFile returns a NameProvider that stores all objects in the same file.
 func SingleFile[E any](filename string) NameProvider[E] {
 	return singleFile[E]{filename: filename}
@@ -70,6 +248,16 @@ func (s singleFile[E]) ToFile(*E) string {
 }
 
 // Persist is an interface to persist and restore objects.
+//
+// The implementations which store the objects in the files of a base folder,
+// like the ones created by PersistJSON, PersistSerializer, PersistGob or
+// PersistCSV, are called file based. Most of the functions which wrap a
+// Persist, like PersistCompressed or PersistDurable, only work with a file
+// based Persist and panic if the inner Persist is of any other kind, e.g.
+// created by PersistMemory, PersistRetry or PersistInstrumented. The Persist
+// returned by such a function is file based again, so these wrappers can be
+// combined. PersistDryRun is the only exception, its result can not be
+// wrapped any further.
 type Persist[E any] interface {
 	// Persist stores the objects in a file.
 	Persist(name string, items []*E) error
@@ -77,184 +265,1046 @@ type Persist[E any] interface {
 	Restore() ([]*E, error)
 }
 
+// Appender is implemented by Persist implementations which are able to add a
+// single new object to a file without rewriting the whole file. If it is
+// implemented, the table uses it to persist inserted objects.
+type Appender[E any] interface {
+	// Append adds the object to the file. If ErrAppendNotSupported is
+	// returned, nothing was written, and the file is persisted as a whole.
+	Append(name string, e *E) error
+}
+
+// ErrAppendNotSupported is returned by Append if the Persist is not able to
+// append to a file.
+var ErrAppendNotSupported = errors.New("append not supported")
+
+// Lister is implemented by Persist implementations which are able to list
+// the files they have stored.
+type Lister interface {
+	// List returns the names of all stored files. The names are the ones
+	// passed to Persist.
+	List() ([]string, error)
+}
+
+// Stater is implemented by Persist implementations which are able to tell
+// when a file was written.
+type Stater interface {
+	// Stat returns the time the file was last written. If the file does not
+	// exist, an error wrapping fs.ErrNotExist is returned.
+	Stat(name string) (time.Time, error)
+}
+
+// fileCodec encodes and decodes the content of a single file.
+type fileCodec[E any] interface {
+	// kind is the name of the format used in error messages
+	kind() string
+	encode(w io.Writer, items []*E) error
+	decode(r io.Reader) ([]*E, error)
+}
+
 // PersistJSON returns a Persist that stores objects in JSON format.
 func PersistJSON[E any](baseFolder, suffix string) Persist[E] {
-	return persistJson[E]{
-		baseFolder: baseFolder,
-		suffix:     suffix,
+	return newPersistFiles[E](baseFolder, suffix, jsonCodec[E]{})
+}
+
+// PersistJSONIndent returns a Persist that stores objects in indented JSON
+// format, which is easier to read and to edit by hand. Each element begins on
+// a new line and is indented by the given indent according to its nesting.
+// The files are readable by PersistJSON and vice versa.
+func PersistJSONIndent[E any](baseFolder, suffix, indent string) Persist[E] {
+	return newPersistFiles[E](baseFolder, suffix, jsonCodec[E]{indent: indent})
+}
+
+// PersistJSONFunc returns a Persist that stores objects in JSON format like
+// PersistJSON, but the given functions are used to create the content of a
+// file and to read it. This allows to control the JSON representation of the
+// objects independently of their MarshalJSON methods, e.g. by using
+// json.MarshalIndent. If a function is nil, encoding/json is used.
+func PersistJSONFunc[E any](baseFolder, suffix string, marshal func([]*E) ([]byte, error), unmarshal func([]byte) ([]*E, error)) Persist[E] {
+	return newPersistFiles[E](baseFolder, suffix, jsonCodec[E]{marshal: marshal, unmarshal: unmarshal})
+}
+
+type jsonCodec[E any] struct {
+	indent    string
+	marshal   func([]*E) ([]byte, error)
+	unmarshal func([]byte) ([]*E, error)
+}
+
+func (jsonCodec[E]) kind() string {
+	return "json"
+}
+
+func (c jsonCodec[E]) encode(w io.Writer, items []*E) error {
+	var b []byte
+	var err error
+	switch {
+	case c.marshal != nil:
+		b, err = c.marshal(items)
+	case c.indent != "":
+		b, err = json.MarshalIndent(items, "", c.indent)
+	default:
+		b, err = json.Marshal(items)
+	}
+	if err != nil {
+		return fmt.Errorf("could not marshal json: %w", err)
 	}
+	_, err = w.Write(b)
+	if err != nil {
+		return fmt.Errorf("could not write file: %w", err)
+	}
+	return nil
 }
 
-type persistJson[E any] struct {
-	baseFolder string
-	suffix     string
+func (c jsonCodec[E]) decode(r io.Reader) ([]*E, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read json file: %w", err)
+	}
+	var items []*E
+	if c.unmarshal == nil {
+		err = json.Unmarshal(b, &items)
+	} else {
+		items, err = c.unmarshal(b)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal json file: %w", err)
+	}
+	return items, nil
 }
 
-func (p persistJson[E]) Persist(dbFile string, items []*E) error {
-	log.Println("persist", dbFile)
-	filePath := path.Join(p.baseFolder, dbFile+p.suffix)
-	if len(items) == 0 {
-		err := os.Remove(filePath)
+// PersistJSONL returns a Persist that stores objects in JSON lines format,
+// with one object per line. An inserted object is appended to its file instead
+// of rewriting the file. If the last line of a file is incomplete, e.g. because
+// the application crashed while writing it, it is ignored by Restore and
+// removed before the next object is appended. A last line which only lacks the
+// line break is read as usual. Appending is not possible if the
+// Persist is compressed or encrypted.
+func PersistJSONL[E any](baseFolder, suffix string) Persist[E] {
+	return newPersistFiles[E](baseFolder, suffix, jsonlCodec[E]{})
+}
+
+type jsonlCodec[E any] struct{}
+
+func (jsonlCodec[E]) kind() string {
+	return "jsonl"
+}
+
+func (c jsonlCodec[E]) encode(w io.Writer, items []*E) error {
+	for _, e := range items {
+		err := c.encodeItem(w, e)
 		if err != nil {
-			return fmt.Errorf("could not remove json file: %w", err)
+			return err
 		}
-	} else {
-		b, err := json.Marshal(items)
+	}
+	return nil
+}
+
+func (jsonlCodec[E]) encodeItem(w io.Writer, e *E) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not marshal json: %w", err)
+	}
+	_, err = w.Write(append(b, '\n'))
+	if err != nil {
+		return fmt.Errorf("could not write file: %w", err)
+	}
+	return nil
+}
+
+func (jsonlCodec[E]) decode(r io.Reader) ([]*E, error) {
+	br := bufio.NewReader(r)
+	var items []*E
+	for line := 1; ; line++ {
+		b, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(b)) > 0 {
+				// only the line break may be missing
+				var e E
+				if json.Unmarshal(b, &e) == nil {
+					items = append(items, &e)
+				} else {
+					log.Println("ignore incomplete last line", line)
+				}
+			}
+			return items, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read jsonl file: %w", err)
+		}
+		if len(bytes.TrimSpace(b)) == 0 {
+			continue
+		}
+		var e E
+		err = json.Unmarshal(b, &e)
+		if err != nil {
+			return nil, fmt.Errorf("could not unmarshal line %d: %w", line, err)
+		}
+		items = append(items, &e)
+	}
+}
+
+// repair removes an incomplete last line. If the last line can be decoded,
+// only the line break is missing, so it is added instead.
+func (jsonlCodec[E]) repair(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	buf := make([]byte, 4096)
+	end := size
+	for end > 0 {
+		start := max(end-int64(len(buf)), 0)
+		chunk := buf[:end-start]
+		_, err = f.ReadAt(chunk, start)
 		if err != nil {
-			return fmt.Errorf("could not marshal json: %w", err)
+			return err
+		}
+		i := bytes.LastIndexByte(chunk, '\n')
+		if i >= 0 {
+			end = start + int64(i) + 1
+			break
 		}
-		err = os.WriteFile(filePath, b, 0644)
+		end = start
+	}
+	if end == size {
+		return nil
+	}
+	last := make([]byte, size-end)
+	_, err = f.ReadAt(last, end)
+	if err != nil {
+		return err
+	}
+	var e E
+	if json.Unmarshal(last, &e) == nil {
+		log.Println("add missing line break to", f.Name())
+		_, err = f.Write([]byte{'\n'})
+		return err
+	}
+	log.Println("remove incomplete last line of", f.Name())
+	return f.Truncate(end)
+}
+
+// PersistSerializer returns a Persist that stores objects in binary format. It
+// is able to persist and restore interfaces. To do that the interface has to be
+// registered with serialize.Register.
+func PersistSerializer[E any](baseFolder, suffix string, serializer *serialize.Serializer) Persist[E] {
+	return newPersistFiles[E](baseFolder, suffix, serializerCodec[E]{serializer: serializer})
+}
+
+type serializerCodec[E any] struct {
+	serializer *serialize.Serializer
+}
+
+func (serializerCodec[E]) kind() string {
+	return "bin"
+}
+
+func (c serializerCodec[E]) encode(w io.Writer, items []*E) error {
+	err := c.serializer.Write(w, items)
+	if err != nil {
+		return fmt.Errorf("could not serialize data: %w", err)
+	}
+	return nil
+}
+
+func (c serializerCodec[E]) decode(r io.Reader) ([]*E, error) {
+	var items []*E
+	err := c.serializer.Read(r, &items)
+	if err != nil {
+		return nil, fmt.Errorf("could not read bin file: %w", err)
+	}
+	return items, nil
+}
+
+// PersistSerializerRecords returns a Persist that stores objects in binary
+// format like PersistSerializer. Each object is stored as a separate length
+// prefixed record, which allows to append an inserted object to its file
+// instead of rewriting the file. This makes inserts into large files much
+// faster. Appending is not possible if the Persist is compressed or encrypted.
+// The format is not compatible with the format of PersistSerializer.
+func PersistSerializerRecords[E any](baseFolder, suffix string, serializer *serialize.Serializer) Persist[E] {
+	return newPersistFiles[E](baseFolder, suffix, recordCodec[E]{serializer: serializer})
+}
+
+// appendCodec is implemented by codecs whose files can be extended by
+// appending objects.
+type appendCodec[E any] interface {
+	fileCodec[E]
+	// encodeItem writes a single object in a way that can be appended to an
+	// existing file.
+	encodeItem(w io.Writer, e *E) error
+}
+
+// repairCodec is implemented by append codecs which are able to remove an
+// incomplete object at the end of a file before a new object is appended.
+type repairCodec interface {
+	repair(f *os.File) error
+}
+
+type recordCodec[E any] struct {
+	serializer *serialize.Serializer
+}
+
+func (recordCodec[E]) kind() string {
+	return "record"
+}
+
+func (c recordCodec[E]) encode(w io.Writer, items []*E) error {
+	for _, e := range items {
+		err := c.encodeItem(w, e)
 		if err != nil {
-			return fmt.Errorf("could not write file: %w", err)
+			return err
 		}
 	}
 	return nil
 }
 
-func (p persistJson[E]) Restore() ([]*E, error) {
-	dir, err := os.Open(p.baseFolder)
+func (c recordCodec[E]) encodeItem(w io.Writer, e *E) error {
+	var b bytes.Buffer
+	err := c.serializer.Write(&b, e)
 	if err != nil {
-		return nil, fmt.Errorf("could not open base folder: %w", err)
+		return fmt.Errorf("could not serialize data: %w", err)
 	}
-	names, err := dir.ReadDir(-1)
+	var l [binary.MaxVarintLen64]byte
+	_, err = w.Write(l[:binary.PutUvarint(l[:], uint64(b.Len()))])
 	if err != nil {
-		return nil, fmt.Errorf("could not scan base folder: %w", err)
+		return fmt.Errorf("could not write file: %w", err)
 	}
-	err = dir.Close()
+	_, err = w.Write(b.Bytes())
 	if err != nil {
-		return nil, fmt.Errorf("could not close base folder: %w", err)
+		return fmt.Errorf("could not write file: %w", err)
 	}
+	return nil
+}
 
-	var allItems []*E
-	for _, n := range names {
-		name := n.Name()
-		if strings.HasSuffix(name, p.suffix) {
-			items, err2 := p.readFile(name)
-			if err2 != nil {
-				return nil, err2
+func (c recordCodec[E]) decode(r io.Reader) ([]*E, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		b := bufio.NewReader(r)
+		br, r = b, b
+	}
+	var items []*E
+	var buf bytes.Buffer
+	for {
+		l, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read record length: %w", err)
+		}
+		if l > math.MaxInt64 {
+			return nil, fmt.Errorf("invalid record length %d, data corrupt?", l)
+		}
+		// the buffer grows with the data actually read, so a corrupt length
+		// does not cause a huge allocation
+		buf.Reset()
+		n, err := io.CopyN(&buf, r, int64(l))
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
 			}
-			allItems = append(allItems, items...)
+			return nil, fmt.Errorf("could not read record of %d bytes, found %d: %w", l, n, err)
 		}
+		var e E
+		err = c.serializer.Read(&buf, &e)
+		if err != nil {
+			return nil, fmt.Errorf("could not read record: %w", err)
+		}
+		items = append(items, &e)
 	}
+}
 
-	return allItems, nil
+// PersistGob returns a Persist that stores objects using encoding/gob.
+// Interfaces can be persisted if the concrete types are registered with
+// gob.Register.
+func PersistGob[E any](baseFolder, suffix string) Persist[E] {
+	return newPersistFiles[E](baseFolder, suffix, gobCodec[E]{})
 }
 
-func LogClose(c io.Closer) {
-	err := c.Close()
+type gobCodec[E any] struct{}
+
+func (gobCodec[E]) kind() string {
+	return "gob"
+}
+
+func (gobCodec[E]) encode(w io.Writer, items []*E) error {
+	err := gob.NewEncoder(w).Encode(items)
 	if err != nil {
-		log.Println("could not close:", err)
+		return fmt.Errorf("could not encode gob: %w", err)
 	}
+	return nil
 }
 
-func (p persistJson[E]) readFile(name string) ([]*E, error) {
-	jsonFile := path.Join(p.baseFolder, name)
-	log.Println("read", name)
-	f, err := os.Open(jsonFile)
+func (gobCodec[E]) decode(r io.Reader) ([]*E, error) {
+	var items []*E
+	err := gob.NewDecoder(r).Decode(&items)
 	if err != nil {
-		return nil, fmt.Errorf("could not open json file: %w", err)
+		return nil, fmt.Errorf("could not read gob file: %w", err)
 	}
-	defer LogClose(f)
+	return items, nil
+}
+
+// PersistMemory returns a Persist that stores objects in memory using the
+// serializer. The files are lost if the program ends, but Restore returns all
+// objects stored by Persist before. This is useful for tests and for
+// temporary data, as the complete persistence code path is used without
+// accessing the file system.
+func PersistMemory[E any]() Persist[E] {
+	return &persistMemory[E]{
+		codec:    serializerCodec[E]{serializer: serialize.New()},
+		files:    map[string][]byte{},
+		modTimes: map[string]time.Time{},
+	}
+}
+
+type persistMemory[E any] struct {
+	m        sync.Mutex
+	codec    serializerCodec[E]
+	files    map[string][]byte
+	modTimes map[string]time.Time
+}
 
-	b, err := io.ReadAll(f)
+func (p *persistMemory[E]) Persist(name string, items []*E) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if len(items) == 0 {
+		delete(p.files, name)
+		delete(p.modTimes, name)
+		return nil
+	}
+	var b bytes.Buffer
+	err := p.codec.encode(&b, items)
 	if err != nil {
-		return nil, fmt.Errorf("could not read json file: %w", err)
+		return err
 	}
-	var items []*E
-	err = json.Unmarshal(b, &items)
+	p.files[name] = b.Bytes()
+	p.modTimes[name] = time.Now()
+	return nil
+}
+
+func (p *persistMemory[E]) Stat(name string) (time.Time, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	t, ok := p.modTimes[name]
+	if !ok {
+		return time.Time{}, fmt.Errorf("file %s: %w", name, fs.ErrNotExist)
+	}
+	return t, nil
+}
+
+func (p *persistMemory[E]) Restore() ([]*E, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	var allItems []*E
+	for _, name := range p.list() {
+		items, err := p.codec.decode(bytes.NewReader(p.files[name]))
+		if err != nil {
+			return nil, fmt.Errorf("error in file %s: %w", name, err)
+		}
+		allItems = append(allItems, items...)
+	}
+	return allItems, nil
+}
+
+func (p *persistMemory[E]) List() ([]string, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return p.list(), nil
+}
+
+// list returns the sorted names of the files. The caller has to hold the lock.
+func (p *persistMemory[E]) list() []string {
+	names := make([]string, 0, len(p.files))
+	for name := range p.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// streamTransform transforms the byte stream of a file, e.g. by compressing
+// or encrypting it.
+type streamTransform interface {
+	// suffix is appended to the file suffix
+	suffix() string
+	// writer wraps the given writer. The returned writer is closed after
+	// all data is written, but must not close w.
+	writer(w io.Writer) (io.WriteCloser, error)
+	// reader wraps the given reader.
+	reader(r io.Reader) (io.Reader, error)
+}
+
+// streamPersist is implemented by all Persist implementations whose files
+// can be wrapped by a streamTransform.
+type streamPersist[E any] interface {
+	Persist[E]
+	withTransform(t streamTransform) Persist[E]
+	withSkipCorrupt() Persist[E]
+	withDurable() Persist[E]
+	withFileMode(mode os.FileMode) Persist[E]
+	withFilter(filter func(dbFile string) bool) Persist[E]
+	withFS(fsys fs.FS) Persist[E]
+	// fileName returns the name of the file the given db file is stored in
+	fileName(dbFile string) string
+	// encode writes the content of a file to w
+	encode(w io.Writer, items []*E) error
+	// encodePlain encodes the items by the codec only, without the
+	// transformations
+	encodePlain(w io.Writer, items []*E) error
+}
+
+// wrapStream adds the transformation to the inner Persist. It panics if the
+// inner Persist is not file based.
+func wrapStream[E any](inner Persist[E], t streamTransform) Persist[E] {
+	sp, ok := inner.(streamPersist[E])
+	if !ok {
+		panic(fmt.Sprintf("persist %T does not support stream transformations", inner))
+	}
+	return sp.withTransform(t)
+}
+
+// PersistSkipCorrupt returns a Persist that skips files which can not be read
+// during the restore, instead of aborting it. In this case Restore returns all
+// elements of the files that could be read together with a *SkippedFilesError
+// describing the skipped files. The inner Persist has to be file based, see
+// Persist.
+func PersistSkipCorrupt[E any](inner Persist[E]) Persist[E] {
+	sp, ok := inner.(streamPersist[E])
+	if !ok {
+		panic(fmt.Sprintf("persist %T does not support skipping corrupt files", inner))
+	}
+	return sp.withSkipCorrupt()
+}
+
+// PersistDurable returns a Persist that syncs each written file and its
+// folder to the disk before Persist returns, so that a successfully persisted
+// file survives a power loss. This makes writing considerably slower. The
+// inner Persist has to be file based.
+func PersistDurable[E any](inner Persist[E]) Persist[E] {
+	sp, ok := inner.(streamPersist[E])
+	if !ok {
+		panic(fmt.Sprintf("persist %T does not support durable writes", inner))
+	}
+	return sp.withDurable()
+}
+
+// PersistFileMode returns a Persist that creates the files with the given
+// permissions instead of 0644. The base folder, if created, gets the same
+// permissions plus the execute bits for everyone who is allowed to read. The
+// inner Persist has to be file based.
+func PersistFileMode[E any](inner Persist[E], mode os.FileMode) Persist[E] {
+	sp, ok := inner.(streamPersist[E])
+	if !ok {
+		panic(fmt.Sprintf("persist %T does not support file modes", inner))
+	}
+	return sp.withFileMode(mode)
+}
+
+// PersistDryRun returns a Persist that encodes the objects like the inner
+// Persist, including all transformations, but discards the result instead of
+// writing it. So all encoding errors are reported without modifying any file,
+// which is useful to validate a large dataset before it is written. Restore
+// reads the files of the inner Persist, which has to be file based. The
+// returned Persist is not file based itself.
+func PersistDryRun[E any](inner Persist[E]) Persist[E] {
+	sp, ok := inner.(streamPersist[E])
+	if !ok {
+		panic(fmt.Sprintf("persist %T does not support dry runs", inner))
+	}
+	return persistDryRun[E]{inner: sp}
+}
+
+type persistDryRun[E any] struct {
+	inner streamPersist[E]
+}
+
+func (p persistDryRun[E]) Persist(dbFile string, items []*E) error {
+	if len(items) == 0 {
+		return nil
+	}
+	err := p.inner.encode(io.Discard, items)
 	if err != nil {
-		return nil, fmt.Errorf("could not unmarshal json file: %w", err)
+		return fmt.Errorf("could not encode file %s: %w", dbFile, err)
 	}
+	return nil
+}
 
-	return items, nil
+func (p persistDryRun[E]) Restore() ([]*E, error) {
+	return p.inner.Restore()
 }
 
-// PersistSerializer returns a Persist that stores objects in binary format. It
-// is able to persist and restore interfaces. To do that the interface has to be
-// registered with serialize.Register.
-func PersistSerializer[E any](baseFolder, suffix string, serializer *serialize.Serializer) Persist[E] {
-	return &persistSerializer[E]{
+// PersistFilter returns a Persist that only restores and lists the files
+// for which the filter returns true. The filter is called with the name of
+// the file as returned by the NameProvider, so without the suffix. This
+// allows several tables to share a folder if the suffix of one table is also a
+// suffix of the files of another table, e.g. "_db.json" and "_users_db.json".
+// The inner Persist has to be file based.
+func PersistFilter[E any](inner Persist[E], filter func(dbFile string) bool) Persist[E] {
+	sp, ok := inner.(streamPersist[E])
+	if !ok {
+		panic(fmt.Sprintf("persist %T does not support filters", inner))
+	}
+	return sp.withFilter(filter)
+}
+
+// ErrReadOnly is returned if a Persist created by PersistFS is asked to
+// write a file.
+var ErrReadOnly = errors.New("persist is read only")
+
+// PersistFS returns a Persist that reads the files from the given file system
+// instead of the disk, e.g. a seed database embedded by go:embed. The base
+// folder of the inner Persist is used as the path of the folder in fsys, so it
+// has to be a relative path as required by fs.ValidPath, otherwise this
+// function panics. The file system is read only, so writing a file returns
+// ErrReadOnly. The inner Persist has to be file based.
+func PersistFS[E any](inner Persist[E], fsys fs.FS) Persist[E] {
+	sp, ok := inner.(streamPersist[E])
+	if !ok {
+		panic(fmt.Sprintf("persist %T does not support file systems", inner))
+	}
+	return sp.withFS(fsys)
+}
+
+// SkippedFilesError is returned by Restore if files have been skipped because
+// they could not be read.
+type SkippedFilesError struct {
+	// Files contains the names of the skipped files
+	Files []string
+	// Errs contains the errors that occurred while reading the files
+	Errs []error
+}
+
+func (s *SkippedFilesError) Error() string {
+	return fmt.Sprintf("skipped %d corrupt files: %v", len(s.Files), errors.Join(s.Errs...))
+}
+
+func (s *SkippedFilesError) Unwrap() []error {
+	return s.Errs
+}
+
+// persistFiles stores each file in the base folder. The file content is
+// created by the codec.
+type persistFiles[E any] struct {
+	baseFolder  string
+	suffix      string
+	codec       fileCodec[E]
+	transforms  []streamTransform
+	skipCorrupt bool
+	durable     bool
+	mode        os.FileMode
+	filter      func(dbFile string) bool
+	// fsys is the file system the files are read from, and dir is the path
+	// of the base folder in it. By default, this is the base folder on the
+	// disk.
+	fsys fs.FS
+	dir  string
+	// readOnly is set if the files are read from a file system given by
+	// PersistFS, which can not be written
+	readOnly bool
+}
+
+func newPersistFiles[E any](baseFolder, suffix string, codec fileCodec[E]) *persistFiles[E] {
+	folder := baseFolder
+	if folder == "" {
+		folder = "."
+	}
+	return &persistFiles[E]{
 		baseFolder: baseFolder,
 		suffix:     suffix,
-		serializer: serializer,
+		codec:      codec,
+		mode:       0644,
+		fsys:       os.DirFS(folder),
+		dir:        ".",
 	}
 }
 
-type persistSerializer[E any] struct {
-	baseFolder string
-	suffix     string
-	serializer *serialize.Serializer
+func (p *persistFiles[E]) withTransform(t streamTransform) Persist[E] {
+	n := *p
+	n.suffix += t.suffix()
+	n.transforms = append(append([]streamTransform{}, p.transforms...), t)
+	return &n
+}
+
+func (p *persistFiles[E]) withSkipCorrupt() Persist[E] {
+	n := *p
+	n.skipCorrupt = true
+	return &n
+}
+
+func (p *persistFiles[E]) withDurable() Persist[E] {
+	n := *p
+	n.durable = true
+	return &n
+}
+
+func (p *persistFiles[E]) withFileMode(mode os.FileMode) Persist[E] {
+	n := *p
+	n.mode = mode.Perm()
+	return &n
+}
+
+func (p *persistFiles[E]) withFilter(filter func(dbFile string) bool) Persist[E] {
+	n := *p
+	n.filter = filter
+	return &n
+}
+
+func (p *persistFiles[E]) withFS(fsys fs.FS) Persist[E] {
+	dir := path.Clean(p.baseFolder)
+	if !fs.ValidPath(dir) {
+		panic(fmt.Sprintf("base folder %q is not a valid path in a file system", p.baseFolder))
+	}
+	n := *p
+	n.fsys = fsys
+	n.dir = dir
+	n.readOnly = true
+	return &n
+}
+
+// matches returns true if the file with the given name belongs to this
+// Persist. If so, the name of the db file is returned.
+func (p *persistFiles[E]) matches(n os.DirEntry) (string, bool) {
+	name := n.Name()
+	if n.IsDir() || !strings.HasSuffix(name, p.suffix) {
+		return "", false
+	}
+	dbFile := strings.TrimSuffix(name, p.suffix)
+	if p.filter != nil && !p.filter(dbFile) {
+		return "", false
+	}
+	return dbFile, true
+}
+
+// createFolder creates the base folder if it does not exist.
+func (p *persistFiles[E]) createFolder() error {
+	err := os.MkdirAll(p.baseFolder, p.mode|(p.mode&0444)>>2)
+	if err != nil {
+		return fmt.Errorf("could not create base folder: %w", err)
+	}
+	return nil
+}
+
+func (p *persistFiles[E]) fileName(dbFile string) string {
+	return dbFile + p.suffix
 }
 
-func (p *persistSerializer[E]) Persist(dbFile string, items []*E) error {
+func (p *persistFiles[E]) Persist(dbFile string, items []*E) error {
+	if p.readOnly {
+		return fmt.Errorf("could not write %s: %w", dbFile, ErrReadOnly)
+	}
 	log.Println("persist", dbFile)
-	filePath := path.Join(p.baseFolder, dbFile+p.suffix)
+	filePath := path.Join(p.baseFolder, p.fileName(dbFile))
 	if len(items) == 0 {
 		err := os.Remove(filePath)
 		if err != nil {
-			return fmt.Errorf("could not remove bin file: %w", err)
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return fmt.Errorf("could not remove %s file: %w", p.codec.kind(), err)
+		}
+		if p.durable {
+			return syncDir(p.baseFolder)
 		}
 	} else {
-		f, err := os.Create(filePath)
+		err := p.createFolder()
 		if err != nil {
-			return fmt.Errorf("could not create file: %w", err)
+			return err
 		}
-		defer LogClose(f)
-		buf := bufio.NewWriter(f)
-		defer buf.Flush()
-		err = p.serializer.Write(buf, items)
+		err = writeAtomic(filePath, p.mode, p.durable, func(w io.Writer) error {
+			return p.encode(w, items)
+		})
 		if err != nil {
-			return fmt.Errorf("could not serialize data: %w", err)
+			return err
 		}
 	}
 	return nil
 }
 
-func (p *persistSerializer[E]) Restore() ([]*E, error) {
-	dir, err := os.Open(p.baseFolder)
+// Append appends the object to the file if the codec supports this and no
+// transformations are used.
+func (p *persistFiles[E]) Append(dbFile string, e *E) error {
+	ac, ok := p.codec.(appendCodec[E])
+	if !ok || len(p.transforms) > 0 || p.readOnly {
+		return ErrAppendNotSupported
+	}
+
+	log.Println("append", dbFile)
+	filePath := path.Join(p.baseFolder, p.fileName(dbFile))
+	var b bytes.Buffer
+	err := ac.encodeItem(&b, e)
+	if err != nil {
+		return err
+	}
+	err = p.createFolder()
 	if err != nil {
-		return nil, fmt.Errorf("could not open base folder: %w", err)
+		return err
 	}
-	names, err := dir.ReadDir(-1)
+	f, err := os.OpenFile(filePath, os.O_RDWR|os.O_APPEND|os.O_CREATE, p.mode)
 	if err != nil {
+		return fmt.Errorf("could not open %s file %s: %w", p.codec.kind(), filePath, err)
+	}
+	if rc, ok := p.codec.(repairCodec); ok {
+		err = rc.repair(f)
+		if err != nil {
+			LogClose(f)
+			return fmt.Errorf("could not repair %s file %s: %w", p.codec.kind(), filePath, err)
+		}
+	}
+	info, err := f.Stat()
+	if err != nil {
+		LogClose(f)
+		return fmt.Errorf("could not stat %s file %s: %w", p.codec.kind(), filePath, err)
+	}
+	_, err = f.Write(b.Bytes())
+	if err != nil {
+		// remove the incomplete record, so that the file stays readable
+		if terr := f.Truncate(info.Size()); terr != nil {
+			log.Println(terr)
+		}
+		LogClose(f)
+		return fmt.Errorf("could not append to %s file %s: %w", p.codec.kind(), filePath, err)
+	}
+	if p.durable {
+		err = syncFile(f)
+		if err != nil {
+			LogClose(f)
+			return fmt.Errorf("could not sync %s file %s: %w", p.codec.kind(), filePath, err)
+		}
+	}
+	return f.Close()
+}
+
+// encode applies the transformations and encodes the items. The last
+// transformation added is the one closest to the file.
+func (p *persistFiles[E]) encode(w io.Writer, items []*E) error {
+	var closer []io.Closer
+	for i := len(p.transforms) - 1; i >= 0; i-- {
+		tw, err := p.transforms[i].writer(w)
+		if err != nil {
+			return err
+		}
+		closer = append(closer, tw)
+		w = tw
+	}
+
+	err := p.codec.encode(w, items)
+	if err != nil {
+		return err
+	}
+
+	for i := len(closer) - 1; i >= 0; i-- {
+		err = closer[i].Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *persistFiles[E]) encodePlain(w io.Writer, items []*E) error {
+	return p.codec.encode(w, items)
+}
+
+// readDir returns the entries of the base folder sorted by name, so that the
+// files are always restored in the same order. If the base folder does not
+// exist, there are no entries. The folder is created by the first write.
+func (p *persistFiles[E]) readDir() ([]os.DirEntry, error) {
+	names, err := fs.ReadDir(p.fsys, p.dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
 		return nil, fmt.Errorf("could not scan base folder: %w", err)
 	}
-	err = dir.Close()
+	return names, nil
+}
+
+// List returns the sorted names of all files in the base folder having the
+// suffix. The suffix is removed from the names.
+func (p *persistFiles[E]) List() ([]string, error) {
+	names, err := p.readDir()
 	if err != nil {
-		return nil, fmt.Errorf("could not close base folder: %w", err)
+		return nil, err
+	}
+	var files []string
+	for _, n := range names {
+		if dbFile, ok := p.matches(n); ok {
+			files = append(files, dbFile)
+		}
 	}
+	sort.Strings(files)
+	return files, nil
+}
 
-	var allItems []*E
+// Stat returns the modification time of the file.
+func (p *persistFiles[E]) Stat(dbFile string) (time.Time, error) {
+	fi, err := fs.Stat(p.fsys, path.Join(p.dir, p.fileName(dbFile)))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// Restore reads all files in the base folder. If the base folder does not
+// exist, the database is empty.
+func (p *persistFiles[E]) Restore() ([]*E, error) {
+	names, err := p.readDir()
+	if err != nil {
+		return nil, err
+	}
 
+	allItems := []*E{}
+	var skipped *SkippedFilesError
 	for _, n := range names {
 		name := n.Name()
-		if strings.HasSuffix(name, p.suffix) {
+		if _, ok := p.matches(n); ok {
 			items, err := p.readFile(name)
 			if err != nil {
-				return nil, err
+				if !p.skipCorrupt {
+					return nil, err
+				}
+				log.Println("skip corrupt file", name, err)
+				if skipped == nil {
+					skipped = &SkippedFilesError{}
+				}
+				skipped.Files = append(skipped.Files, name)
+				skipped.Errs = append(skipped.Errs, err)
+				continue
 			}
-
 			allItems = append(allItems, items...)
 		}
 	}
 
+	if skipped != nil {
+		return allItems, skipped
+	}
 	return allItems, nil
 }
 
-func (p *persistSerializer[E]) readFile(name string) ([]*E, error) {
-	binFile := path.Join(p.baseFolder, name)
+func (p *persistFiles[E]) readFile(name string) ([]*E, error) {
+	filePath := path.Join(p.baseFolder, name)
 	log.Println("read", name)
 
-	f, err := os.Open(binFile)
+	f, err := p.fsys.Open(path.Join(p.dir, name))
 	if err != nil {
-		return nil, fmt.Errorf("could not open bin file: %w", err)
+		return nil, fmt.Errorf("could not open %s file %s: %w", p.codec.kind(), filePath, err)
 	}
 	defer LogClose(f)
 
-	var items []*E
-	err = p.serializer.Read(bufio.NewReader(f), &items)
+	var r io.Reader = bufio.NewReader(f)
+	for i := len(p.transforms) - 1; i >= 0; i-- {
+		r, err = p.transforms[i].reader(r)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s file %s: %w", p.codec.kind(), filePath, err)
+		}
+	}
+
+	items, err := p.codec.decode(r)
 	if err != nil {
-		return nil, fmt.Errorf("could not read bin file: %w", err)
+		return nil, fmt.Errorf("error in file %s: %w", filePath, err)
+	}
+	if len(p.transforms) > 0 {
+		// some codecs stop reading before the end of the stream, but the
+		// transformations check e.g. checksums only at its end
+		_, err = io.Copy(io.Discard, r)
+		if err != nil {
+			return nil, fmt.Errorf("error in file %s: %w", filePath, err)
+		}
 	}
 	return items, nil
 }
+
+// writeAtomic writes a file by writing to a temporary file in the same folder
+// which is renamed to the target file if writing was successful. So the target
+// file is either replaced completely or left untouched. The removal of a file
+// needs no such treatment, because os.Remove is atomic by itself. If durable
+// is set, the file and the folder are synced to the disk. The file is created
+// with the given permissions.
+func writeAtomic(filePath string, mode os.FileMode, durable bool, write func(w io.Writer) error) error {
+	dir, file := path.Split(filePath)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.CreateTemp(dir, "."+file+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create file: %w", err)
+	}
+	tmpPath := f.Name()
+
+	buf := bufio.NewWriter(f)
+	err = write(buf)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil {
+		err = f.Chmod(mode)
+	}
+	if err == nil && durable {
+		err = syncFile(f)
+		if err != nil {
+			err = fmt.Errorf("could not sync file: %w", err)
+		}
+	}
+	if err != nil {
+		LogClose(f)
+		removeTemp(tmpPath)
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		removeTemp(tmpPath)
+		return fmt.Errorf("could not close file: %w", err)
+	}
+
+	err = os.Rename(tmpPath, filePath)
+	if err != nil {
+		removeTemp(tmpPath)
+		return fmt.Errorf("could not rename file: %w", err)
+	}
+	if durable {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+// syncFile syncs the file to the disk. It is a variable to allow the tests
+// to observe the sync calls.
+var syncFile = func(f *os.File) error {
+	return f.Sync()
+}
+
+// syncDir syncs the folder to the disk, which is required to make the
+// creation, the renaming or the removal of a file in the folder durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("could not open folder: %w", err)
+	}
+	err = syncFile(d)
+	if err != nil {
+		LogClose(d)
+		return fmt.Errorf("could not sync folder: %w", err)
+	}
+	return d.Close()
+}
+
+func removeTemp(tmpPath string) {
+	err := os.Remove(tmpPath)
+	if err != nil {
+		log.Println("could not remove temp file:", err)
+	}
+}
+
+func LogClose(c io.Closer) {
+	err := c.Close()
+	if err != nil {
+		log.Println("could not close:", err)
+	}
+}
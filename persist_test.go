@@ -0,0 +1,1101 @@
+package objectDB
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"github.com/hneemann/objectDB/serialize"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func date(y int, m time.Month, d int) *time.Time {
+	t := time.Date(y, m, d, 12, 0, 0, 0, time.UTC)
+	return &t
+}
+
+func identity(t *time.Time) time.Time {
+	return *t
+}
+
+func TestDaily(t *testing.T) {
+	np := Daily[time.Time]("d", identity)
+	assert.EqualValues(t, "d_2024_01_05", np.ToFile(date(2024, 1, 5)))
+	assert.EqualValues(t, "d_2024_12_31", np.ToFile(date(2024, 12, 31)))
+	assert.True(t, np.SameFile(date(2024, 3, 1), date(2024, 3, 1)))
+	assert.False(t, np.SameFile(date(2024, 3, 1), date(2024, 3, 2)))
+	assert.False(t, np.SameFile(date(2024, 3, 1), date(2023, 3, 1)))
+
+	assert.EqualValues(t, "2024_01_05", Daily[time.Time]("", identity).ToFile(date(2024, 1, 5)))
+}
+
+func TestWeekly(t *testing.T) {
+	np := Weekly[time.Time]("w", identity)
+	assert.EqualValues(t, "w_2024_W03", np.ToFile(date(2024, 1, 17)))
+	// 2020 has 53 ISO weeks
+	assert.EqualValues(t, "w_2020_W53", np.ToFile(date(2020, 12, 31)))
+	assert.EqualValues(t, "w_2020_W53", np.ToFile(date(2021, 1, 3)))
+	assert.EqualValues(t, "w_2021_W01", np.ToFile(date(2021, 1, 4)))
+	// 2024-12-30 belongs to the first week of 2025
+	assert.EqualValues(t, "w_2025_W01", np.ToFile(date(2024, 12, 30)))
+
+	assert.True(t, np.SameFile(date(2020, 12, 31), date(2021, 1, 3)))
+	assert.False(t, np.SameFile(date(2021, 1, 3), date(2021, 1, 4)))
+	assert.True(t, np.SameFile(date(2024, 12, 30), date(2025, 1, 5)))
+	// same week number, different year
+	assert.False(t, np.SameFile(date(2023, 1, 17), date(2024, 1, 17)))
+}
+
+func TestYearly(t *testing.T) {
+	np := Yearly[time.Time]("y", identity)
+	assert.EqualValues(t, "y_2024", np.ToFile(date(2024, 1, 5)))
+	assert.True(t, np.SameFile(date(2024, 1, 1), date(2024, 12, 31)))
+	assert.False(t, np.SameFile(date(2023, 12, 31), date(2024, 1, 1)))
+}
+
+type order struct {
+	Tenant string
+	Amount int
+}
+
+func TestByKey(t *testing.T) {
+	np := ByKey[order]("t", func(o *order) string { return o.Tenant })
+	assert.EqualValues(t, "t_acme", np.ToFile(&order{Tenant: "acme"}))
+	assert.True(t, np.SameFile(&order{Tenant: "acme", Amount: 1}, &order{Tenant: "acme", Amount: 2}))
+	assert.False(t, np.SameFile(&order{Tenant: "acme"}, &order{Tenant: "other"}))
+	assert.EqualValues(t, "acme", ByKey[order]("", func(o *order) string { return o.Tenant }).ToFile(&order{Tenant: "acme"}))
+
+	p := PersistMemory[order]()
+	table, err := New[order](np, p, nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(&order{Tenant: "acme", Amount: 1}))
+	assert.NoError(t, table.Insert(&order{Tenant: "globex", Amount: 2}))
+	assert.NoError(t, table.Insert(&order{Tenant: "acme", Amount: 3}))
+
+	files, err := p.(Lister).List()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"t_acme", "t_globex"}, files)
+
+	restored, err := New[order](np, p, nil, nil)
+	assert.NoError(t, err)
+	acme := restored.Match(func(o *order) bool { return o.Tenant == "acme" })
+	assert.EqualValues(t, 2, acme.Size())
+}
+
+func TestSharded(t *testing.T) {
+	np := Sharded[string](8, func(s *string) string { return *s })
+
+	a := "key"
+	assert.EqualValues(t, np.ToFile(&a), np.ToFile(&a))
+	assert.EqualValues(t, "shard_4", np.ToFile(&a))
+
+	counts := map[string]int{}
+	for i := 0; i < 8000; i++ {
+		k := strconv.Itoa(i)
+		counts[np.ToFile(&k)]++
+	}
+	assert.EqualValues(t, 8, len(counts))
+	for _, c := range counts {
+		assert.True(t, c > 800 && c < 1200)
+	}
+
+	b := "other"
+	assert.EqualValues(t, np.ToFile(&a) == np.ToFile(&b), np.SameFile(&a, &b))
+}
+
+func TestRollingFile(t *testing.T) {
+	np := RollingFile[int]("r", 100, func(i *int) int { return *i })
+	n := func(i int) *int { return &i }
+	assert.EqualValues(t, "r_0", np.ToFile(n(0)))
+	assert.EqualValues(t, "r_0", np.ToFile(n(99)))
+	assert.EqualValues(t, "r_1", np.ToFile(n(100)))
+	assert.EqualValues(t, "r_1", np.ToFile(n(199)))
+	assert.EqualValues(t, "r_2", np.ToFile(n(200)))
+	assert.True(t, np.SameFile(n(0), n(99)))
+	assert.False(t, np.SameFile(n(99), n(100)))
+	assert.True(t, np.SameFile(n(100), n(199)))
+
+	assert.EqualValues(t, "5", RollingFile[int]("", 1, func(i *int) int { return *i }).ToFile(n(5)))
+}
+
+func TestGobInterface(t *testing.T) {
+	gob.Register(stringer{})
+	p := PersistGob[withInterface]("testdata", "_db.gob")
+
+	items := []*withInterface{{N: 1, S: stringer{S: "a"}}, {N: 2, S: stringer{S: "b"}}}
+	assert.NoError(t, p.Persist("gob", items))
+
+	restored, err := p.Restore()
+	assert.NoError(t, err)
+	assert.EqualValues(t, items, restored)
+
+	assert.NoError(t, p.Persist("gob", nil))
+}
+
+func TestRemoveMissingFile(t *testing.T) {
+	assert.NoError(t, PersistJSON[time.Time]("testdata", "_db.json").Persist("never", nil))
+	assert.NoError(t, PersistSerializer[time.Time]("testdata", "_db.bin", serialize.New()).Persist("never", nil))
+
+	table, err := New[time.Time](myMonthly, PersistSerializer[time.Time]("testdata", "_db.bin", serialize.New()), nil, nil)
+	assert.NoError(t, err)
+	table.SetWriteDelay(10)
+	defer table.Shutdown()
+
+	assert.NoError(t, table.Insert(add(time.Now(), 0)))
+	r := table.Match(func(e *time.Time) bool { return true })
+	assert.NoError(t, r.Delete(0))
+	assert.NoError(t, table.Flush())
+	assert.NoError(t, table.Insert(add(time.Now(), 0)))
+
+	r = table.Match(func(e *time.Time) bool { return true })
+	assert.NoError(t, r.Delete(0))
+	assert.NoError(t, table.Flush())
+}
+
+func TestSkipCorrupt(t *testing.T) {
+	p := PersistJSON[time.Time]("testdata", "_db.json")
+	assert.NoError(t, p.Persist("a", []*time.Time{date(2024, 1, 1)}))
+	assert.NoError(t, p.Persist("b", []*time.Time{date(2024, 2, 1)}))
+	assert.NoError(t, p.Persist("c", []*time.Time{date(2024, 3, 1)}))
+	defer func() {
+		for _, n := range []string{"a", "b", "c"} {
+			assert.NoError(t, p.Persist(n, nil))
+		}
+	}()
+
+	// truncate the file b
+	b, err := os.ReadFile("testdata/b_db.json")
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile("testdata/b_db.json", b[:len(b)/2], 0644))
+
+	_, err = p.Restore()
+	assert.Error(t, err)
+
+	restored, err := PersistSkipCorrupt(p).Restore()
+	var skipped *SkippedFilesError
+	assert.True(t, errors.As(err, &skipped))
+	assert.EqualValues(t, []string{"b_db.json"}, skipped.Files)
+	assert.EqualValues(t, 2, len(restored))
+
+	table, err := New[time.Time](myMonthly, PersistSkipCorrupt(p), nil, nil)
+	assert.True(t, errors.As(err, &skipped))
+	assert.EqualValues(t, 2, table.Size())
+}
+
+func TestRestoreErrorContainsFileName(t *testing.T) {
+	json := PersistJSON[time.Time]("testdata", "_db.json")
+	bin := PersistSerializer[time.Time]("testdata", "_db.bin", serialize.New())
+	for _, p := range []Persist[time.Time]{json, bin} {
+		assert.NoError(t, p.Persist("ok", []*time.Time{date(2024, 1, 1)}))
+		assert.NoError(t, p.Persist("broken", []*time.Time{date(2024, 2, 1), date(2024, 2, 2)}))
+	}
+	defer func() {
+		for _, p := range []Persist[time.Time]{json, bin} {
+			assert.NoError(t, p.Persist("ok", nil))
+			assert.NoError(t, p.Persist("broken", nil))
+		}
+	}()
+
+	for _, f := range []string{"testdata/broken_db.json", "testdata/broken_db.bin"} {
+		b, err := os.ReadFile(f)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(f, b[:len(b)/2], 0644))
+	}
+
+	_, err := json.Restore()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "testdata/broken_db.json")
+
+	_, err = bin.Restore()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "testdata/broken_db.bin")
+}
+
+func openFiles() int {
+	files, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(files)
+}
+
+// fdProbe is a transformation that records the maximum number of open files
+// seen while the files are read.
+type fdProbe struct {
+	max *int
+}
+
+func (fdProbe) suffix() string {
+	return ""
+}
+
+func (fdProbe) writer(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+func (f fdProbe) reader(r io.Reader) (io.Reader, error) {
+	if n := openFiles(); n > *f.max {
+		*f.max = n
+	}
+	return r, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestRestoreClosesFiles(t *testing.T) {
+	before := openFiles()
+	if before < 0 {
+		t.Skip("can not count open files")
+	}
+
+	const count = 300
+	var max int
+	p := PersistJSON[time.Time]("testdata", "_db.json").(streamPersist[time.Time]).withTransform(fdProbe{max: &max})
+	for i := 0; i < count; i++ {
+		assert.NoError(t, p.Persist("f"+strconv.Itoa(i), []*time.Time{date(2024, 1, 1)}))
+	}
+	defer func() {
+		for i := 0; i < count; i++ {
+			assert.NoError(t, p.Persist("f"+strconv.Itoa(i), nil))
+		}
+	}()
+
+	restored, err := p.Restore()
+	assert.NoError(t, err)
+	assert.EqualValues(t, count, len(restored))
+	assert.InDelta(t, before, max, 5)
+}
+
+func TestSerializerRecords(t *testing.T) {
+	p := PersistSerializerRecords[time.Time]("testdata", "_db.rec", serialize.New())
+	assert.NoError(t, p.Persist("rec", []*time.Time{date(2024, 1, 1), date(2024, 1, 2)}))
+	assert.NoError(t, p.(Appender[time.Time]).Append("rec", date(2024, 1, 3)))
+	restored, err := p.Restore()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []*time.Time{date(2024, 1, 1), date(2024, 1, 2), date(2024, 1, 3)}, restored)
+	assert.NoError(t, p.Persist("rec", nil))
+
+	assert.ErrorIs(t, PersistCompressed(p).(Appender[time.Time]).Append("rec", date(2024, 1, 3)), ErrAppendNotSupported)
+	assert.ErrorIs(t, PersistJSON[time.Time]("testdata", "_db.json").(Appender[time.Time]).Append("rec", date(2024, 1, 3)), ErrAppendNotSupported)
+
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	table, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+	n := time.Date(2024, 5, 15, 12, 0, 0, 0, time.Local)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, table.Insert(add(n, i)))
+	}
+	r := table.Match(func(e *time.Time) bool { return e.Equal(*add(n, 3)) })
+	assert.NoError(t, r.Delete(0))
+
+	table2, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 9, table2.Size())
+	assert.NoError(t, table2.Replace(nil))
+}
+
+func BenchmarkBulkInsert(b *testing.B) {
+	for _, bench := range []struct {
+		name    string
+		persist func(folder string) Persist[benchItem]
+	}{
+		{"rewrite", func(folder string) Persist[benchItem] {
+			return PersistSerializer[benchItem](folder, ".bin", serialize.New())
+		}},
+		{"append", func(folder string) Persist[benchItem] {
+			return PersistSerializerRecords[benchItem](folder, ".rec", serialize.New())
+		}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			log.SetOutput(io.Discard)
+			defer log.SetOutput(os.Stderr)
+			for i := 0; i < b.N; i++ {
+				table, err := New[benchItem](SingleFile[benchItem]("bench"), bench.persist(b.TempDir()), copyBenchItem, nil)
+				assert.NoError(b, err)
+				for n := 0; n < 1000; n++ {
+					assert.NoError(b, table.Insert(&benchItem{N: n}))
+				}
+			}
+		})
+	}
+}
+
+func TestDurable(t *testing.T) {
+	var synced []string
+	defer func(orig func(f *os.File) error) { syncFile = orig }(syncFile)
+	syncFile = func(f *os.File) error {
+		synced = append(synced, path.Base(f.Name()))
+		return f.Sync()
+	}
+
+	p := PersistJSON[time.Time]("testdata", "_db.json")
+	assert.NoError(t, p.Persist("d", []*time.Time{date(2024, 1, 1)}))
+	assert.EqualValues(t, 0, len(synced))
+
+	p = PersistDurable(p)
+	assert.NoError(t, p.Persist("d", []*time.Time{date(2024, 1, 1)}))
+	assert.EqualValues(t, 2, len(synced))
+	assert.True(t, strings.HasPrefix(synced[0], ".d_db.json."))
+	assert.EqualValues(t, "testdata", synced[1])
+
+	synced = nil
+	assert.NoError(t, p.Persist("d", nil))
+	assert.EqualValues(t, []string{"testdata"}, synced)
+
+	synced = nil
+	r := PersistDurable(PersistSerializerRecords[time.Time]("testdata", "_db.rec", serialize.New()))
+	assert.NoError(t, r.(Appender[time.Time]).Append("d", date(2024, 1, 1)))
+	assert.EqualValues(t, []string{"d_db.rec"}, synced)
+	assert.NoError(t, r.Persist("d", nil))
+}
+
+func TestSerializerRecordsCorruptLength(t *testing.T) {
+	p := PersistSerializerRecords[time.Time]("testdata", "_db.rec", serialize.New())
+	assert.NoError(t, p.Persist("rec", []*time.Time{date(2024, 1, 1)}))
+	defer func() { assert.NoError(t, p.Persist("rec", nil)) }()
+	b, err := os.ReadFile("testdata/rec_db.rec")
+	assert.NoError(t, err)
+
+	var huge [binary.MaxVarintLen64]byte
+	for _, data := range [][]byte{
+		append(append([]byte{}, b...), huge[:binary.PutUvarint(huge[:], 1<<62)]...),
+		append(append([]byte{}, b...), huge[:binary.PutUvarint(huge[:], 1<<63+5)]...),
+		b[:len(b)-1],
+	} {
+		assert.NoError(t, os.WriteFile("testdata/rec_db.rec", data, 0644))
+		_, err = p.Restore()
+		assert.Error(t, err)
+
+		restored, err := PersistSkipCorrupt(p).Restore()
+		var skipped *SkippedFilesError
+		assert.ErrorAs(t, err, &skipped)
+		assert.EqualValues(t, 0, len(restored))
+	}
+}
+
+func TestJSONL(t *testing.T) {
+	p := PersistJSONL[time.Time]("testdata", "_db.jsonl")
+	items := []*time.Time{date(2024, 1, 1), date(2024, 1, 2)}
+	assert.NoError(t, p.Persist("l", items))
+	defer func() { assert.NoError(t, p.Persist("l", nil)) }()
+
+	b, err := os.ReadFile("testdata/l_db.jsonl")
+	assert.NoError(t, err)
+	assert.EqualValues(t, "\"2024-01-01T12:00:00Z\"\n\"2024-01-02T12:00:00Z\"\n", string(b))
+
+	restored, err := p.Restore()
+	assert.NoError(t, err)
+	assert.EqualValues(t, items, restored)
+
+	assert.NoError(t, p.(Appender[time.Time]).Append("l", date(2024, 1, 3)))
+	restored, err = p.Restore()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []*time.Time{date(2024, 1, 1), date(2024, 1, 2), date(2024, 1, 3)}, restored)
+}
+
+func TestJSONLPartialLine(t *testing.T) {
+	p := PersistJSONL[time.Time]("testdata", "_db.jsonl")
+	assert.NoError(t, p.Persist("l", []*time.Time{date(2024, 1, 1), date(2024, 1, 2)}))
+	defer func() { assert.NoError(t, p.Persist("l", nil)) }()
+
+	// simulate a crash while the last line was written
+	b, err := os.ReadFile("testdata/l_db.jsonl")
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile("testdata/l_db.jsonl", b[:len(b)-5], 0644))
+
+	restored, err := p.Restore()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []*time.Time{date(2024, 1, 1)}, restored)
+
+	// the incomplete line is removed before appending
+	assert.NoError(t, p.(Appender[time.Time]).Append("l", date(2024, 1, 3)))
+	restored, err = p.Restore()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []*time.Time{date(2024, 1, 1), date(2024, 1, 3)}, restored)
+}
+
+func TestJSONLMissingLineBreak(t *testing.T) {
+	p := PersistJSONL[time.Time]("testdata", "_db.jsonl")
+	assert.NoError(t, p.Persist("l", []*time.Time{date(2024, 1, 1), date(2024, 1, 2)}))
+	defer func() { assert.NoError(t, p.Persist("l", nil)) }()
+
+	// the last line is complete, only the line break is missing
+	b, err := os.ReadFile("testdata/l_db.jsonl")
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile("testdata/l_db.jsonl", b[:len(b)-1], 0644))
+
+	restored, err := p.Restore()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []*time.Time{date(2024, 1, 1), date(2024, 1, 2)}, restored)
+
+	// the line is kept and the line break is added before appending
+	assert.NoError(t, p.(Appender[time.Time]).Append("l", date(2024, 1, 3)))
+	restored, err = p.Restore()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []*time.Time{date(2024, 1, 1), date(2024, 1, 2), date(2024, 1, 3)}, restored)
+}
+
+func TestJSONLTable(t *testing.T) {
+	p := PersistJSONL[time.Time]("testdata", "_db.jsonl")
+	table, err := New[time.Time](myMonthly, p, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+	n := fillTable(table)
+	r := table.Match(func(e *time.Time) bool { return e.Equal(n) })
+	assert.NoError(t, r.Delete(0))
+
+	table2, err := New[time.Time](myMonthly, p, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+	assert.EqualValues(t, 9, table2.Size())
+	assert.NoError(t, table2.Replace(nil))
+}
+
+func TestMigration(t *testing.T) {
+	type oldFormat struct {
+		Date  time.Time
+		Price int
+	}
+	type newFormat struct {
+		Date  time.Time
+		Price int
+		Gross int
+	}
+
+	old := PersistJSON[oldFormat]("testdata", "_db.json")
+	assert.NoError(t, old.Persist("m", []*oldFormat{{Date: *date(2024, 1, 2), Price: 200}, {Date: *date(2024, 1, 1), Price: 100}}))
+
+	p := PersistJSON[newFormat]("testdata", "_db.json")
+	np := SingleFile[newFormat]("m")
+	less := func(a, b *newFormat) bool { return a.Date.Before(b.Date) }
+	migrate := func(e *newFormat) error {
+		if e.Gross == 0 {
+			e.Gross = e.Price * 119 / 100
+		}
+		return nil
+	}
+	table, err := New[newFormat](np, p, nil, less, WithMigration(migrate))
+	assert.NoError(t, err)
+	var first newFormat
+	assert.True(t, table.First(&first, func(e *newFormat) bool { return true }))
+	assert.EqualValues(t, newFormat{Date: *date(2024, 1, 1), Price: 100, Gross: 119}, first)
+
+	// the next write persists the migrated elements
+	assert.NoError(t, table.Insert(&newFormat{Date: *date(2024, 1, 3), Price: 300, Gross: 357}))
+	restored, err := p.Restore()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, len(restored))
+	for _, e := range restored {
+		assert.EqualValues(t, e.Price*119/100, e.Gross)
+	}
+
+	failed := errors.New("failed")
+	_, err = New[newFormat](np, p, nil, less, WithMigration(func(*newFormat) error { return failed }))
+	assert.ErrorIs(t, err, failed)
+
+	assert.NoError(t, table.Replace(nil))
+}
+
+func TestCompact(t *testing.T) {
+	p := PersistJSON[time.Time]("testdata", "_db.json")
+	table, err := New[time.Time](myMonthly, p, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(date(2024, 1, 1)))
+	assert.NoError(t, table.Insert(date(2024, 2, 1)))
+
+	// a stale file with elements not in the table, an invalid file and a
+	// lost file containing live elements
+	assert.NoError(t, p.Persist("test_2023_12", []*time.Time{date(2023, 12, 1)}))
+	assert.NoError(t, os.WriteFile("testdata/stale_db.json", []byte("invalid"), 0644))
+	assert.NoError(t, os.Remove("testdata/test_2024_01_db.json"))
+
+	assert.NoError(t, table.Compact())
+
+	files, err := p.(Lister).List()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"test_2024_01", "test_2024_02"}, files)
+
+	restored, err := p.Restore()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []*time.Time{date(2024, 1, 1), date(2024, 2, 1)}, restored)
+
+	assert.NoError(t, table.Replace(nil))
+}
+
+func TestPersistMemory(t *testing.T) {
+	p := PersistMemory[time.Time]()
+	table, err := New[time.Time](myMonthly, p, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(date(2024, 2, 1)))
+	assert.NoError(t, table.Insert(date(2024, 1, 1)))
+	assert.NoError(t, table.Insert(date(2024, 1, 2)))
+
+	files, err := p.(Lister).List()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"test_2024_01", "test_2024_02"}, files)
+
+	table2, err := New[time.Time](myMonthly, p, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+	var e time.Time
+	assert.EqualValues(t, 3, table2.Size())
+	assert.True(t, table2.At(&e, 0))
+	assert.EqualValues(t, *date(2024, 1, 1), e)
+
+	r := table2.Match(func(e *time.Time) bool { return e.Month() == time.February })
+	assert.NoError(t, r.Delete(0))
+	files, err = p.(Lister).List()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"test_2024_01"}, files)
+}
+
+func TestFileModeAndFreshFolder(t *testing.T) {
+	defer os.RemoveAll("testdata/fresh")
+
+	p := PersistFileMode(PersistJSON[time.Time]("testdata/fresh/db", "_db.json"), 0600)
+	items, err := p.Restore()
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+	files, err := p.(Lister).List()
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+
+	assert.NoError(t, p.Persist("test", []*time.Time{date(2024, 1, 1)}))
+	info, err := os.Stat("testdata/fresh/db")
+	assert.NoError(t, err)
+	assert.EqualValues(t, os.ModeDir|0700, info.Mode())
+	info, err = os.Stat("testdata/fresh/db/test_db.json")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0600, info.Mode())
+
+	// appended files get the mode as well
+	r := PersistFileMode(PersistJSONL[time.Time]("testdata/fresh/log", "_db.jsonl"), 0600)
+	assert.NoError(t, r.(Appender[time.Time]).Append("test", date(2024, 1, 1)))
+	info, err = os.Stat("testdata/fresh/log/test_db.jsonl")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0600, info.Mode())
+}
+
+func TestNewWithoutFolder(t *testing.T) {
+	defer os.RemoveAll("testdata/new")
+
+	p := PersistSerializer[time.Time]("testdata/new", "_db.bin", serialize.New())
+	items, err := p.Restore()
+	assert.NoError(t, err)
+	assert.NotNil(t, items)
+	assert.Empty(t, items)
+
+	table, err := New[time.Time](myMonthly, p, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, table.Size())
+	assert.NoError(t, table.Insert(date(2024, 1, 1)))
+
+	table2, err := New[time.Time](myMonthly, p, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, table2.Size())
+}
+
+func TestJSONFunc(t *testing.T) {
+	type entry struct {
+		Date   time.Time
+		Note   string
+		Secret string
+	}
+	type jsonEntry struct {
+		Date string `json:"date"`
+		Note string `json:"note"`
+	}
+	marshal := func(items []*entry) ([]byte, error) {
+		je := make([]jsonEntry, len(items))
+		for i, e := range items {
+			je[i] = jsonEntry{Date: e.Date.Format(time.DateOnly), Note: e.Note}
+		}
+		return json.MarshalIndent(je, "", "  ")
+	}
+	unmarshal := func(b []byte) ([]*entry, error) {
+		var je []jsonEntry
+		err := json.Unmarshal(b, &je)
+		if err != nil {
+			return nil, err
+		}
+		items := make([]*entry, len(je))
+		for i, e := range je {
+			d, err := time.Parse(time.DateOnly, e.Date)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = &entry{Date: d, Note: e.Note}
+		}
+		return items, nil
+	}
+
+	p := PersistJSONFunc[entry]("testdata", "_db.json", marshal, unmarshal)
+	assert.NoError(t, p.Persist("e", []*entry{{Date: *date(2024, 1, 2), Note: "a", Secret: "s"}}))
+
+	b, err := os.ReadFile("testdata/e_db.json")
+	assert.NoError(t, err)
+	assert.EqualValues(t, "[\n  {\n    \"date\": \"2024-01-02\",\n    \"note\": \"a\"\n  }\n]", string(b))
+
+	items, err := p.Restore()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []*entry{{Date: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Note: "a"}}, items)
+
+	assert.NoError(t, os.WriteFile("testdata/e_db.json", []byte(`[{"date":"invalid"}]`), 0644))
+	_, err = p.Restore()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "e_db.json")
+
+	assert.NoError(t, p.Persist("e", nil))
+}
+
+func TestJSONIndent(t *testing.T) {
+	p := PersistJSONIndent[time.Time]("testdata", "_db.json", "  ")
+	table, err := New[time.Time](myMonthly, p, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(date(2024, 1, 1)))
+	assert.NoError(t, table.Insert(date(2024, 1, 2)))
+
+	b, err := os.ReadFile("testdata/test_2024_01_db.json")
+	assert.NoError(t, err)
+	assert.EqualValues(t, "[\n  \"2024-01-01T12:00:00Z\",\n  \"2024-01-02T12:00:00Z\"\n]", string(b))
+
+	// the files are readable by PersistJSON
+	items, err := PersistJSON[time.Time]("testdata", "_db.json").Restore()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []*time.Time{date(2024, 1, 1), date(2024, 1, 2)}, items)
+
+	assert.NoError(t, table.Replace(nil))
+}
+
+func TestReplaceFile(t *testing.T) {
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	p := PersistSerializer[time.Time]("testdata", "_db.bin", serialize.New())
+	table, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+	for m := time.January; m <= time.March; m++ {
+		assert.NoError(t, table.Insert(date(2024, m, 10)))
+		assert.NoError(t, table.Insert(date(2024, m, 20)))
+	}
+	jan, err := os.ReadFile("testdata/test_2024_01_db.bin")
+	assert.NoError(t, err)
+	mar, err := os.ReadFile("testdata/test_2024_03_db.bin")
+	assert.NoError(t, err)
+	version := table.Version()
+
+	err = table.ReplaceFile("test_2024_02", []*time.Time{date(2024, 2, 5), date(2024, 3, 1)})
+	assert.Error(t, err)
+	assert.EqualValues(t, version, table.Version())
+
+	assert.NoError(t, table.ReplaceFile("test_2024_02", []*time.Time{date(2024, 2, 25), date(2024, 2, 1), date(2024, 2, 15)}))
+	assert.EqualValues(t, version+1, table.Version())
+	assert.EqualValues(t, 7, table.Size())
+	var dates []time.Time
+	table.All(func(e *time.Time) bool {
+		dates = append(dates, *e)
+		return true
+	})
+	assert.EqualValues(t, []time.Time{*date(2024, 1, 10), *date(2024, 1, 20), *date(2024, 2, 1), *date(2024, 2, 15), *date(2024, 2, 25), *date(2024, 3, 10), *date(2024, 3, 20)}, dates)
+
+	b, err := os.ReadFile("testdata/test_2024_01_db.bin")
+	assert.NoError(t, err)
+	assert.EqualValues(t, jan, b)
+	b, err = os.ReadFile("testdata/test_2024_03_db.bin")
+	assert.NoError(t, err)
+	assert.EqualValues(t, mar, b)
+
+	restored, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 7, restored.Size())
+
+	assert.NoError(t, table.ReplaceFile("test_2024_02", nil))
+	assert.EqualValues(t, []string{"test_2024_01", "test_2024_03"}, table.Files())
+	assert.NoError(t, table.Replace(nil))
+}
+
+func TestRestoreOrder(t *testing.T) {
+	p := PersistJSON[time.Time]("testdata", "_db.json")
+	table, err := New[time.Time](myMonthly, p, nil, nil)
+	assert.NoError(t, err)
+	for _, d := range []*time.Time{date(2024, 3, 2), date(2024, 1, 5), date(2024, 3, 1), date(2024, 2, 1), date(2024, 1, 3)} {
+		assert.NoError(t, table.Insert(d))
+	}
+
+	// without a less function the files are restored in the order of their
+	// names, and the order within a file is kept
+	restored, err := New[time.Time](myMonthly, p, nil, nil)
+	assert.NoError(t, err)
+	var dates []time.Time
+	restored.All(func(e *time.Time) bool {
+		dates = append(dates, *e)
+		return true
+	})
+	assert.EqualValues(t, []time.Time{*date(2024, 1, 5), *date(2024, 1, 3), *date(2024, 2, 1), *date(2024, 3, 2), *date(2024, 3, 1)}, dates)
+	assert.NoError(t, table.Replace(nil))
+}
+
+func TestReindex(t *testing.T) {
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	table, err := New[time.Time](myMonthly, PersistJSON[time.Time]("testdata", "_db.json"), nil, less)
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(date(2024, 1, 10)))
+	assert.NoError(t, table.Insert(date(2024, 3, 10)))
+	r := table.Match(func(e *time.Time) bool { return true })
+
+	// write a file as if edited by hand
+	b, err := json.Marshal([]time.Time{*date(2024, 2, 10)})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile("testdata/test_2024_02_db.json", b, 0644))
+
+	assert.NoError(t, table.Reindex())
+	var dates []time.Time
+	table.All(func(e *time.Time) bool {
+		dates = append(dates, *e)
+		return true
+	})
+	assert.EqualValues(t, []time.Time{*date(2024, 1, 10), *date(2024, 2, 10), *date(2024, 3, 10)}, dates)
+	assert.ErrorIs(t, r.Delete(0), ErrVersionChanged)
+
+	// a broken file leaves the table unchanged
+	assert.NoError(t, os.WriteFile("testdata/test_2024_02_db.json", b[:len(b)/2], 0644))
+	version := table.Version()
+	assert.Error(t, table.Reindex())
+	assert.EqualValues(t, version, table.Version())
+	assert.EqualValues(t, 3, table.Size())
+
+	assert.NoError(t, table.Replace(nil))
+
+	memory, err := New[time.Time](nil, nil, nil, less)
+	assert.NoError(t, err)
+	assert.Error(t, memory.Reindex())
+}
+
+func TestReindexWriteDelay(t *testing.T) {
+	const walFile = "testdata/wal.log"
+	defer os.Remove(walFile)
+
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	table, err := New[time.Time](myMonthly, PersistJSON[time.Time]("testdata", "_db.json"), nil, less)
+	assert.NoError(t, err)
+	table.SetWriteDelay(100)
+	assert.NoError(t, table.EnableWAL(walFile))
+	assert.NoError(t, table.Insert(date(2024, 1, 10)))
+	assert.NoError(t, table.Insert(date(2024, 2, 10)))
+
+	// the pending files are written before they are read again
+	assert.NoError(t, table.Reindex())
+	assert.EqualValues(t, 2, table.Size())
+	_, err = os.Stat("testdata/test_2024_02_db.json")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, table.delayedWrite.pending())
+	info, err := os.Stat(walFile)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, info.Size())
+
+	assert.NoError(t, table.Replace(nil))
+	assert.NoError(t, table.Shutdown())
+}
+
+func TestRebucket(t *testing.T) {
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	daily := Daily[time.Time]("test", identity)
+	p := PersistJSON[time.Time]("testdata", "_db.json")
+	table, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+	for _, d := range []*time.Time{date(2024, 1, 10), add(*date(2024, 1, 10), 1), date(2024, 1, 20), date(2024, 2, 5)} {
+		assert.NoError(t, table.InsertVal(*d))
+	}
+	version := table.Version()
+
+	assert.NoError(t, table.Rebucket(daily))
+	assert.EqualValues(t, version, table.Version())
+	files, err := p.(Lister).List()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"test_2024_01_10", "test_2024_01_20", "test_2024_02_05"}, files)
+
+	restored, err := New[time.Time](daily, p, nil, less)
+	assert.NoError(t, err)
+	var dates []time.Time
+	restored.All(func(e *time.Time) bool {
+		dates = append(dates, *e)
+		return true
+	})
+	assert.EqualValues(t, []time.Time{*date(2024, 1, 10), date(2024, 1, 10).Add(time.Hour), *date(2024, 1, 20), *date(2024, 2, 5)}, dates)
+
+	// the new file name is also an old one
+	assert.NoError(t, table.Rebucket(SingleFile[time.Time]("test_2024_01_20")))
+	files, err = p.(Lister).List()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"test_2024_01_20"}, files)
+	restored, err = New[time.Time](daily, p, nil, less)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, restored.Size())
+
+	assert.NoError(t, table.Replace(nil))
+}
+
+func TestRebucketWriteDelay(t *testing.T) {
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	p := PersistJSON[time.Time]("testdata", "_db.json")
+	table, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+	table.SetWriteDelay(100)
+	assert.NoError(t, table.Insert(date(2024, 1, 10)))
+	assert.NoError(t, table.Insert(date(2024, 2, 5)))
+
+	// the pending monthly files are not written again by the delay handler
+	assert.NoError(t, table.Rebucket(Daily[time.Time]("test", identity)))
+	assert.EqualValues(t, 0, table.delayedWrite.pending())
+	assert.NoError(t, table.Shutdown())
+	files, err := p.(Lister).List()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"test_2024_01_10", "test_2024_02_05"}, files)
+
+	assert.NoError(t, table.Replace(nil))
+}
+
+func TestRebucketError(t *testing.T) {
+	writeErr := errors.New("write failed")
+	f := &failingPersist{err: writeErr}
+	table, err := New[time.Time](myMonthly, f, nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(date(2024, 1, 10)))
+	assert.NoError(t, table.Insert(date(2024, 2, 10)))
+
+	f.fails = f.calls + 1
+	err = table.Rebucket(Daily[time.Time]("test", identity))
+	assert.ErrorIs(t, err, writeErr)
+	assert.EqualValues(t, []string{"test_2024_01", "test_2024_02"}, table.Files())
+}
+
+func TestFileModTimes(t *testing.T) {
+	p := PersistJSON[time.Time]("testdata", "_db.json")
+	table, err := New[time.Time](myMonthly, p, nil, nil)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, table.Replace(nil))
+	}()
+	assert.NoError(t, table.Insert(date(2024, 1, 10)))
+	assert.NoError(t, table.Insert(date(2024, 2, 10)))
+
+	// set the times to the past to not depend on the timestamp resolution
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, f := range []string{"testdata/test_2024_01_db.json", "testdata/test_2024_02_db.json"} {
+		assert.NoError(t, os.Chtimes(f, past, past))
+	}
+	times, err := table.FileModTimes()
+	assert.NoError(t, err)
+	assert.True(t, times["test_2024_01"].Equal(past))
+	assert.True(t, times["test_2024_02"].Equal(past))
+
+	assert.NoError(t, table.Insert(date(2024, 2, 20)))
+	times, err = table.FileModTimes()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, len(times))
+	assert.True(t, times["test_2024_01"].Equal(past))
+	assert.True(t, times["test_2024_02"].After(past))
+
+	table.SetWriteDelay(10)
+	defer table.Shutdown()
+	assert.NoError(t, table.Insert(date(2024, 1, 20)))
+	times, err = table.FileModTimes()
+	assert.NoError(t, err)
+	assert.True(t, times["test_2024_01"].IsZero())
+
+	memory, err := New[time.Time](myMonthly, PersistMemory[time.Time](), nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, memory.Insert(date(2024, 1, 10)))
+	times, err = memory.FileModTimes()
+	assert.NoError(t, err)
+	assert.False(t, times["test_2024_01"].IsZero())
+
+	f := &failingPersist{}
+	other, err := New[time.Time](myMonthly, f, nil, nil)
+	assert.NoError(t, err)
+	_, err = other.FileModTimes()
+	assert.Error(t, err)
+}
+
+func TestAppendOnly(t *testing.T) {
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	p := PersistJSONL[time.Time]("testdata", "_db.jsonl")
+	table, err := New[time.Time](myMonthly, p, nil, less, WithAppendOnly[time.Time]())
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, p.Persist("test_2024_01", nil))
+	}()
+
+	n := *date(2024, 1, 1)
+	assert.NoError(t, table.Insert(&n))
+	first, err := os.ReadFile("testdata/test_2024_01_db.jsonl")
+	assert.NoError(t, err)
+	info, err := os.Stat("testdata/test_2024_01_db.jsonl")
+	assert.NoError(t, err)
+	for i := 1; i <= 20; i++ {
+		assert.NoError(t, table.Insert(add(n, i)))
+	}
+	// the file was not replaced by a rewritten one
+	infoAfter, err := os.Stat("testdata/test_2024_01_db.jsonl")
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(info, infoAfter))
+	b, err := os.ReadFile("testdata/test_2024_01_db.jsonl")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(b), string(first)))
+	assert.EqualValues(t, 21, strings.Count(string(b), "\n"))
+
+	assert.ErrorIs(t, table.Insert(add(n, 10)), ErrAppendOnly)
+	assert.NoError(t, table.Insert(add(n, 20)))
+
+	r := table.Match(func(e *time.Time) bool { return true })
+	assert.ErrorIs(t, r.Delete(0), ErrAppendOnly)
+	assert.ErrorIs(t, r.DeleteAll([]int{0, 1}), ErrAppendOnly)
+	assert.ErrorIs(t, r.Update(0, &n), ErrAppendOnly)
+	_, err = table.UpdateMatch(func(e *time.Time) bool { return true }, &n)
+	assert.ErrorIs(t, err, ErrAppendOnly)
+	assert.ErrorIs(t, table.Move(func(e *time.Time) bool { return true }, &n), ErrAppendOnly)
+	assert.ErrorIs(t, table.Replace(nil), ErrAppendOnly)
+	assert.EqualValues(t, 22, table.Size())
+
+	restored, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 22, restored.Size())
+}
+
+func TestPersistFS(t *testing.T) {
+	jan, err := json.Marshal([]time.Time{*date(2024, 1, 10), *date(2024, 1, 20)})
+	assert.NoError(t, err)
+	var feb bytes.Buffer
+	assert.NoError(t, serialize.New().Write(&feb, []*time.Time{date(2024, 2, 10)}))
+	fsys := fstest.MapFS{
+		"seed/test_2024_01_db.json": {Data: jan, ModTime: *date(2024, 3, 1)},
+		"seed/test_2024_02_db.bin":  {Data: feb.Bytes()},
+		"seed/other.txt":            {Data: []byte("no db file")},
+	}
+
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	p := PersistFS(PersistJSON[time.Time]("seed", "_db.json"), fsys)
+	table, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, table.Size())
+	files, err := p.(Lister).List()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"test_2024_01"}, files)
+	times, err := table.FileModTimes()
+	assert.NoError(t, err)
+	assert.True(t, times["test_2024_01"].Equal(*date(2024, 3, 1)))
+
+	// the file system is read only
+	assert.ErrorIs(t, table.Insert(date(2024, 1, 30)), ErrReadOnly)
+	assert.EqualValues(t, 2, table.Size())
+
+	bin, err := New[time.Time](myMonthly, PersistFS(PersistSerializer[time.Time]("seed", "_db.bin", serialize.New()), fsys), nil, less)
+	assert.NoError(t, err)
+	found, ok := bin.FirstVal(func(e *time.Time) bool { return true })
+	assert.True(t, ok)
+	assert.EqualValues(t, *date(2024, 2, 10), found)
+
+	empty, err := New[time.Time](myMonthly, PersistFS(PersistJSON[time.Time]("missing", "_db.json"), fsys), nil, less)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, empty.Size())
+
+	// a path in a file system can not be absolute
+	assert.Panics(t, func() { PersistFS(PersistJSON[time.Time]("/seed", "_db.json"), fsys) })
+	assert.Panics(t, func() { PersistFS(PersistJSON[time.Time]("../seed", "_db.json"), fsys) })
+}
+
+type withChan struct {
+	N int
+	C chan int
+}
+
+func TestDryRun(t *testing.T) {
+	p := PersistDryRun(PersistCompressed(PersistSerializer[withChan]("testdata", "_db.bin", serialize.New())))
+	err := p.Persist("dry", []*withChan{{N: 1}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "dry")
+	assert.Contains(t, err.Error(), "C")
+
+	table, err := New[time.Time](myMonthly, PersistDryRun(PersistJSON[time.Time]("testdata", "_db.json")), nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(date(2024, 1, 1)))
+	assert.NoError(t, table.Insert(date(2024, 2, 1)))
+
+	files, err := os.ReadDir("testdata")
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestSyncFileGroup(t *testing.T) {
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	p := PersistMemory[time.Time]()
+	table, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(date(2024, 1, 1)))
+
+	// a new month
+	assert.NoError(t, table.SyncFileGroup("", []*time.Time{date(2024, 2, 20), date(2024, 2, 10)}))
+	assert.EqualValues(t, []string{"test_2024_01", "test_2024_02"}, table.Files())
+	assert.EqualValues(t, 3, table.Size())
+
+	// the same month again, the old content is replaced
+	assert.NoError(t, table.SyncFileGroup("test_2024_02", []*time.Time{date(2024, 2, 5)}))
+	assert.EqualValues(t, 2, table.Size())
+	var e time.Time
+	assert.True(t, table.At(&e, 1))
+	assert.EqualValues(t, *date(2024, 2, 5), e)
+
+	restored, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, restored.Size())
+
+	assert.Error(t, table.SyncFileGroup("", []*time.Time{date(2024, 3, 1), date(2024, 4, 1)}))
+	assert.Error(t, table.SyncFileGroup("test_2024_03", []*time.Time{date(2024, 4, 1)}))
+	assert.Error(t, table.SyncFileGroup("", nil))
+	assert.EqualValues(t, 2, table.Size())
+}
+
+type user struct {
+	Name string
+}
+
+func TestPersistFilter(t *testing.T) {
+	users, err := New[user](SingleFile[user]("all"), PersistJSON[user]("testdata", "_users_db.json"), nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, users.Insert(&user{Name: "bob"}))
+
+	// the file of the users also ends with _db.json
+	orders := PersistJSON[time.Time]("testdata", "_db.json")
+	_, err = New[time.Time](myMonthly, orders, nil, nil)
+	assert.Error(t, err)
+
+	filtered := PersistFilter(orders, func(dbFile string) bool { return strings.HasPrefix(dbFile, "test_") })
+	table, err := New[time.Time](myMonthly, filtered, nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(date(2024, 1, 1)))
+
+	restored, err := New[time.Time](myMonthly, filtered, nil, nil)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, restored.Size())
+	files, err := filtered.(Lister).List()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"test_2024_01"}, files)
+
+	restoredUsers, err := New[user](SingleFile[user]("all"), PersistJSON[user]("testdata", "_users_db.json"), nil, nil)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, restoredUsers.Size())
+
+	assert.NoError(t, table.Replace(nil))
+	assert.NoError(t, users.Replace(nil))
+}
@@ -1,33 +1,69 @@
 package objectDB
 
 import (
+	"errors"
 	"fmt"
+	"iter"
+	"sort"
 )
 
+// Result is a snapshot of the elements of a table which matched a query. It
+// refers to the elements by their position in the table, so it becomes invalid
+// as soon as the table is modified by any other means than the Result itself.
+// In this case, all accesses fail with ErrVersionChanged, and Refresh has to
+// be used to obtain an up-to-date Result. See WithStrictVersioning for a more
+// tolerant policy for reading elements. Deleting elements through a Result
+// keeps this Result valid, but not other Results of the same table.
 type Result[E any] struct {
 	table      *Table[E]
 	tableIndex []int
 	version    int
+	// query computes the indices of the result again. It is called with the
+	// table locked.
+	query func() []int
 }
 
-func newResult[E any](tableIndex []int, table *Table[E]) Result[E] {
+func newResult[E any](tableIndex []int, table *Table[E], query func() []int) Result[E] {
 	return Result[E]{
 		table:      table,
 		tableIndex: tableIndex,
-		version:    table.version,
+		version:    table.Version(),
+		query:      query,
 	}
 }
 
+// Valid returns true if the table was not modified since the result was
+// created. Otherwise, accessing the elements of the result fails with
+// ErrVersionChanged, and Refresh can be used to obtain a valid result.
+func (r *Result[E]) Valid() bool {
+	return r.table != nil && r.version == r.table.Version()
+}
+
+// Refresh runs the query that created the result again and returns a new
+// result which reflects the current state of the table.
+func (r *Result[E]) Refresh() (Result[E], error) {
+	if r.table == nil || r.query == nil {
+		return Result[E]{}, errors.New("refresh: result can not be refreshed")
+	}
+
+	r.table.lock(LockRead)
+	defer r.table.m.Unlock()
+
+	return newResult(r.query(), r.table, r.query), nil
+}
+
 func (r *Result[E]) Size() int {
 	return len(r.tableIndex)
 }
 
+// Iter calls the yield function with a deep copy of each element of the
+// result. Each call receives a newly allocated element, so the pointers can be
+// retained by the caller.
 func (r *Result[E]) Iter(yield func(*E, error) bool) {
-	var err error
-	var e E
 	for _, n := range r.tableIndex {
-		err = r.table.copy(&e, n, r.version)
-		if !yield(&e, err) {
+		e := new(E)
+		err := r.table.copy(e, n, r.version)
+		if !yield(e, err) {
 			break
 		}
 		if err != nil {
@@ -36,19 +72,144 @@ func (r *Result[E]) Iter(yield func(*E, error) bool) {
 	}
 }
 
+// Seq returns an iterator over deep copies of the elements of the result.
+// If the table has changed in the meantime, the zero value is yielded
+// together with the error and the iteration stops.
+func (r *Result[E]) Seq() iter.Seq2[E, error] {
+	return func(yield func(E, error) bool) {
+		for _, n := range r.tableIndex {
+			var e E
+			err := r.table.copy(&e, n, r.version)
+			if err != nil {
+				var zero E
+				yield(zero, err)
+				return
+			}
+			if !yield(e, nil) {
+				return
+			}
+		}
+	}
+}
+
+// ToSlice returns deep copies of all elements of the result in a newly
+// allocated slice. If the table has changed in the meantime, an error is
+// returned.
+func (r *Result[E]) ToSlice() ([]E, error) {
+	s := make([]E, len(r.tableIndex))
+	for i, n := range r.tableIndex {
+		err := r.table.copy(&s[i], n, r.version)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Any returns true if p returns true for at least one element of the result.
+// The iteration stops at the first such element. If the result is empty, false
+// is returned. If the table has changed in the meantime, an error is returned.
+func (r *Result[E]) Any(p func(*E) bool) (bool, error) {
+	for e, err := range r.Iter {
+		if err != nil {
+			return false, err
+		}
+		if p(e) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// All returns true if p returns true for all elements of the result. The
+// iteration stops at the first element for which p returns false. If the
+// result is empty, true is returned. If the table has changed in the meantime,
+// an error is returned.
+func (r *Result[E]) All(p func(*E) bool) (bool, error) {
+	for e, err := range r.Iter {
+		if err != nil {
+			return false, err
+		}
+		if !p(e) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Contains returns true if the match function returns true for at least one
+// element of the result. In contrast to Any, the elements are not copied and
+// the table is locked during the whole scan, so the same restrictions as for
+// the accept function of Match apply. If the table has changed in the
+// meantime, an error is returned, even if the result is empty.
+func (r *Result[E]) Contains(match func(*E) bool) (bool, error) {
+	if r.table == nil {
+		return false, nil
+	}
+
+	r.table.lock(LockRead)
+	defer r.table.m.Unlock()
+
+	if r.table.Version() != r.version {
+		return false, fmt.Errorf("contains: %w", ErrVersionChanged)
+	}
+	for _, n := range r.tableIndex {
+		if match(r.table.data[n]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// IndicesCopy returns a copy of the indices of the matched elements in the
+// table. It is intended for debugging purposes.
+func (r *Result[E]) IndicesCopy() []int {
+	return append([]int{}, r.tableIndex...)
+}
+
 func (r *Result[E]) Get(dst *E, n int) error {
 	if n < 0 || n >= len(r.tableIndex) {
-		return fmt.Errorf("item: index out of range")
+		return fmt.Errorf("item: %w", ErrIndexOutOfRange)
 	}
 
 	return r.table.copy(dst, r.tableIndex[n], r.version)
 }
 
+// GetVal returns a deep copy of the n-th element of the result.
+func (r *Result[E]) GetVal(n int) (E, error) {
+	var e E
+	err := r.Get(&e, n)
+	return e, err
+}
+
+// First copies the first element of the result to dst. It returns false if
+// the result is empty. If the table has changed in the meantime, an error is
+// returned, even if the result is empty, unless the versioning is relaxed,
+// see WithStrictVersioning.
+func (r *Result[E]) First(dst *E) (bool, error) {
+	if len(r.tableIndex) == 0 {
+		if r.table != nil && r.table.Version() != r.version && !r.table.relaxedReads {
+			return false, fmt.Errorf("first: %w", ErrVersionChanged)
+		}
+		return false, nil
+	}
+
+	err := r.table.copy(dst, r.tableIndex[0], r.version)
+	return err == nil, err
+}
+
 func (r *Result[E]) Delete(n int) error {
+	if n < 0 || n >= len(r.tableIndex) {
+		return fmt.Errorf("delete: %w", ErrIndexOutOfRange)
+	}
+
 	tableIndex := r.tableIndex[n]
-	err := r.table.delete(tableIndex, r.version)
-	if err == nil {
-		r.version++
+	version, deleted, err := r.table.delete(tableIndex, r.version)
+	// a rolled back delete also increments the version, but leaves the
+	// indices unchanged
+	r.version = version
+	if deleted {
+		// the element was deleted, even if an error is reported
 		copy(r.tableIndex[n:], r.tableIndex[n+1:])
 		r.tableIndex = r.tableIndex[:len(r.tableIndex)-1]
 		for i := range r.tableIndex {
@@ -60,18 +221,116 @@ func (r *Result[E]) Delete(n int) error {
 	return err
 }
 
+// DeleteAll deletes the elements with the given indices from the table. The
+// elements are deleted in a single step, and each affected file is persisted
+// only once. The Result stays usable and contains the remaining elements
+// afterwards. Duplicate indices are ignored. In contrast to Delete, the
+// elements stay deleted if one of the files could not be written.
+func (r *Result[E]) DeleteAll(indices []int) error {
+	seen := map[int]bool{}
+	var tableIndices []int
+	for _, n := range indices {
+		if n < 0 || n >= len(r.tableIndex) {
+			return fmt.Errorf("delete: %w", ErrIndexOutOfRange)
+		}
+		if !seen[n] {
+			seen[n] = true
+			tableIndices = append(tableIndices, r.tableIndex[n])
+		}
+	}
+	if len(tableIndices) == 0 {
+		return nil
+	}
+	sort.Ints(tableIndices)
+
+	version, err := r.table.deleteAll(tableIndices, r.version)
+	if version != r.version {
+		r.version = version
+		ti := r.tableIndex[:0]
+		for n, tableIndex := range r.tableIndex {
+			if !seen[n] {
+				// the number of deleted elements in front of this one
+				ti = append(ti, tableIndex-sort.SearchInts(tableIndices, tableIndex))
+			}
+		}
+		r.tableIndex = ti
+	}
+	return err
+}
+
 func (r *Result[E]) Update(n int, e *E) error {
+	if n < 0 || n >= len(r.tableIndex) {
+		return fmt.Errorf("update: %w", ErrIndexOutOfRange)
+	}
+
 	return r.table.update(r.tableIndex[n], r.version, e)
 }
 
+// Modify calls the mutate function with a deep copy of the n-th element and
+// updates the element with the modified copy. In contrast to a Get followed
+// by an Update, no other modification of the table can happen in between,
+// because the table is locked during the whole operation. Therefore, mutate
+// must not access the table. As with Update, the modification must not change
+// the position of the element in the sort order.
+func (r *Result[E]) Modify(n int, mutate func(*E)) error {
+	if n < 0 || n >= len(r.tableIndex) {
+		return fmt.Errorf("modify: %w", ErrIndexOutOfRange)
+	}
+
+	return r.table.modify(r.tableIndex[n], r.version, mutate)
+}
+
+// Order returns a new Result containing the same elements sorted by the given
+// less function. For performance reasons, the less function is called with the
+// not yet deep copied elements, so it is not allowed to modify the elements.
+// If the debug checks are enabled, deep copies are passed instead. The table is
+// locked while sorting. The sort is stable, so elements which are equal
+// according to less keep their order. The new Result has the same version as
+// r, so if the table has changed since r was created, ErrVersionChanged is
+// returned.
 func (r *Result[E]) Order(less func(e1, e2 *E) bool) (Result[E], error) {
 	so, err := r.table.order(r.tableIndex, less, r.version)
 	if err != nil {
 		return Result[E]{}, err
 	}
+	var query func() []int
+	if r.query != nil {
+		parent := r.query
+		query = func() []int { return r.table.sortIndices(parent(), less) }
+	}
 	return Result[E]{
 		table:      r.table,
 		tableIndex: so,
 		version:    r.version,
+		query:      query,
 	}, nil
 }
+
+// MapResult applies f to a deep copy of each element of the result and
+// returns the values returned by f. If the table has changed in the meantime,
+// an error is returned.
+func MapResult[E, T any](r Result[E], f func(*E) T) ([]T, error) {
+	m := make([]T, 0, r.Size())
+	for e, err := range r.Iter {
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, f(e))
+	}
+	return m, nil
+}
+
+// ReduceResult folds the deep copies of all elements of the result into a
+// single value, starting with init. If the table has changed in the meantime,
+// an error is returned.
+func ReduceResult[E, A any](r Result[E], init A, f func(A, *E) A) (A, error) {
+	acc := init
+	for e, err := range r.Iter {
+		if err != nil {
+			var zero A
+			return zero, err
+		}
+		acc = f(acc, e)
+	}
+	return acc, nil
+}
@@ -0,0 +1,125 @@
+package objectDB
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"time"
+)
+
+// PersistRetry returns a Persist that retries a failed Persist call of the
+// inner Persist. After the first failure it waits for the backoff duration,
+// which is doubled after each further failure. If all attempts fail, the last
+// error is returned. Restore is not retried. Errors caused by a missing file
+// are not retried, because a retry can not succeed. If the inner Persist
+// implements Appender, Lister or Stater, the calls are forwarded and retried
+// the same way. If the inner Persist is to be compressed or encrypted,
+// PersistRetry has to be the outermost wrapper.
+func PersistRetry[E any](inner Persist[E], attempts int, backoff time.Duration) Persist[E] {
+	if attempts < 1 {
+		attempts = 1
+	}
+	p := persistRetry[E]{inner: inner, attempts: attempts, backoff: backoff}
+	_, isLister := inner.(Lister)
+	_, isStater := inner.(Stater)
+	switch {
+	case isLister && isStater:
+		return retryListerStater[E]{p}
+	case isLister:
+		return retryLister[E]{p}
+	case isStater:
+		return retryStater[E]{p}
+	default:
+		return p
+	}
+}
+
+type persistRetry[E any] struct {
+	inner    Persist[E]
+	attempts int
+	backoff  time.Duration
+}
+
+// retry calls f until it succeeds, the attempts are exhausted or an error
+// occurs which can not be fixed by a retry.
+func retry[T any](attempts int, backoff time.Duration, op, name string, f func() (T, error)) (T, error) {
+	wait := backoff
+	var t T
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			log.Println("retry", op, name, "after error:", err)
+			time.Sleep(wait)
+			wait *= 2
+		}
+		t, err = f()
+		if err == nil || errors.Is(err, fs.ErrNotExist) || errors.Is(err, ErrAppendNotSupported) {
+			return t, err
+		}
+	}
+	return t, err
+}
+
+func (p persistRetry[E]) Persist(name string, items []*E) error {
+	_, err := retry(p.attempts, p.backoff, "persist", name, func() (struct{}, error) {
+		return struct{}{}, p.inner.Persist(name, items)
+	})
+	return err
+}
+
+// Append forwards the call to the inner Persist if it implements Appender.
+// Otherwise ErrAppendNotSupported is returned.
+func (p persistRetry[E]) Append(name string, e *E) error {
+	a, ok := p.inner.(Appender[E])
+	if !ok {
+		return ErrAppendNotSupported
+	}
+	_, err := retry(p.attempts, p.backoff, "append", name, func() (struct{}, error) {
+		return struct{}{}, a.Append(name, e)
+	})
+	return err
+}
+
+func (p persistRetry[E]) Restore() ([]*E, error) {
+	return p.inner.Restore()
+}
+
+// list is only called if the inner Persist implements Lister
+func (p persistRetry[E]) list() ([]string, error) {
+	return retry(p.attempts, p.backoff, "list", "", p.inner.(Lister).List)
+}
+
+// stat is only called if the inner Persist implements Stater
+func (p persistRetry[E]) stat(name string) (time.Time, error) {
+	return retry(p.attempts, p.backoff, "stat", name, func() (time.Time, error) {
+		return p.inner.(Stater).Stat(name)
+	})
+}
+
+type retryLister[E any] struct {
+	persistRetry[E]
+}
+
+func (p retryLister[E]) List() ([]string, error) {
+	return p.list()
+}
+
+type retryStater[E any] struct {
+	persistRetry[E]
+}
+
+func (p retryStater[E]) Stat(name string) (time.Time, error) {
+	return p.stat(name)
+}
+
+type retryListerStater[E any] struct {
+	persistRetry[E]
+}
+
+func (p retryListerStater[E]) List() ([]string, error) {
+	return p.list()
+}
+
+func (p retryListerStater[E]) Stat(name string) (time.Time, error) {
+	return p.stat(name)
+}
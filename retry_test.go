@@ -0,0 +1,101 @@
+package objectDB
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+type failingPersist struct {
+	fails int
+	err   error
+	calls int
+}
+
+func (f *failingPersist) Persist(string, []*time.Time) error {
+	f.calls++
+	if f.calls <= f.fails {
+		return f.err
+	}
+	return nil
+}
+
+func (f *failingPersist) Restore() ([]*time.Time, error) {
+	return nil, nil
+}
+
+func TestPersistRetry(t *testing.T) {
+	transient := errors.New("transient")
+
+	f := &failingPersist{fails: 2, err: transient}
+	assert.NoError(t, PersistRetry[time.Time](f, 3, time.Millisecond).Persist("a", nil))
+	assert.EqualValues(t, 3, f.calls)
+
+	f = &failingPersist{fails: 3, err: transient}
+	assert.ErrorIs(t, PersistRetry[time.Time](f, 3, time.Millisecond).Persist("a", nil), transient)
+	assert.EqualValues(t, 3, f.calls)
+
+	f = &failingPersist{fails: 3, err: fs.ErrNotExist}
+	assert.ErrorIs(t, PersistRetry[time.Time](f, 3, time.Millisecond).Persist("a", nil), fs.ErrNotExist)
+	assert.EqualValues(t, 1, f.calls)
+}
+
+func TestPersistRetryForward(t *testing.T) {
+	// failingPersist implements none of the optional interfaces
+	p := PersistRetry[time.Time](&failingPersist{}, 3, time.Millisecond)
+	_, ok := p.(Lister)
+	assert.False(t, ok)
+	_, ok = p.(Stater)
+	assert.False(t, ok)
+	assert.ErrorIs(t, p.(Appender[time.Time]).Append("a", date(2024, 1, 1)), ErrAppendNotSupported)
+
+	p = PersistRetry(PersistJSONL[time.Time]("testdata", "_db.jsonl"), 3, time.Millisecond)
+	assert.NoError(t, p.(Appender[time.Time]).Append("l", date(2024, 1, 1)))
+	assert.NoError(t, p.(Appender[time.Time]).Append("l", date(2024, 1, 2)))
+	restored, err := p.Restore()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []*time.Time{date(2024, 1, 1), date(2024, 1, 2)}, restored)
+
+	files, err := p.(Lister).List()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"l"}, files)
+
+	mod, err := p.(Stater).Stat("l")
+	assert.NoError(t, err)
+	assert.False(t, mod.IsZero())
+	_, err = p.(Stater).Stat("missing")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	assert.NoError(t, p.Persist("l", nil))
+}
+
+func TestPersistRetryTable(t *testing.T) {
+	f := &failingPersist{fails: 1, err: errors.New("transient")}
+	table, err := New[time.Time](myMonthly, PersistRetry[time.Time](f, 2, time.Millisecond), nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(add(time.Now(), 0)))
+	assert.EqualValues(t, 2, f.calls)
+}
+
+func TestDelayedWriteError(t *testing.T) {
+	failed := errors.New("failed")
+	table, err := New[time.Time](myMonthly, &failingPersist{fails: 100, err: failed}, nil, nil)
+	assert.NoError(t, err)
+	clock := newFakeClock()
+	table.clock = clock
+	assert.NoError(t, table.LastWriteError())
+
+	table.SetWriteDelay(2)
+	assert.NoError(t, table.Insert(add(time.Now(), 0)))
+	clock.advanceUntil(t, func() bool { return table.LastWriteError() != nil })
+	assert.ErrorIs(t, table.LastWriteError(), failed)
+
+	assert.ErrorIs(t, table.Shutdown(), failed)
+	assert.NoError(t, table.LastWriteError())
+
+	table.SetWriteDelay(2)
+	assert.NoError(t, table.Insert(add(time.Now(), 1)))
+	assert.ErrorIs(t, table.Shutdown(), failed)
+}
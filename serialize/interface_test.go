@@ -2,9 +2,13 @@ package serialize
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"math"
+	"math/big"
+	"net/netip"
+	"sync"
 	"testing"
 	"time"
 )
@@ -60,6 +64,32 @@ func TestInterface(t *testing.T) {
 
 }
 
+func TestConcurrentUse(t *testing.T) {
+	ser := New().Register(MyStr{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				in := []fmt.Stringer{&MyStr{V: "Hello"}}
+				var b bytes.Buffer
+				assert.NoError(t, ser.Write(&b, &in))
+				var out []fmt.Stringer
+				assert.NoError(t, ser.Read(&b, &out))
+				assert.EqualValues(t, "Hello", out[0].String())
+			}
+		}()
+	}
+	// registering while the serializer is in use
+	ser.Register(MyFloat{})
+	wg.Wait()
+
+	in := []fmt.Stringer{&MyFloat{V: 1}}
+	var b bytes.Buffer
+	assert.NoError(t, ser.Write(&b, &in))
+}
+
 type Test struct {
 	T time.Time
 }
@@ -88,3 +118,424 @@ func TestSerializer(t *testing.T) {
 	assert.True(t, s[2].T.Equal(r[2].T))
 
 }
+
+func TestInterfaceRegisterOrder(t *testing.T) {
+	s := []fmt.Stringer{
+		&MyStr{V: "Hello"},
+		&MyFloat{V: math.Pi},
+	}
+
+	b := bytes.Buffer{}
+	err := New().
+		Register(MyStr{}).
+		Register(MyFloat{}).
+		Write(&b, &s)
+	assert.NoError(t, err)
+
+	var r []fmt.Stringer
+	err = New().
+		Register(MyFloat{}).
+		Register(MyFloat32{}).
+		Register(MyStr{}).
+		Read(&b, &r)
+	assert.NoError(t, err)
+
+	assert.EqualValues(t, s, r)
+}
+
+func TestInterfaceRegisterName(t *testing.T) {
+	s := []fmt.Stringer{
+		&MyStr{V: "Hello"},
+		&MyFloat{V: math.Pi},
+	}
+
+	b := bytes.Buffer{}
+	err := New().
+		RegisterName("str", MyStr{}).
+		RegisterName("float", MyFloat{}).
+		Write(&b, &s)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Contains(b.Bytes(), []byte("float")))
+	assert.False(t, bytes.Contains(b.Bytes(), []byte("MyFloat")))
+
+	var r []fmt.Stringer
+	err = New().
+		RegisterName("float", MyFloat{}).
+		RegisterName("str", MyStr{}).
+		Read(&b, &r)
+	assert.NoError(t, err)
+
+	assert.EqualValues(t, s, r)
+
+	assert.Panics(t, func() { New().RegisterName("a", MyStr{}).RegisterName("a", MyFloat{}) })
+	assert.Panics(t, func() { New().RegisterName("a", MyStr{}).RegisterName("b", MyStr{}) })
+}
+
+func TestInterfaceLegacyIndex(t *testing.T) {
+	// written by the index based format
+	data := []byte{0xe, 0x1, 0x0, 0x0, 0x0, 0x10, 0x1, 0x0, 0x0, 0x80, 0xd, 0xb, 0x18, 0x2d, 0x44, 0x54, 0xfb, 0x21, 0x9, 0x40}
+
+	var r []fmt.Stringer
+	err := New().
+		Register(MyStr{}).
+		Register(MyFloat{}).
+		Read(bytes.NewReader(data), &r)
+	assert.NoError(t, err)
+
+	assert.EqualValues(t, []fmt.Stringer{&MyFloat{V: math.Pi}}, r)
+}
+
+func TestInterfaceUnregistered(t *testing.T) {
+	// written by the index based format using an index which is not registered
+	data := []byte{0xe, 0x1, 0x0, 0x0, 0x0, 0x10, 0x5, 0x0, 0x0, 0x80, 0xd, 0xb, 0x18, 0x2d, 0x44, 0x54, 0xfb, 0x21, 0x9, 0x40}
+
+	var r []fmt.Stringer
+	err := New().
+		Register(MyStr{}).
+		Register(MyFloat{}).
+		Read(bytes.NewReader(data), &r)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unregistered type index 5")
+
+	s := []fmt.Stringer{&MyStr{V: "Hello"}}
+	b := bytes.Buffer{}
+	err = New().RegisterName("str", MyStr{}).Write(&b, &s)
+	assert.NoError(t, err)
+
+	err = New().RegisterName("string", MyStr{}).Read(&b, &r)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `unregistered type name "str"`)
+}
+
+// Point implements only the text marshaler interfaces
+type Point struct {
+	X, Y int
+}
+
+func (p Point) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d;%d", p.X, p.Y)), nil
+}
+
+func (p *Point) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "%d;%d", &p.X, &p.Y)
+	return err
+}
+
+func TestTextMarshaler(t *testing.T) {
+	type st struct {
+		P  Point
+		PP *Point
+	}
+	s := []st{
+		{P: Point{1, 2}, PP: &Point{3, 4}},
+		{P: Point{-5, 6}, PP: &Point{7, 8}},
+	}
+
+	ser := New()
+	b := bytes.Buffer{}
+	err := ser.Write(&b, &s)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Contains(b.Bytes(), []byte("-5;6")))
+
+	var r []st
+	err = ser.Read(&b, &r)
+	assert.NoError(t, err)
+	assert.EqualValues(t, s, r)
+}
+
+func TestMarshalerBigAndNetip(t *testing.T) {
+	type st struct {
+		I  big.Int
+		IP *big.Int
+		R  big.Rat
+		RP *big.Rat
+		A  netip.Addr
+		AP *netip.Addr
+		MI map[string]big.Int
+		MR map[string]big.Rat
+		MA map[string]netip.Addr
+		SA []netip.Addr
+	}
+
+	a := netip.MustParseAddr("fe80::1")
+	w := st{
+		IP: big.NewInt(-7),
+		RP: big.NewRat(1, 3),
+		A:  netip.MustParseAddr("10.0.0.1"),
+		AP: &a,
+		MI: map[string]big.Int{"a": *big.NewInt(5)},
+		MR: map[string]big.Rat{"r": *big.NewRat(-3, 4)},
+		MA: map[string]netip.Addr{"x": netip.MustParseAddr("::1")},
+		SA: []netip.Addr{netip.MustParseAddr("1.2.3.4"), {}},
+	}
+	w.I.SetString("123456789012345678901234567890", 10)
+	w.R.SetFrac64(-2, 7)
+
+	ser := New()
+	var b bytes.Buffer
+	assert.NoError(t, ser.Write(&b, &w))
+
+	var r st
+	assert.NoError(t, ser.Read(&b, &r))
+	assert.EqualValues(t, 0, w.I.Cmp(&r.I))
+	assert.EqualValues(t, 0, w.IP.Cmp(r.IP))
+	assert.EqualValues(t, 0, w.R.Cmp(&r.R))
+	assert.EqualValues(t, 0, w.RP.Cmp(r.RP))
+	assert.EqualValues(t, w.A, r.A)
+	assert.EqualValues(t, a, *r.AP)
+	mi := r.MI["a"]
+	assert.EqualValues(t, "5", mi.String())
+	mr := r.MR["r"]
+	assert.EqualValues(t, "-3/4", mr.String())
+	assert.EqualValues(t, w.MA, r.MA)
+	assert.EqualValues(t, w.SA, r.SA)
+}
+
+// Celsius has a value receiver MarshalBinary and a pointer receiver
+// UnmarshalBinary
+type Celsius struct {
+	deci int16
+}
+
+func (c Celsius) MarshalBinary() ([]byte, error) {
+	return []byte{byte(c.deci), byte(c.deci >> 8)}, nil
+}
+
+func (c *Celsius) UnmarshalBinary(data []byte) error {
+	if len(data) != 2 {
+		return fmt.Errorf("invalid length %d", len(data))
+	}
+	c.deci = int16(data[0]) | int16(data[1])<<8
+	return nil
+}
+
+// OnlyMarshal can be marshaled but not unmarshaled, so it is written as a
+// plain struct
+type OnlyMarshal struct {
+	V int
+}
+
+func (o OnlyMarshal) MarshalBinary() ([]byte, error) {
+	return []byte("unreadable"), nil
+}
+
+func TestBinaryMarshalerSymmetric(t *testing.T) {
+	type st struct {
+		C  Celsius
+		M  map[string]Celsius
+		S  []Celsius
+		A  [2]Celsius
+		MK map[Celsius]int
+		O  OnlyMarshal
+		MO map[int]OnlyMarshal
+	}
+	w := st{
+		C:  Celsius{215},
+		M:  map[string]Celsius{"in": {205}, "out": {-37}},
+		S:  []Celsius{{1}, {-1}},
+		A:  [2]Celsius{{300}, {-300}},
+		MK: map[Celsius]int{{100}: 1},
+		O:  OnlyMarshal{V: 3},
+		MO: map[int]OnlyMarshal{1: {V: 4}},
+	}
+
+	ser := New()
+	var b bytes.Buffer
+	assert.NoError(t, ser.Write(&b, &w))
+	assert.False(t, bytes.Contains(b.Bytes(), []byte("unreadable")))
+
+	var r st
+	assert.NoError(t, ser.Read(&b, &r))
+	assert.EqualValues(t, w, r)
+}
+
+func TestFingerprint(t *testing.T) {
+	a := New().Register(MyStr{}).Register(MyFloat{})
+	b := New().Register(MyFloat{}).Register(MyStr{})
+	assert.EqualValues(t, a.Fingerprint(), b.Fingerprint())
+	assert.NotEqual(t, a.Fingerprint(), New().Register(MyStr{}).Fingerprint())
+	assert.NotEqual(t, a.Fingerprint(), New().Register(MyStr{}).RegisterName("float", MyFloat{}).Fingerprint())
+	assert.NotEqual(t, New().Fingerprint(), a.Fingerprint())
+
+	encode := func(any) ([]byte, error) { return nil, nil }
+	decode := func([]byte) (any, error) { return Geo{}, nil }
+	c := New().Register(MyStr{}).Register(MyFloat{}).RegisterCodec(Geo{}, encode, decode)
+	assert.NotEqual(t, a.Fingerprint(), c.Fingerprint())
+	d := New().RegisterCodec(Geo{}, encode, decode).Register(MyFloat{}).Register(MyStr{})
+	assert.EqualValues(t, c.Fingerprint(), d.Fingerprint())
+}
+
+type Base struct {
+	ID   int
+	Tags []string
+}
+
+type Derived struct {
+	Base
+	Name string
+}
+
+type DerivedPtr struct {
+	*Base
+	Name string
+}
+
+// Reading has the marshaler of Celsius only because it is promoted
+type Reading struct {
+	Celsius
+	Place string
+}
+
+type ReadingPtr struct {
+	*Celsius
+	Place string
+}
+
+type Event struct {
+	time.Time
+	Name string
+}
+
+// Labeled embeds Celsius but declares its own marshaler, which is the only
+// way to store the unexported label. The marshaler can not be told apart from
+// the promoted one, so it has to be registered as a codec.
+type Labeled struct {
+	Celsius
+	label string
+}
+
+func (l Labeled) MarshalBinary() ([]byte, error) {
+	c, _ := l.Celsius.MarshalBinary()
+	return append(c, l.label...), nil
+}
+
+func (l *Labeled) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 {
+		return fmt.Errorf("invalid length %d", len(data))
+	}
+	l.label = string(data[2:])
+	return l.Celsius.UnmarshalBinary(data[:2])
+}
+
+func TestEmbedded(t *testing.T) {
+	type st struct {
+		D  Derived
+		DP DerivedPtr
+		DN DerivedPtr
+		R  Reading
+		RP ReadingPtr
+		RN ReadingPtr
+		E  Event
+		L  Labeled
+	}
+	w := st{
+		D:  Derived{Base: Base{ID: 1, Tags: []string{"a"}}, Name: "d"},
+		DP: DerivedPtr{Base: &Base{ID: 2}, Name: "dp"},
+		DN: DerivedPtr{Name: "dn"},
+		R:  Reading{Celsius: Celsius{215}, Place: "in"},
+		RP: ReadingPtr{Celsius: &Celsius{-37}, Place: "out"},
+		RN: ReadingPtr{Place: "none"},
+		E:  Event{Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), Name: "e"},
+		L:  Labeled{Celsius: Celsius{100}, label: "boil"},
+	}
+	assert.EqualValues(t, 1, w.D.ID)
+
+	encode := func(v any) ([]byte, error) {
+		return v.(Labeled).MarshalBinary()
+	}
+	decode := func(b []byte) (any, error) {
+		var l Labeled
+		err := l.UnmarshalBinary(b)
+		return l, err
+	}
+	for _, ser := range []*Serializer{New(), New().FieldNames()} {
+		ser.RegisterCodec(Labeled{}, encode, decode)
+		var b bytes.Buffer
+		assert.NoError(t, ser.Write(&b, &w))
+
+		var r st
+		assert.NoError(t, ser.Read(&b, &r))
+		assert.EqualValues(t, w, r)
+		assert.EqualValues(t, "in", r.R.Place)
+		assert.EqualValues(t, "e", r.E.Name)
+		assert.True(t, w.E.Equal(r.E.Time))
+	}
+
+	// without the codec, Labeled is written field by field
+	var b bytes.Buffer
+	assert.NoError(t, New().Write(&b, &w.L))
+	var l Labeled
+	assert.NoError(t, New().Read(&b, &l))
+	assert.EqualValues(t, Labeled{Celsius: Celsius{100}}, l)
+}
+
+type Geo struct {
+	Lat, Lon float64
+}
+
+func TestCodec(t *testing.T) {
+	encoded, decoded := 0, 0
+	encode := func(v any) ([]byte, error) {
+		encoded++
+		g := v.(Geo)
+		// micro degrees are precise enough
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint32(b, uint32(int32(math.Round(g.Lat*1e6))))
+		binary.LittleEndian.PutUint32(b[4:], uint32(int32(math.Round(g.Lon*1e6))))
+		return b, nil
+	}
+	decode := func(b []byte) (any, error) {
+		decoded++
+		if len(b) != 8 {
+			return nil, fmt.Errorf("invalid length %d", len(b))
+		}
+		return Geo{
+			Lat: float64(int32(binary.LittleEndian.Uint32(b))) / 1e6,
+			Lon: float64(int32(binary.LittleEndian.Uint32(b[4:]))) / 1e6,
+		}, nil
+	}
+
+	type place struct {
+		Name string
+		Pos  Geo
+		Alt  *Geo
+		Any  any
+	}
+	s := New().Register(Geo{}).RegisterCodec(Geo{}, encode, decode)
+	p := place{Name: "Kiel", Pos: Geo{54.323, 10.139}, Alt: &Geo{-33.9, 18.4}, Any: Geo{1, 2}}
+
+	b := bytes.Buffer{}
+	assert.NoError(t, s.Write(&b, p))
+	assert.EqualValues(t, 3, encoded)
+	size, err := New().Register(Geo{}).SizeOf(p)
+	assert.NoError(t, err)
+	assert.True(t, b.Len() < size)
+
+	var r place
+	assert.NoError(t, s.Read(&b, &r))
+	assert.EqualValues(t, 3, decoded)
+	assert.EqualValues(t, p, r)
+
+	// a codec takes precedence over a marshaler
+	s = New().RegisterCodec(Point{}, func(v any) ([]byte, error) {
+		return []byte{byte(v.(Point).X), byte(v.(Point).Y)}, nil
+	}, func(b []byte) (any, error) {
+		return Point{X: int(b[0]), Y: int(b[1])}, nil
+	})
+	b.Reset()
+	assert.NoError(t, s.Write(&b, Point{3, 4}))
+	assert.False(t, bytes.Contains(b.Bytes(), []byte("3;4")))
+	var pt Point
+	assert.NoError(t, s.Read(&b, &pt))
+	assert.EqualValues(t, Point{3, 4}, pt)
+
+	s = New().RegisterCodec(Geo{}, encode, func(b []byte) (any, error) { return Point{}, nil })
+	b.Reset()
+	assert.NoError(t, s.Write(&b, Geo{}))
+	var g Geo
+	err = s.Read(&b, &g)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "returned a value of type serialize.Point")
+
+	assert.Panics(t, func() { s.RegisterCodec(Geo{}, encode, decode) })
+}
@@ -1,15 +1,30 @@
 // Package serialize is a simple package to serialize data.
 // It is able to serialize and deserialize interfaces.
 // A custom binary format is generated that is compatible to nothing.
+// Channels, functions, uintptr and unsafe pointers are not supported. A
+// uintptr is rejected because it usually holds an address which is
+// meaningless outside the running process.
+// A []byte is written as a single block of bytes. Data written by older
+// versions, which wrote it element by element, can still be read, but older
+// versions can not read a block. Use Serializer.LegacyBytes to write data
+// which older versions have to read.
 package serialize
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"encoding"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"math"
 	"math/bits"
 	"reflect"
+	"sort"
+	"sync"
 )
 
 type typeCode uint8
@@ -32,43 +47,403 @@ const (
 	arrayCode
 	mapCode
 	interfaceCode
+	namedInterfaceCode
+	pointerCode
+	namedStructCode
+	complex64Code
+	complex128Code
+	bytesCode
 )
 
 const pointerMask = 1 << 31
 
+// DefaultMaxAlloc is the default maximum length of strings, slices and maps
+// accepted while reading.
+const DefaultMaxAlloc = 1 << 26
+
+// DefaultMaxFrame is the default maximum size in bytes of a record written by
+// WriteFramed or read by ReadFramed.
+const DefaultMaxFrame = 1 << 30
+
+// DefaultMaxPointerDepth is the default maximum number of nested pointers
+// followed while writing.
+const DefaultMaxPointerDepth = 1000
+
+// ErrPointerDepth is returned if the pointer nesting exceeds the configured
+// maximum depth. This usually means that the data contains a cycle.
+var ErrPointerDepth = errors.New("serialize: pointer nesting too deep (possible cycle)")
+
+// ErrTrailingData is returned by ReadStrict if the stream contains further
+// bytes after the value.
+var ErrTrailingData = errors.New("serialize: trailing data after value")
+
+// Serializer writes and reads data. A Serializer can be used by multiple
+// goroutines concurrently. Types can be registered at any time, also
+// concurrently to reading and writing. The options like SortMapKeys or
+// MaxAlloc have to be set before the Serializer is used.
 type Serializer struct {
-	typeList []reflect.Type
-	typeMap  map[string]uint32
+	m               sync.RWMutex
+	typeList        []reflect.Type
+	typeNames       map[reflect.Type]string
+	nameTypes       map[string]reflect.Type
+	sortMapKeys     bool
+	strictFields    bool
+	fieldNames      bool
+	legacyBytes     bool
+	maxPointerDepth int
+	maxAlloc        int
+	maxFrame        int
+	codecs          sync.Map // reflect.Type -> codec
 }
 
 // New creates a new serializer. The serializer is able to serialize and
 // deserialize interfaces. To do that the interface has to be registered with
 // Register.
 func New() *Serializer {
-	return &Serializer{typeMap: map[string]uint32{}}
+	return &Serializer{
+		typeNames:       map[reflect.Type]string{},
+		nameTypes:       map[string]reflect.Type{},
+		maxPointerDepth: DefaultMaxPointerDepth,
+		maxAlloc:        DefaultMaxAlloc,
+		maxFrame:        DefaultMaxFrame,
+	}
 }
 
-// Register registers a interface for serialization
+// Register registers a interface for serialization. The type is identified in
+// the stream by the name returned by reflect.Type.String(). If the type
+// could be renamed or moved to another package, use RegisterName instead.
 func (s *Serializer) Register(i any) *Serializer {
+	return s.RegisterName(reflect.TypeOf(i).String(), i)
+}
+
+// RegisterName registers a interface for serialization. The type is
+// identified in the stream by the given name. So the written data stays
+// readable if the type is renamed. The order in which the types are
+// registered does not matter. It panics if the name or the type is already
+// registered.
+func (s *Serializer) RegisterName(name string, i any) *Serializer {
 	t := reflect.TypeOf(i)
-	s.typeMap[t.String()] = uint32(len(s.typeList))
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if n, ok := s.typeNames[t]; ok {
+		panic(fmt.Sprintf("serialize: type %v registered twice, as %q and %q", t, n, name))
+	}
+	if ot, ok := s.nameTypes[name]; ok {
+		panic(fmt.Sprintf("serialize: name %q registered twice, for %v and %v", name, ot, t))
+	}
+	s.typeNames[t] = name
+	s.nameTypes[name] = t
 	s.typeList = append(s.typeList, t)
 	return s
 }
 
+// codec is a pair of functions registered by RegisterCodec.
+type codec struct {
+	encode func(any) ([]byte, error)
+	decode func([]byte) (any, error)
+}
+
+// RegisterCodec registers functions which are used to write and read values
+// of the type of sample instead of the reflection based encoding or a
+// marshaler implemented by the type. The encode function is called with a
+// value of this type, and the decode function has to return a value of this
+// type. The bytes are written as a byte slice. Codecs are used wherever the
+// type occurs, e.g. as a struct field or in an interface. It panics if a codec
+// for the type is already registered.
+func (s *Serializer) RegisterCodec(sample any, encode func(any) ([]byte, error), decode func([]byte) (any, error)) *Serializer {
+	t := reflect.TypeOf(sample)
+	if _, loaded := s.codecs.LoadOrStore(t, codec{encode: encode, decode: decode}); loaded {
+		panic(fmt.Sprintf("serialize: codec for type %v registered twice", t))
+	}
+	return s
+}
+
+// codecOf returns the codec registered for the given type.
+func (s *Serializer) codecOf(t reflect.Type) (codec, bool) {
+	c, ok := s.codecs.Load(t)
+	if !ok {
+		return codec{}, false
+	}
+	return c.(codec), true
+}
+
+// Fingerprint returns a hash of the registered names and types, including the
+// types a codec is registered for. Data written with a serializer can be read
+// by another serializer having the same fingerprint. The order of the
+// registration does not affect the fingerprint, because the types are
+// identified by their names. The codec functions themselves are not part of
+// the fingerprint.
+func (s *Serializer) Fingerprint() string {
+	s.m.RLock()
+	names := make([]string, 0, len(s.nameTypes))
+	for name, t := range s.nameTypes {
+		names = append(names, name+"="+t.String())
+	}
+	s.m.RUnlock()
+	s.codecs.Range(func(t, _ any) bool {
+		names = append(names, "codec:"+t.(reflect.Type).String())
+		return true
+	})
+
+	sort.Strings(names)
+	h := sha256.New()
+	for _, n := range names {
+		h.Write([]byte(n))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SortMapKeys enables sorting of map keys before writing. This makes the
+// output deterministic. Only maps with integer, float or string keys are
+// sorted, all other maps are written in range order.
+func (s *Serializer) SortMapKeys() *Serializer {
+	s.sortMapKeys = true
+	return s
+}
+
+// StrictFields enables the strict field mode. By default, unexported struct
+// fields are silently skipped. In strict mode, writing a struct with an
+// unexported field returns an error, unless the field is tagged with
+// `serialize:"-"`.
+func (s *Serializer) StrictFields() *Serializer {
+	s.strictFields = true
+	return s
+}
+
+// FieldNames enables writing the names of struct fields. By default, the
+// fields of a struct are written by their position, so the data becomes
+// unreadable if a field is added to or removed from the struct. If field
+// names are written, fields missing in the stream are left zero and fields
+// unknown to the struct are skipped while reading. This makes the data larger.
+// Data written with and without field names can be read in both modes.
+func (s *Serializer) FieldNames() *Serializer {
+	s.fieldNames = true
+	return s
+}
+
+// LegacyBytes enables writing byte slices element by element, as done by
+// older versions of this package, which are not able to read a byte slice
+// written as a single block. This makes the data about twice as large and
+// writing and reading considerably slower. Reading is not affected by this
+// option, both formats are always readable.
+func (s *Serializer) LegacyBytes() *Serializer {
+	s.legacyBytes = true
+	return s
+}
+
+// MaxPointerDepth sets the maximum number of nested pointers which are
+// followed while writing. If the limit is exceeded, ErrPointerDepth is
+// returned. This prevents a stack overflow if the data contains a cycle.
+func (s *Serializer) MaxPointerDepth(depth int) *Serializer {
+	s.maxPointerDepth = depth
+	return s
+}
+
+// MaxAlloc sets the maximum length of strings, slices and maps accepted while
+// reading. The length of a string is given in bytes, the length of a slice or
+// map in elements. If a longer length is found in the stream, an error is
+// returned. This prevents huge allocations caused by corrupt data.
+func (s *Serializer) MaxAlloc(n int) *Serializer {
+	s.maxAlloc = n
+	return s
+}
+
+// MaxFrame sets the maximum size in bytes of a record written by WriteFramed
+// or read by ReadFramed. The same limit is applied to both, so that every
+// record written can also be read again.
+func (s *Serializer) MaxFrame(n int) *Serializer {
+	s.maxFrame = n
+	return s
+}
+
 // Write writes the data to the writer
+// If data is a pointer, the value it points to is written.
 func (s *Serializer) Write(w io.Writer, data any) error {
-	return s.writeValue(w, reflect.ValueOf(data), 0)
+	v := reflect.ValueOf(data)
+	if v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	return s.writeValue(&scratchWriter{w: w}, v, 0)
+}
+
+// WriteCompressed writes the data like Write, but compresses the stream
+// using gzip. The data can be read by ReadCompressed.
+func (s *Serializer) WriteCompressed(w io.Writer, data any) error {
+	zw := gzip.NewWriter(w)
+	err := s.Write(zw, data)
+	if err != nil {
+		return err
+	}
+	err = zw.Close()
+	if err != nil {
+		return fmt.Errorf("could not compress data: %w", err)
+	}
+	return nil
+}
+
+// ReadCompressed reads data written by WriteCompressed. The compressed
+// stream is read to its end, so that the checksum and the size stored at the
+// end of the stream are verified.
+func (s *Serializer) ReadCompressed(r io.Reader, data any) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("could not decompress data: %w", err)
+	}
+	err = s.ReadStrict(zr, data)
+	if err != nil {
+		return err
+	}
+	err = zr.Close()
+	if err != nil {
+		return fmt.Errorf("could not decompress data: %w", err)
+	}
+	return nil
+}
+
+// SizeOf returns the number of bytes Write would write for the data. The
+// data is encoded the same way, but the bytes are only counted.
+func (s *Serializer) SizeOf(data any) (int, error) {
+	var c countingWriter
+	err := s.Write(&c, data)
+	if err != nil {
+		return 0, err
+	}
+	return c.n, nil
+}
+
+// countingWriter counts the bytes written and discards them.
+type countingWriter struct {
+	n int
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	c.n += len(b)
+	return len(b), nil
+}
+
+// scratchWriter wraps the writer of a single Write call. It provides a buffer
+// which is used to write small values without allocating memory, so that a
+// Serializer can be used concurrently.
+type scratchWriter struct {
+	w       io.Writer
+	scratch [9]byte
+}
+
+func (sw *scratchWriter) Write(b []byte) (int, error) {
+	return sw.w.Write(b)
+}
+
+func (sw *scratchWriter) WriteString(str string) (int, error) {
+	return io.WriteString(sw.w, str)
+}
+
+// writeBuffer returns a buffer of the given length which is valid until the
+// next call.
+func writeBuffer(w io.Writer, n int) []byte {
+	if sw, ok := w.(*scratchWriter); ok && n <= len(sw.scratch) {
+		return sw.scratch[:n]
+	}
+	return make([]byte, n)
 }
 
 var (
 	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
 	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	textMarshalerType     = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
 )
 
+// implementing returns the value itself or its address if one of them
+// implements the interface t. If only the pointer implements the interface
+// and the value is not addressable, which is the case for map values, the
+// address of a copy is returned.
+func implementing(v reflect.Value, t reflect.Type) (reflect.Value, bool) {
+	if !v.IsValid() || v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+		return v, false
+	}
+	if v.Type().Implements(t) {
+		return v, true
+	}
+	if reflect.PointerTo(v.Type()).Implements(t) {
+		if v.CanAddr() {
+			return v.Addr(), true
+		}
+		c := reflect.New(v.Type())
+		c.Elem().Set(v)
+		return c, true
+	}
+	return v, false
+}
+
+// marshaler returns the marshaler interface used to write values of the
+// given type and the matching unmarshaler interface used to read them. A
+// marshaler is only used if the type, or the pointer to it, implements both
+// interfaces, so that the data written can also be read. A marshaler which
+// a struct only has because it is promoted from an embedded field is not used,
+// because it would write the embedded field only. Such a struct is written
+// field by field, and the embedded field uses its marshaler. This also
+// applies if the struct declares the marshaler itself; to use it anyway, it
+// has to be registered with RegisterCodec. If no marshaler is used, nil is
+// returned.
+func marshaler(t reflect.Type) (reflect.Type, reflect.Type) {
+	if t.Kind() == reflect.Interface || t.Kind() == reflect.Pointer {
+		return nil, nil
+	}
+	pt := reflect.PointerTo(t)
+	if pt.Implements(binaryMarshalerType) && pt.Implements(binaryUnmarshalerType) && !promoted(t, "MarshalBinary") {
+		return binaryMarshalerType, binaryUnmarshalerType
+	}
+	if pt.Implements(textMarshalerType) && pt.Implements(textUnmarshalerType) && !promoted(t, "MarshalText") {
+		return textMarshalerType, textUnmarshalerType
+	}
+	return nil, nil
+}
+
+// promoted returns true if t is a struct with an embedded field whose type,
+// or the pointer to it, has the method with the given name. Reflection can
+// not tell such a promoted method apart from a method of the same name
+// declared by the struct itself, so in both cases the method is considered
+// to be promoted.
+func promoted(t reflect.Type, name string) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.Anonymous {
+			continue
+		}
+		if _, ok := f.Type.MethodByName(name); ok {
+			return true
+		}
+		if f.Type.Kind() != reflect.Pointer {
+			if _, ok := reflect.PointerTo(f.Type).MethodByName(name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (s *Serializer) writeValue(w io.Writer, v reflect.Value, ptrDepth int) error {
-	if v.IsValid() && v.Type().Implements(binaryMarshalerType) {
-		return s.binMarshal(w, v, ptrDepth)
+	if v.IsValid() {
+		if c, ok := s.codecOf(v.Type()); ok {
+			b, err := c.encode(v.Interface())
+			if err != nil {
+				return fmt.Errorf("error calling codec of %v: %w", v.Type(), err)
+			}
+			return s.writeBytes(w, b)
+		}
+		switch mt, _ := marshaler(v.Type()); mt {
+		case binaryMarshalerType:
+			m, _ := implementing(v, binaryMarshalerType)
+			return s.binMarshal(w, m, ptrDepth)
+		case textMarshalerType:
+			m, _ := implementing(v, textMarshalerType)
+			return s.textMarshal(w, m)
+		}
 	}
 
 	switch v.Kind() {
@@ -106,35 +481,74 @@ func (s *Serializer) writeValue(w io.Writer, v reflect.Value, ptrDepth int) erro
 		return s.writeIntBytes(w, float32Code, int64(math.Float32bits(float32(v.Float()))), 4)
 	case reflect.Float64:
 		return s.writeIntBytes(w, float64Code, int64(math.Float64bits(v.Float())), 8)
+	case reflect.Complex64:
+		c := v.Complex()
+		return s.writeComplex(w, complex64Code,
+			uint64(math.Float32bits(float32(real(c)))),
+			uint64(math.Float32bits(float32(imag(c)))), 4)
+	case reflect.Complex128:
+		c := v.Complex()
+		return s.writeComplex(w, complex128Code, math.Float64bits(real(c)), math.Float64bits(imag(c)), 8)
 	case reflect.String:
 		return s.writeString(w, v.String())
 	case reflect.Struct:
 		return s.writeStruct(w, v, ptrDepth)
 	case reflect.Pointer:
+		if v.IsNil() {
+			return s.writeTypeCode(w, invalidCode)
+		}
+		if ptrDepth >= s.maxPointerDepth {
+			return ErrPointerDepth
+		}
+		err := s.writeTypeCode(w, pointerCode)
+		if err != nil {
+			return err
+		}
 		return s.writeValue(w, v.Elem(), ptrDepth+1)
 	case reflect.Invalid:
 		return s.writeTypeCode(w, invalidCode)
-	case reflect.Slice, reflect.Array:
+	case reflect.Slice, reflect.Map:
+		// nil is written like a nil pointer, to distinguish it from empty
+		if v.IsNil() {
+			return s.writeTypeCode(w, invalidCode)
+		}
+		if v.Kind() == reflect.Map {
+			return s.writeMap(w, v, ptrDepth)
+		}
+		if isBytes(v.Type()) && !s.legacyBytes {
+			return s.writeBytes(w, v.Bytes())
+		}
+		return s.writeArray(w, v, ptrDepth)
+	case reflect.Array:
 		return s.writeArray(w, v, ptrDepth)
-	case reflect.Map:
-		return s.writeMap(w, v, ptrDepth)
 	case reflect.Interface:
+		if !v.IsNil() && ptrDepth >= s.maxPointerDepth {
+			return ErrPointerDepth
+		}
 		return s.writeInterface(w, v, ptrDepth+1)
 	default:
-		return fmt.Errorf("unsuported type %v", v)
+		return fmt.Errorf("serialize: unsupported kind %v of type %v", v.Kind(), v.Type())
 	}
 }
 
 func (s *Serializer) binMarshal(w io.Writer, v reflect.Value, depth int) error {
 	r := v.MethodByName("MarshalBinary").Call(nil)
 	if !(r[1].IsNil()) {
-		return fmt.Errorf("error calling MarshalBinary")
+		return fmt.Errorf("error calling MarshalBinary on %v: %v", v.Type(), r[1])
 	}
 	return s.writeValue(w, r[0], depth)
 }
 
+func (s *Serializer) textMarshal(w io.Writer, v reflect.Value) error {
+	r := v.MethodByName("MarshalText").Call(nil)
+	if !(r[1].IsNil()) {
+		return fmt.Errorf("error calling MarshalText on %v: %v", v.Type(), r[1])
+	}
+	return s.writeString(w, string(r[0].Bytes()))
+}
+
 func (s *Serializer) writeInterface(w io.Writer, v reflect.Value, depth int) error {
-	err := s.writeTypeCode(w, interfaceCode)
+	err := s.writeTypeCode(w, namedInterfaceCode)
 	if err != nil {
 		return err
 	}
@@ -147,17 +561,24 @@ func (s *Serializer) writeInterface(w io.Writer, v reflect.Value, depth int) err
 		val = val.Elem()
 	}
 
-	ic, ok := s.typeMap[val.Type().String()]
+	s.m.RLock()
+	name, ok := s.typeNames[val.Type()]
+	s.m.RUnlock()
 
 	if !ok {
 		return fmt.Errorf("found unregistered interface %v", val.Type())
 	}
 
+	l := uint32(len(name))
 	if pointer {
-		ic |= pointerMask
+		l |= pointerMask
 	}
 
-	err = s.writeInt32(w, ic)
+	err = s.writeInt32(w, l)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, name)
 	if err != nil {
 		return err
 	}
@@ -176,6 +597,22 @@ func (s *Serializer) writeMap(w io.Writer, v reflect.Value, ptrDepth int) error
 		return err
 	}
 
+	if s.sortMapKeys {
+		if keys, ok := sortedKeys(v); ok {
+			for _, k := range keys {
+				err = s.writeValue(w, k, ptrDepth)
+				if err != nil {
+					return err
+				}
+				err = s.writeValue(w, v.MapIndex(k), ptrDepth)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
 	it := v.MapRange()
 	for it.Next() {
 		err = s.writeValue(w, it.Key(), ptrDepth)
@@ -191,6 +628,29 @@ func (s *Serializer) writeMap(w io.Writer, v reflect.Value, ptrDepth int) error
 	return nil
 }
 
+// sortedKeys returns the sorted keys of the map. If the key type is not
+// ordered, false is returned.
+func sortedKeys(v reflect.Value) ([]reflect.Value, bool) {
+	var less func(a, b reflect.Value) bool
+	switch v.Type().Key().Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		less = func(a, b reflect.Value) bool { return a.Int() < b.Int() }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		less = func(a, b reflect.Value) bool { return a.Uint() < b.Uint() }
+	case reflect.Float32, reflect.Float64:
+		less = func(a, b reflect.Value) bool { return a.Float() < b.Float() }
+	case reflect.String:
+		less = func(a, b reflect.Value) bool { return a.String() < b.String() }
+	default:
+		return nil, false
+	}
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return less(keys[i], keys[j])
+	})
+	return keys, true
+}
+
 func (s *Serializer) writeArray(w io.Writer, v reflect.Value, prtDepth int) error {
 	err := s.writeTypeCode(w, arrayCode)
 	if err != nil {
@@ -210,19 +670,45 @@ func (s *Serializer) writeArray(w io.Writer, v reflect.Value, prtDepth int) erro
 	return nil
 }
 
-func (s *Serializer) writeBool(w io.Writer, v reflect.Value) error {
-	err := s.writeTypeCode(w, boolCode)
+var byteType = reflect.TypeFor[byte]()
+
+// isBytes returns true if t is a byte slice which is written as a single
+// block of bytes instead of element by element. Slices of named byte types
+// are written element by element, because the named type can implement a
+// marshaler.
+func isBytes(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem() == byteType
+}
+
+// writeBytes writes the length of the byte slice followed by the bytes.
+func (s *Serializer) writeBytes(w io.Writer, b []byte) error {
+	err := s.writeTypeCode(w, bytesCode)
 	if err != nil {
 		return err
 	}
+	err = s.writeInt32(w, uint32(len(b)))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (s *Serializer) writeBool(w io.Writer, v reflect.Value) error {
+	buf := writeBuffer(w, 2)
+	buf[0] = byte(boolCode)
+	buf[1] = 0
 	if v.Bool() {
-		return s.writeBytes(w, 1)
-	} else {
-		return s.writeBytes(w, 0)
+		buf[1] = 1
 	}
+	_, err := w.Write(buf)
+	return err
 }
 
 func (s *Serializer) writeStruct(w io.Writer, v reflect.Value, ptrDepth int) error {
+	if s.fieldNames {
+		return s.writeNamedStruct(w, v, ptrDepth)
+	}
 	err := s.writeTypeCode(w, structCode)
 	if err != nil {
 		return err
@@ -230,16 +716,82 @@ func (s *Serializer) writeStruct(w io.Writer, v reflect.Value, ptrDepth int) err
 	t := v.Type()
 	for i := 0; i < v.NumField(); i++ {
 		field := v.Field(i)
-		if t.Field(i).IsExported() {
+		f := t.Field(i)
+		if isSerialized(f) {
+			err = checkFieldKind(t, f)
+			if err != nil {
+				return err
+			}
 			err = s.writeValue(w, field, ptrDepth)
 			if err != nil {
 				return err
 			}
+		} else if s.strictFields && !f.IsExported() && f.Tag.Get("serialize") != "-" {
+			return fmt.Errorf("unexported field %s in %v can not be serialized", f.Name, t)
+		}
+	}
+	return nil
+}
+
+// writeNamedStruct writes the number of fields followed by the name and the
+// value of each field.
+func (s *Serializer) writeNamedStruct(w io.Writer, v reflect.Value, ptrDepth int) error {
+	err := s.writeTypeCode(w, namedStructCode)
+	if err != nil {
+		return err
+	}
+	t := v.Type()
+	var fields []int
+	for i := 0; i < v.NumField(); i++ {
+		f := t.Field(i)
+		if isSerialized(f) {
+			err = checkFieldKind(t, f)
+			if err != nil {
+				return err
+			}
+			fields = append(fields, i)
+		} else if s.strictFields && !f.IsExported() && f.Tag.Get("serialize") != "-" {
+			return fmt.Errorf("unexported field %s in %v can not be serialized", f.Name, t)
+		}
+	}
+	err = s.writeInt32(w, uint32(len(fields)))
+	if err != nil {
+		return err
+	}
+	for _, i := range fields {
+		name := t.Field(i).Name
+		err = s.writeInt32(w, uint32(len(name)))
+		if err != nil {
+			return err
 		}
+		_, err = io.WriteString(w, name)
+		if err != nil {
+			return err
+		}
+		err = s.writeValue(w, v.Field(i), ptrDepth)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFieldKind returns an error naming the field if the kind of the field
+// is not supported.
+func checkFieldKind(t reflect.Type, f reflect.StructField) error {
+	switch f.Type.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Uintptr, reflect.UnsafePointer:
+		return fmt.Errorf("serialize: field %s of %v has unsupported kind %v", f.Name, t, f.Type.Kind())
 	}
 	return nil
 }
 
+// isSerialized returns true if the field is to be serialized. Unexported fields
+// and fields tagged with `serialize:"-"` are skipped.
+func isSerialized(f reflect.StructField) bool {
+	return f.IsExported() && f.Tag.Get("serialize") != "-"
+}
+
 func (s *Serializer) writeString(w io.Writer, str string) error {
 	err := s.writeTypeCode(w, stringCode)
 	if err != nil {
@@ -249,41 +801,50 @@ func (s *Serializer) writeString(w io.Writer, str string) error {
 	if err != nil {
 		return err
 	}
-	_, err = w.Write([]byte(str))
+	_, err = io.WriteString(w, str)
 	return err
 }
 
 func (s *Serializer) writeIntBytes(w io.Writer, code typeCode, v int64, n int) error {
-	err := s.writeTypeCode(w, code)
-	if err != nil {
-		return err
-	}
-	for i := 0; i < n; i++ {
-		err = s.writeBytes(w, byte(v&0xff))
-		if err != nil {
-			return err
-		}
+	buf := writeBuffer(w, n+1)
+	buf[0] = byte(code)
+	for i := 1; i <= n; i++ {
+		buf[i] = byte(v & 0xff)
 		v = v >> 8
 	}
+	_, err := w.Write(buf)
 	return err
 }
 
-func (s *Serializer) writeInt32(w io.Writer, i uint32) error {
-	return s.writeBytes(w,
-		byte(i&0xff),
-		byte((i>>8)&0xff),
-		byte((i>>16)&0xff),
-		byte((i>>24)&0xff),
-	)
+// writeComplex writes the type code followed by the bits of the real and the
+// imaginary part, each of them using n bytes.
+func (s *Serializer) writeComplex(w io.Writer, code typeCode, re, im uint64, n int) error {
+	buf := writeBuffer(w, 2*n+1)
+	buf[0] = byte(code)
+	for i := 1; i <= n; i++ {
+		buf[i] = byte(re & 0xff)
+		buf[i+n] = byte(im & 0xff)
+		re = re >> 8
+		im = im >> 8
+	}
+	_, err := w.Write(buf)
+	return err
 }
 
-func (s *Serializer) writeTypeCode(w io.Writer, c typeCode) error {
-	_, err := w.Write([]byte{byte(c)})
+func (s *Serializer) writeInt32(w io.Writer, i uint32) error {
+	buf := writeBuffer(w, 4)
+	buf[0] = byte(i & 0xff)
+	buf[1] = byte((i >> 8) & 0xff)
+	buf[2] = byte((i >> 16) & 0xff)
+	buf[3] = byte((i >> 24) & 0xff)
+	_, err := w.Write(buf)
 	return err
 }
 
-func (s *Serializer) writeBytes(w io.Writer, b ...byte) error {
-	_, err := w.Write(b)
+func (s *Serializer) writeTypeCode(w io.Writer, c typeCode) error {
+	buf := writeBuffer(w, 1)
+	buf[0] = byte(c)
+	_, err := w.Write(buf)
 	return err
 }
 
@@ -294,21 +855,230 @@ func (s *Serializer) Read(r io.Reader, data any) (err error) {
 		return fmt.Errorf("invalid target type: %v", reflect.TypeOf(data))
 	}
 
+	pr := newPeekReader(r)
+	return s.decode(func() {
+		s.readValue(pr, rv.Elem())
+	})
+}
+
+// ReadInto reads a value of the type of sample from the reader and returns
+// it. The sample itself is not modified, it only provides the type, so no
+// variable of this type has to be declared. If sample is a pointer, a pointer
+// to a newly allocated value is returned.
+func (s *Serializer) ReadInto(r io.Reader, sample any) (any, error) {
+	if sample == nil {
+		return nil, errors.New("invalid sample: nil")
+	}
+	v := reflect.New(reflect.TypeOf(sample))
+	err := s.Read(r, v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
+// ReadStrict reads the data from the reader like Read, but returns
+// ErrTrailingData if the stream is not completely consumed by the value. This
+// detects files which contain garbage after the data, e.g. caused by a
+// defective rewrite.
+func (s *Serializer) ReadStrict(r io.Reader, data any) error {
+	pr := newPeekReader(r)
+	err := s.Read(pr, data)
+	if err != nil {
+		return err
+	}
+	n, err := pr.Read(pr.scratch[:1])
+	if n > 0 {
+		return ErrTrailingData
+	}
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("could not check for trailing data: %w", err)
+	}
+	return nil
+}
+
+// peekReader allows to look at the next type code without consuming it. It
+// also provides the buffers used to read the data of a single Read call.
+type peekReader struct {
+	r       io.Reader
+	peeked  bool
+	peekVal byte
+	scratch [8]byte
+	buf     []byte
+}
+
+// readBuffer returns a buffer of the given length which is valid until the
+// next call.
+func readBuffer(r io.Reader, n int) []byte {
+	pr, ok := r.(*peekReader)
+	if !ok {
+		return make([]byte, n)
+	}
+	if n <= len(pr.scratch) {
+		return pr.scratch[:n]
+	}
+	if n > cap(pr.buf) {
+		pr.buf = make([]byte, n)
+	}
+	return pr.buf[:n]
+}
+
+func newPeekReader(r io.Reader) *peekReader {
+	if pr, ok := r.(*peekReader); ok {
+		return pr
+	}
+	return &peekReader{r: r}
+}
+
+func (p *peekReader) Read(b []byte) (int, error) {
+	if p.peeked && len(b) > 0 {
+		b[0] = p.peekVal
+		p.peeked = false
+		return 1, nil
+	}
+	return p.r.Read(b)
+}
+
+// peekTypeCode returns the next type code without consuming it.
+func peekTypeCode(r io.Reader) typeCode {
+	pr, ok := r.(*peekReader)
+	if !ok {
+		panic("reader does not support peeking")
+	}
+	if !pr.peeked {
+		_, err := io.ReadFull(pr.r, pr.scratch[:1])
+		if err != nil {
+			panic(fmt.Errorf("could not read type code: %w", err))
+		}
+		pr.peekVal = pr.scratch[0]
+		pr.peeked = true
+	}
+	return typeCode(pr.peekVal)
+}
+
+// decode calls the given function and converts a panic to an error.
+func (s *Serializer) decode(f func()) (err error) {
 	defer func() {
 		if rec := recover(); rec != nil {
 			err = fmt.Errorf("error during decoding: %v", rec)
 		}
 	}()
 
-	s.readValue(r, rv)
+	f()
 	return nil
 }
 
+// ReadSeq reads a slice or array of elements of type elemType from the reader.
+// In contrast to Read, the elements are not collected in a slice but are
+// decoded and yielded one after the other. So a large stream can be processed
+// without holding all elements in memory. The yielded values are pointers to
+// a newly allocated elemType. If an error occurs, it is yielded together with
+// a nil value and the iteration stops.
+func (s *Serializer) ReadSeq(r io.Reader, elemType reflect.Type) iter.Seq2[any, error] {
+	return func(yield func(any, error) bool) {
+		r := newPeekReader(r)
+		var l int
+		err := s.decode(func() {
+			expect(r, arrayCode)
+			l = int(s.readInt32(r))
+		})
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		for i := 0; i < l; i++ {
+			val := reflect.New(elemType)
+			err = s.decode(func() {
+				s.readValue(r, val.Elem())
+			})
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(val.Interface(), nil) {
+				return
+			}
+		}
+	}
+}
+
+// ReadSeqOf is the typed version of Serializer.ReadSeq. It reads a slice or
+// array with elements of type T and yields one element after the other.
+func ReadSeqOf[T any](s *Serializer, r io.Reader) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for v, err := range s.ReadSeq(r, reflect.TypeFor[T]()) {
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+			if !yield(*v.(*T), nil) {
+				return
+			}
+		}
+	}
+}
+
+// WriteFramed writes the data as a single record which is prefixed by its
+// length in bytes. In contrast to Write, several records can be written to the
+// same stream, and a record can be appended to an existing stream.
+func (s *Serializer) WriteFramed(w io.Writer, data any) error {
+	var buf bytes.Buffer
+	err := s.Write(&buf, data)
+	if err != nil {
+		return err
+	}
+	if buf.Len() > s.maxFrame || buf.Len() > math.MaxUint32 {
+		return fmt.Errorf("record of %d bytes is too large", buf.Len())
+	}
+	err = s.writeInt32(w, uint32(buf.Len()))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// ReadFramed reads the next record written by WriteFramed. If there are no
+// more records, io.EOF is returned. The whole record is consumed even if it
+// could not be decoded, so the reading can continue with the next record.
+func (s *Serializer) ReadFramed(r io.Reader, data any) error {
+	var head [4]byte
+	_, err := io.ReadFull(r, head[:])
+	if err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return fmt.Errorf("could not read record length: %w", err)
+	}
+	l := uint32(head[0]) | (uint32(head[1]) << 8) | (uint32(head[2]) << 16) | (uint32(head[3]) << 24)
+	if uint64(l) > uint64(s.maxFrame) {
+		return fmt.Errorf("record length %d exceeds maximum of %d, data corrupt?", l, s.maxFrame)
+	}
+	buf := make([]byte, l)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return fmt.Errorf("could not read record of %d bytes: %w", l, err)
+	}
+	return s.ReadStrict(bytes.NewReader(buf), data)
+}
+
 func (s *Serializer) readValue(r io.Reader, v reflect.Value) {
-	if v.CanAddr() && v.Addr().Type().Implements(binaryUnmarshalerType) {
-		s.binUnmarshal(r, v)
+	if c, ok := s.codecOf(v.Type()); ok {
+		s.codecDecode(r, v, c)
 		return
 	}
+	if v.CanAddr() {
+		switch _, ut := marshaler(v.Type()); ut {
+		case binaryUnmarshalerType:
+			s.binUnmarshal(r, v)
+			return
+		case textUnmarshalerType:
+			s.textUnmarshal(r, v)
+			return
+		}
+	}
 
 	switch v.Kind() {
 	case reflect.Struct:
@@ -339,9 +1109,22 @@ func (s *Serializer) readValue(r io.Reader, v reflect.Value) {
 		s.readFloat64(r, v)
 	case reflect.Float32:
 		s.readFloat32(r, v)
+	case reflect.Complex128:
+		s.readComplex128(r, v)
+	case reflect.Complex64:
+		s.readComplex64(r, v)
 	case reflect.String:
 		s.readString(r, v)
 	case reflect.Pointer:
+		switch peekTypeCode(r) {
+		case invalidCode:
+			readTypeCode(r)
+			v.Set(reflect.Zero(v.Type()))
+			return
+		case pointerCode:
+			readTypeCode(r)
+		}
+		// data written by older versions has no pointer code
 		if v.IsNil() {
 			nv := reflect.New(v.Type().Elem())
 			v.Set(nv)
@@ -356,22 +1139,51 @@ func (s *Serializer) readValue(r io.Reader, v reflect.Value) {
 	case reflect.Interface:
 		s.readInterface(r, v)
 	default:
-		panic(fmt.Errorf("unsuported type %v", v.Type()))
+		panic(fmt.Errorf("serialize: unsupported kind %v of type %v", v.Kind(), v.Type()))
 	}
 }
 
 func (s *Serializer) readInterface(r io.Reader, v reflect.Value) {
-	expect(r, interfaceCode)
+	code := readTypeCode(r)
 	ic := s.readInt32(r)
 
 	pointer := ic&pointerMask != 0
 	ic &= pointerMask - 1
 
-	intType := s.typeList[ic]
+	var intType reflect.Type
+	switch code {
+	case namedInterfaceCode:
+		buf := readBuffer(r, s.checkLen(ic))
+		_, err := io.ReadFull(r, buf)
+		if err != nil {
+			panic(fmt.Errorf("could not read type name: %w", err))
+		}
+		var ok bool
+		s.m.RLock()
+		intType, ok = s.nameTypes[string(buf)]
+		s.m.RUnlock()
+		if !ok {
+			panic(fmt.Errorf("found unregistered type name %q", string(buf)))
+		}
+	case interfaceCode:
+		// legacy format which identifies the type by its registration index
+		s.m.RLock()
+		if int(ic) < len(s.typeList) {
+			intType = s.typeList[ic]
+		}
+		s.m.RUnlock()
+		if intType == nil {
+			panic(fmt.Errorf("found unregistered type index %d", ic))
+		}
+	default:
+		panic(fmt.Errorf("unexpected type code: expected %v, found %v", namedInterfaceCode, code))
+	}
 
 	val := reflect.New(intType)
 
-	s.readValue(r, val)
+	// the value is read into the addressable element, so that a nil slice
+	// or map stored in the interface can be set
+	s.readValue(r, val.Elem())
 
 	if pointer {
 		v.Set(val)
@@ -381,8 +1193,13 @@ func (s *Serializer) readInterface(r io.Reader, v reflect.Value) {
 }
 
 func (s *Serializer) readMap(r io.Reader, v reflect.Value) {
+	if peekTypeCode(r) == invalidCode {
+		readTypeCode(r)
+		v.SetZero()
+		return
+	}
 	expect(r, mapCode)
-	l := int(s.readInt32(r))
+	l := s.checkLen(s.readInt32(r))
 
 	keyType := v.Type().Key()
 	valType := v.Type().Elem()
@@ -400,19 +1217,59 @@ func (s *Serializer) readMap(r io.Reader, v reflect.Value) {
 }
 
 func (s *Serializer) readSlice(r io.Reader, v reflect.Value) {
+	if peekTypeCode(r) == invalidCode {
+		readTypeCode(r)
+		v.SetZero()
+		return
+	}
+	// byte slices written element by element are still readable
+	if isBytes(v.Type()) && peekTypeCode(r) == bytesCode {
+		s.readBytes(r, v)
+		return
+	}
 	expect(r, arrayCode)
-	l := int(s.readInt32(r))
-
-	slice := reflect.MakeSlice(v.Type(), l, l)
+	l := s.checkLen(s.readInt32(r))
+
+	var slice reflect.Value
+	if !v.IsNil() && v.Cap() >= l {
+		// reuse the backing array, the elements are cleared because reading
+		// a pointer would otherwise overwrite the data it points to
+		slice = v.Slice(0, l)
+		for i := 0; i < l; i++ {
+			slice.Index(i).SetZero()
+		}
+	} else {
+		slice = reflect.MakeSlice(v.Type(), l, l)
+	}
 	for i := 0; i < l; i++ {
 		s.readValue(r, slice.Index(i))
 	}
 	v.Set(slice)
 }
 
+func (s *Serializer) readBytes(r io.Reader, v reflect.Value) {
+	expect(r, bytesCode)
+	l := s.checkLen(s.readInt32(r))
+
+	var b []byte
+	if !v.IsNil() && v.Cap() >= l {
+		b = v.Slice(0, l).Bytes()
+	} else {
+		b = make([]byte, l)
+	}
+	_, err := io.ReadFull(r, b)
+	if err != nil {
+		panic(fmt.Errorf("could not read bytes: %w", err))
+	}
+	v.SetBytes(b)
+}
+
 func (s *Serializer) readArray(r io.Reader, v reflect.Value) {
 	expect(r, arrayCode)
 	l := int(s.readInt32(r))
+	if l != v.Len() {
+		panic(fmt.Errorf("array length mismatch: expected %d, found %d", v.Len(), l))
+	}
 
 	for i := 0; i < l; i++ {
 		s.readValue(r, v.Index(i))
@@ -421,7 +1278,7 @@ func (s *Serializer) readArray(r io.Reader, v reflect.Value) {
 
 func (s *Serializer) readBool(r io.Reader, v reflect.Value) {
 	expect(r, boolCode)
-	buf := make([]byte, 1)
+	buf := readBuffer(r, 1)
 	_, err := io.ReadFull(r, buf)
 	if err != nil {
 		panic(err)
@@ -436,13 +1293,7 @@ func (s *Serializer) readSizedInt(r io.Reader, code typeCode) uint64 {
 }
 
 func (s *Serializer) readInt(r io.Reader, c32 typeCode, c64 typeCode) uint64 {
-	buf := make([]byte, 1)
-	_, err := io.ReadFull(r, buf)
-	if err != nil {
-		panic(err)
-	}
-
-	switch typeCode(buf[0]) {
+	switch readTypeCode(r) {
 	case c32:
 		return s.readRawInt(r, 4)
 	case c64:
@@ -453,7 +1304,7 @@ func (s *Serializer) readInt(r io.Reader, c32 typeCode, c64 typeCode) uint64 {
 }
 
 func (s *Serializer) readRawInt(r io.Reader, l int) uint64 {
-	buf := make([]byte, l)
+	buf := readBuffer(r, l)
 	_, err := io.ReadFull(r, buf)
 	if err != nil {
 		panic(err)
@@ -483,20 +1334,114 @@ func getIntLen(code typeCode) int {
 }
 
 func (s *Serializer) readStruct(r io.Reader, v reflect.Value) {
+	if peekTypeCode(r) == namedStructCode {
+		s.readNamedStruct(r, v)
+		return
+	}
 	expect(r, structCode)
 	t := v.Type()
 	for i := 0; i < v.NumField(); i++ {
 		field := v.Field(i)
-		if t.Field(i).IsExported() {
+		f := t.Field(i)
+		if isSerialized(f) {
+			if err := checkFieldKind(t, f); err != nil {
+				panic(err)
+			}
 			s.readValue(r, field)
 		}
 	}
 }
 
+// readNamedStruct reads a struct written with field names. Fields not found
+// in the stream are set to zero, unknown fields are skipped.
+func (s *Serializer) readNamedStruct(r io.Reader, v reflect.Value) {
+	expect(r, namedStructCode)
+	n := s.checkLen(s.readInt32(r))
+	v.SetZero()
+	t := v.Type()
+	for i := 0; i < n; i++ {
+		name := s.readName(r)
+		f, ok := t.FieldByName(name)
+		if ok && len(f.Index) == 1 && isSerialized(f) {
+			if err := checkFieldKind(t, f); err != nil {
+				panic(err)
+			}
+			s.readValue(r, v.Field(f.Index[0]))
+		} else {
+			s.skipValue(r)
+		}
+	}
+}
+
+// readName reads a length prefixed string without type code.
+func (s *Serializer) readName(r io.Reader) string {
+	buf := readBuffer(r, s.checkLen(s.readInt32(r)))
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		panic(fmt.Errorf("could not read name: %w", err))
+	}
+	return string(buf)
+}
+
+// skipValue reads a value without storing it. Structs written without field
+// names can not be skipped, because the number of fields is not known.
+func (s *Serializer) skipValue(r io.Reader) {
+	code := readTypeCode(r)
+	switch code {
+	case invalidCode:
+	case boolCode:
+		s.skipBytes(r, 1)
+	case int8Code, uint8Code, int16Code, uint16Code, int32Code, uint32Code, int64Code, uint64Code:
+		s.skipBytes(r, getIntLen(code))
+	case float32Code:
+		s.skipBytes(r, 4)
+	case float64Code, complex64Code:
+		s.skipBytes(r, 8)
+	case complex128Code:
+		s.skipBytes(r, 16)
+	case stringCode, bytesCode:
+		s.skipBytes(r, s.checkLen(s.readInt32(r)))
+	case arrayCode:
+		n := s.checkLen(s.readInt32(r))
+		for i := 0; i < n; i++ {
+			s.skipValue(r)
+		}
+	case mapCode:
+		n := s.checkLen(s.readInt32(r))
+		for i := 0; i < n; i++ {
+			s.skipValue(r)
+			s.skipValue(r)
+		}
+	case namedStructCode:
+		n := s.checkLen(s.readInt32(r))
+		for i := 0; i < n; i++ {
+			s.readName(r)
+			s.skipValue(r)
+		}
+	case namedInterfaceCode:
+		s.skipBytes(r, s.checkLen(s.readInt32(r)&(pointerMask-1)))
+		s.skipValue(r)
+	case interfaceCode:
+		s.readInt32(r)
+		s.skipValue(r)
+	case pointerCode:
+		s.skipValue(r)
+	default:
+		panic(fmt.Errorf("can not skip value with type code %v", code))
+	}
+}
+
+func (s *Serializer) skipBytes(r io.Reader, n int) {
+	_, err := io.CopyN(io.Discard, r, int64(n))
+	if err != nil {
+		panic(fmt.Errorf("could not skip data: %w", err))
+	}
+}
+
 func (s *Serializer) readString(r io.Reader, v reflect.Value) {
 	expect(r, stringCode)
-	strLen := s.readInt32(r)
-	buf := make([]byte, strLen)
+	strLen := s.checkLen(s.readInt32(r))
+	buf := readBuffer(r, strLen)
 	_, err := io.ReadFull(r, buf)
 	if err != nil {
 		panic(fmt.Errorf("could not read string data: %w", err))
@@ -504,6 +1449,15 @@ func (s *Serializer) readString(r io.Reader, v reflect.Value) {
 	v.SetString(string(buf))
 }
 
+// checkLen panics if the length read from the stream exceeds the maximum
+// length.
+func (s *Serializer) checkLen(l uint32) int {
+	if uint64(l) > uint64(s.maxAlloc) {
+		panic(fmt.Errorf("length %d exceeds maximum of %d, data corrupt?", l, s.maxAlloc))
+	}
+	return int(l)
+}
+
 func (s *Serializer) readFloat32(r io.Reader, v reflect.Value) {
 	expect(r, float32Code)
 	floatBits := s.readInt32(r)
@@ -516,8 +1470,22 @@ func (s *Serializer) readFloat64(r io.Reader, v reflect.Value) {
 	v.SetFloat(math.Float64frombits(floatBits))
 }
 
+func (s *Serializer) readComplex64(r io.Reader, v reflect.Value) {
+	expect(r, complex64Code)
+	re := math.Float32frombits(s.readInt32(r))
+	im := math.Float32frombits(s.readInt32(r))
+	v.SetComplex(complex128(complex(re, im)))
+}
+
+func (s *Serializer) readComplex128(r io.Reader, v reflect.Value) {
+	expect(r, complex128Code)
+	re := math.Float64frombits(s.readInt64(r))
+	im := math.Float64frombits(s.readInt64(r))
+	v.SetComplex(complex(re, im))
+}
+
 func (s *Serializer) readInt32(r io.Reader) uint32 {
-	buf := make([]byte, 4)
+	buf := readBuffer(r, 4)
 	_, err := io.ReadFull(r, buf)
 	if err != nil {
 		panic(fmt.Errorf("could not read int32: %w", err))
@@ -526,7 +1494,7 @@ func (s *Serializer) readInt32(r io.Reader) uint32 {
 }
 
 func (s *Serializer) readInt64(r io.Reader) uint64 {
-	buf := make([]byte, 8)
+	buf := readBuffer(r, 8)
 	_, err := io.ReadFull(r, buf)
 	if err != nil {
 		panic(fmt.Errorf("could not read int64: %w", err))
@@ -553,13 +1521,44 @@ func (s *Serializer) binUnmarshal(r io.Reader, v reflect.Value) {
 	}
 }
 
+func (s *Serializer) codecDecode(r io.Reader, v reflect.Value, c codec) {
+	var b []byte
+	s.readSlice(r, reflect.ValueOf(&b).Elem())
+
+	d, err := c.decode(b)
+	if err != nil {
+		panic(fmt.Errorf("error calling codec of %v: %w", v.Type(), err))
+	}
+	dv := reflect.ValueOf(d)
+	if !dv.IsValid() || dv.Type() != v.Type() {
+		panic(fmt.Errorf("codec of %v returned a value of type %T", v.Type(), d))
+	}
+	v.Set(dv)
+}
+
+func (s *Serializer) textUnmarshal(r io.Reader, v reflect.Value) {
+	var str string
+	s.readString(r, reflect.ValueOf(&str).Elem())
+
+	method := v.Addr().MethodByName("UnmarshalText")
+	res := method.Call([]reflect.Value{reflect.ValueOf([]byte(str))})
+	if !(res[0].IsNil()) {
+		panic(fmt.Errorf("error calling UnmarshalText on %v: %v", v.Type(), res[0]))
+	}
+}
+
 func expect(r io.Reader, code typeCode) {
-	buf := []byte{0}
+	c := readTypeCode(r)
+	if c != code {
+		panic(fmt.Errorf("unexpected type code: expected %v, found %v", code, c))
+	}
+}
+
+func readTypeCode(r io.Reader) typeCode {
+	buf := readBuffer(r, 1)
 	_, err := io.ReadFull(r, buf)
 	if err != nil {
 		panic(fmt.Errorf("could not read type code: %w", err))
 	}
-	if buf[0] != byte(code) {
-		panic(fmt.Errorf("unexpected type code: expected %v, found %v", code, buf[0]))
-	}
+	return typeCode(buf[0])
 }
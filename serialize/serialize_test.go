@@ -2,9 +2,15 @@ package serialize
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"math"
+	"math/cmplx"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestStringWrite(t *testing.T) {
@@ -155,6 +161,59 @@ func TestRWSlice(t *testing.T) {
 	assert.EqualValues(t, in, out)
 }
 
+func TestRWNilSliceMap(t *testing.T) {
+	type S struct {
+		NS []int
+		ES []int
+		NM map[string]int
+		EM map[string]int
+		NB []byte
+		EB []byte
+	}
+
+	w := S{ES: []int{}, EM: map[string]int{}, EB: []byte{}}
+
+	ser := New()
+	var b bytes.Buffer
+	assert.NoError(t, ser.Write(&b, &w))
+
+	r := S{NS: []int{1}, NM: map[string]int{"a": 1}, NB: []byte{1}}
+	assert.NoError(t, ser.Read(&b, &r))
+	assert.Nil(t, r.NS)
+	assert.Nil(t, r.NM)
+	assert.Nil(t, r.NB)
+	assert.NotNil(t, r.ES)
+	assert.NotNil(t, r.EM)
+	assert.NotNil(t, r.EB)
+	assert.EqualValues(t, w, r)
+
+	// nil values can be skipped
+	type empty struct{}
+	b.Reset()
+	assert.NoError(t, ser.FieldNames().Write(&b, &w))
+	assert.NoError(t, ser.Read(&b, &empty{}))
+}
+
+func TestRWNilSliceMapInterface(t *testing.T) {
+	type S struct {
+		S any
+		M any
+		E any
+	}
+	w := S{S: []int(nil), M: map[string]int(nil), E: []int{}}
+
+	ser := New().Register([]int{}).Register(map[string]int{})
+	var b bytes.Buffer
+	assert.NoError(t, ser.Write(&b, &w))
+
+	var r S
+	assert.NoError(t, ser.Read(&b, &r))
+	assert.EqualValues(t, w, r)
+	assert.Nil(t, r.S.([]int))
+	assert.Nil(t, r.M.(map[string]int))
+	assert.NotNil(t, r.E.([]int))
+}
+
 func TestRWSlicePointer(t *testing.T) {
 	var b bytes.Buffer
 
@@ -178,6 +237,59 @@ func TestRWSlicePointer(t *testing.T) {
 	assert.EqualValues(t, in, out)
 }
 
+func TestReadReuseSlice(t *testing.T) {
+	type st struct {
+		A int
+		B []int
+	}
+
+	in := []*st{{A: 1, B: []int{}}, {A: 2, B: []int{3}}}
+	var b bytes.Buffer
+	ser := New()
+	assert.NoError(t, ser.Write(&b, &in))
+
+	old := &st{A: 7, B: []int{8}}
+	out := make([]*st, 5, 10)
+	out[0] = old
+	backing := &out[:1][0]
+	assert.NoError(t, ser.Read(&b, &out))
+	assert.EqualValues(t, in, out)
+	assert.EqualValues(t, 2, len(out))
+	assert.True(t, backing == &out[0])
+	// the data the reused element pointed to is not modified
+	assert.EqualValues(t, &st{A: 7, B: []int{8}}, old)
+
+	// a slice which is too small is replaced
+	b.Reset()
+	assert.NoError(t, ser.Write(&b, &in))
+	small := make([]*st, 0, 1)
+	assert.NoError(t, ser.Read(&b, &small))
+	assert.EqualValues(t, in, small)
+}
+
+func BenchmarkReadReuseSlice(b *testing.B) {
+	in := make([]int, 1000)
+	var buf bytes.Buffer
+	ser := New()
+	assert.NoError(b, ser.Write(&buf, in))
+	data := buf.Bytes()
+
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var out []int
+			assert.NoError(b, ser.Read(bytes.NewReader(data), &out))
+		}
+	})
+	b.Run("reuse", func(b *testing.B) {
+		b.ReportAllocs()
+		var out []int
+		for i := 0; i < b.N; i++ {
+			assert.NoError(b, ser.Read(bytes.NewReader(data), &out))
+		}
+	})
+}
+
 func TestRWArray(t *testing.T) {
 	var b bytes.Buffer
 
@@ -306,3 +418,784 @@ func TestRWInt(t *testing.T) {
 
 	assert.EqualValues(t, w, r)
 }
+
+func TestRWComplex(t *testing.T) {
+	type S struct {
+		A complex64
+		B complex128
+		C []complex128
+	}
+
+	w := S{
+		A: complex(float32(math.Pi), -1.5),
+		B: complex(math.E, math.Pi),
+		C: []complex128{cmplx.Inf(), complex(math.Inf(-1), 0), complex(0, math.MaxFloat64), 0},
+	}
+
+	ser := New()
+	var b bytes.Buffer
+	assert.NoError(t, ser.Write(&b, &w))
+
+	var r S
+	assert.NoError(t, ser.Read(&b, &r))
+	assert.EqualValues(t, w, r)
+
+	b.Reset()
+	assert.NoError(t, ser.Write(&b, []complex64{complex64(cmplx.NaN()), 1i}))
+	var n []complex64
+	assert.NoError(t, ser.Read(&b, &n))
+	assert.Len(t, n, 2)
+	assert.True(t, cmplx.IsNaN(complex128(n[0])))
+	assert.EqualValues(t, 1i, n[1])
+}
+
+func TestRWComplexSkip(t *testing.T) {
+	type Old struct {
+		A complex64
+		B complex128
+		N int
+	}
+	type Current struct {
+		N int
+	}
+
+	ser := New().FieldNames()
+	var b bytes.Buffer
+	assert.NoError(t, ser.Write(&b, &Old{A: 1 + 2i, B: 3 + 4i, N: 5}))
+
+	var r Current
+	assert.NoError(t, ser.Read(&b, &r))
+	assert.EqualValues(t, Current{N: 5}, r)
+}
+
+func TestUnsupportedFieldKind(t *testing.T) {
+	type withChan struct {
+		N int
+		C chan int
+	}
+	type withFunc struct {
+		F func()
+	}
+	type withUintptr struct {
+		P uintptr
+	}
+
+	for _, ser := range []*Serializer{New(), New().FieldNames()} {
+		var b bytes.Buffer
+		err := ser.Write(&b, &withChan{})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "field C of serialize.withChan has unsupported kind chan")
+
+		err = ser.Write(&b, &withFunc{})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "field F of serialize.withFunc has unsupported kind func")
+
+		err = ser.Write(&b, &withUintptr{})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "field P of serialize.withUintptr has unsupported kind uintptr")
+	}
+
+	// ignored fields are allowed
+	type ignored struct {
+		N int
+		C chan int `serialize:"-"`
+		f func()
+	}
+	ser := New()
+	var b bytes.Buffer
+	assert.NoError(t, ser.Write(&b, &ignored{N: 1}))
+	var r ignored
+	assert.NoError(t, ser.Read(&b, &r))
+	assert.EqualValues(t, 1, r.N)
+
+	b.Reset()
+	err := ser.Write(&b, uintptr(1))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported kind uintptr")
+}
+
+type Color uint8
+
+type Level int
+
+type Name string
+
+type Flag bool
+
+type Ratio float32
+
+func TestRWNamedTypes(t *testing.T) {
+	var b bytes.Buffer
+
+	type S struct {
+		C Color
+		L Level
+		N Name
+		F Flag
+		R Ratio
+		D time.Duration
+	}
+
+	w := S{
+		C: 200,
+		L: -42,
+		N: "named",
+		F: true,
+		R: 0.5,
+		D: 90 * time.Minute,
+	}
+
+	ser := New()
+	err := ser.Write(&b, &w)
+	assert.NoError(t, err)
+
+	var r S
+	err = ser.Read(&b, &r)
+	assert.NoError(t, err)
+
+	assert.EqualValues(t, w, r)
+}
+
+func TestRWNamedTypesSlice(t *testing.T) {
+	var b bytes.Buffer
+
+	in := []Color{1, 2, 255}
+
+	ser := New()
+	err := ser.Write(&b, &in)
+	assert.NoError(t, err)
+	assert.EqualValues(t, []byte{0xe, 0x3, 0x0, 0x0, 0x0, 0x6, 0x1, 0x6, 0x2, 0x6, 0xff}, b.Bytes())
+
+	var out []Color
+	err = ser.Read(&b, &out)
+	assert.NoError(t, err)
+
+	assert.EqualValues(t, in, out)
+}
+
+func TestMapSorted(t *testing.T) {
+	var a = map[string]string{
+		"b": "B",
+		"a": "A",
+		"c": "C",
+	}
+	ser := New().SortMapKeys()
+	var first []byte
+	for i := 0; i < 10; i++ {
+		var w bytes.Buffer
+		err := ser.Write(&w, &a)
+		assert.NoError(t, err)
+		if first == nil {
+			first = w.Bytes()
+		} else {
+			assert.EqualValues(t, first, w.Bytes())
+		}
+	}
+	assert.EqualValues(t, []byte{0xf, 0x3, 0x0, 0x0, 0x0,
+		0xc, 0x1, 0x0, 0x0, 0x0, 0x61, 0xc, 0x1, 0x0, 0x0, 0x0, 0x41,
+		0xc, 0x1, 0x0, 0x0, 0x0, 0x62, 0xc, 0x1, 0x0, 0x0, 0x0, 0x42,
+		0xc, 0x1, 0x0, 0x0, 0x0, 0x63, 0xc, 0x1, 0x0, 0x0, 0x0, 0x43}, first)
+}
+
+func TestMapIntSorted(t *testing.T) {
+	var w bytes.Buffer
+	var a = map[int16]int16{
+		2: 32,
+		1: 16,
+	}
+	err := New().SortMapKeys().Write(&w, &a)
+	assert.NoError(t, err)
+	assert.EqualValues(t, []byte{0xf, 0x2, 0x0, 0x0, 0x0, 0x3, 0x1, 0x0, 0x3, 0x10, 0x0, 0x3, 0x2, 0x0, 0x3, 0x20, 0x0}, w.Bytes())
+}
+
+func TestRWStructSkipTag(t *testing.T) {
+	var b bytes.Buffer
+
+	type st struct {
+		A int
+		B string `serialize:"-"`
+		C int
+		D []int `serialize:"-"`
+	}
+
+	in := st{A: 1, B: "cache", C: 3, D: []int{1, 2}}
+
+	ser := New()
+	err := ser.Write(&b, &in)
+	assert.NoError(t, err)
+
+	var out st
+	err = ser.Read(&b, &out)
+	assert.NoError(t, err)
+
+	assert.EqualValues(t, st{A: 1, C: 3}, out)
+}
+
+type node struct {
+	V    int
+	Next *node
+}
+
+func TestCycle(t *testing.T) {
+	var b bytes.Buffer
+
+	n1 := &node{V: 1}
+	n2 := &node{V: 2, Next: n1}
+	n1.Next = n2
+
+	err := New().Write(&b, n1)
+	assert.ErrorIs(t, err, ErrPointerDepth)
+}
+
+func TestPointerDepth(t *testing.T) {
+	var list *node
+	for i := 0; i < 10; i++ {
+		list = &node{V: i, Next: list}
+	}
+
+	var b bytes.Buffer
+	err := New().MaxPointerDepth(5).Write(&b, list)
+	assert.ErrorIs(t, err, ErrPointerDepth)
+
+	b.Reset()
+	err = New().MaxPointerDepth(10).Write(&b, list)
+	assert.NoError(t, err)
+}
+
+func TestReadSeq(t *testing.T) {
+	var b bytes.Buffer
+
+	type st struct {
+		A int
+		B string
+	}
+
+	var in []*st
+	for i := 0; i < 1000; i++ {
+		in = append(in, &st{A: i, B: "Hello"})
+	}
+
+	ser := New()
+	err := ser.Write(&b, &in)
+	assert.NoError(t, err)
+
+	i := 0
+	for e, err := range ReadSeqOf[*st](ser, &b) {
+		assert.NoError(t, err)
+		assert.EqualValues(t, in[i], e)
+		i++
+	}
+	assert.EqualValues(t, len(in), i)
+}
+
+func TestReadSeqTruncated(t *testing.T) {
+	var b bytes.Buffer
+
+	in := []int32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	ser := New()
+	err := ser.Write(&b, &in)
+	assert.NoError(t, err)
+
+	data := b.Bytes()
+	data = data[:len(data)-12]
+
+	var read []int32
+	var lastErr error
+	for v, err := range ser.ReadSeq(bytes.NewReader(data), reflect.TypeFor[int32]()) {
+		if err != nil {
+			lastErr = err
+			break
+		}
+		read = append(read, *v.(*int32))
+	}
+	assert.Error(t, lastErr)
+	assert.EqualValues(t, []int32{1, 2, 3, 4, 5, 6, 7}, read)
+}
+
+func TestStrictFields(t *testing.T) {
+	type st struct {
+		A int
+		b int
+	}
+	in := st{A: 1, b: 2}
+
+	var b bytes.Buffer
+	ser := New()
+	err := ser.Write(&b, &in)
+	assert.NoError(t, err)
+	var out st
+	err = ser.Read(&b, &out)
+	assert.NoError(t, err)
+	assert.EqualValues(t, st{A: 1}, out)
+
+	b.Reset()
+	err = New().StrictFields().Write(&b, &in)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unexported field b")
+
+	type skipped struct {
+		A int
+		b int `serialize:"-"`
+	}
+	b.Reset()
+	err = New().StrictFields().Write(&b, &skipped{A: 1, b: 2})
+	assert.NoError(t, err)
+}
+
+func TestMaxAlloc(t *testing.T) {
+	var str string
+	err := New().Read(bytes.NewReader([]byte{0xc, 0xff, 0xff, 0xff, 0xff, 0x48}), &str)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds maximum")
+
+	var sl []int64
+	err = New().Read(bytes.NewReader([]byte{0xe, 0xff, 0xff, 0xff, 0xf0, 0x5}), &sl)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds maximum")
+
+	var m map[string]string
+	err = New().Read(bytes.NewReader([]byte{0xf, 0xff, 0xff, 0xff, 0xf0, 0x5}), &m)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds maximum")
+
+	var b bytes.Buffer
+	in := []int16{1, 2, 3, 4}
+	assert.NoError(t, New().Write(&b, &in))
+	err = New().MaxAlloc(3).Read(bytes.NewReader(b.Bytes()), &sl)
+	assert.Error(t, err)
+	var out []int16
+	err = New().MaxAlloc(4).Read(bytes.NewReader(b.Bytes()), &out)
+	assert.NoError(t, err)
+	assert.EqualValues(t, in, out)
+
+	var arr [3]int16
+	err = New().Read(bytes.NewReader(b.Bytes()), &arr)
+	assert.Error(t, err)
+}
+
+func TestRWPointer(t *testing.T) {
+	type st struct {
+		A *bool
+		B *bool
+		C **int
+		D **int
+		E **int
+		F *[]int
+		G *time.Time
+		H *time.Time
+	}
+
+	now := time.Now()
+	tr := true
+	i := 42
+	pi := &i
+	var nilInt *int
+	in := st{
+		A: nil,
+		B: &tr,
+		C: &pi,
+		D: &nilInt,
+		E: nil,
+		F: &[]int{1, 2},
+		G: nil,
+		H: &now,
+	}
+
+	ser := New()
+	var b bytes.Buffer
+	err := ser.Write(&b, &in)
+	assert.NoError(t, err)
+
+	var out st
+	err = ser.Read(&b, &out)
+	assert.NoError(t, err)
+
+	assert.Nil(t, out.A)
+	assert.NotNil(t, out.B)
+	assert.True(t, *out.B)
+	assert.NotNil(t, out.C)
+	assert.NotNil(t, *out.C)
+	assert.EqualValues(t, 42, **out.C)
+	assert.NotNil(t, out.D)
+	assert.Nil(t, *out.D)
+	assert.Nil(t, out.E)
+	assert.EqualValues(t, []int{1, 2}, *out.F)
+	assert.Nil(t, out.G)
+	assert.True(t, now.Equal(*out.H))
+}
+
+func TestRWPointerSlice(t *testing.T) {
+	one := 1
+	in := []*int{&one, nil}
+
+	ser := New()
+	var b bytes.Buffer
+	err := ser.Write(&b, &in)
+	assert.NoError(t, err)
+	assert.EqualValues(t, []byte{0xe, 0x2, 0x0, 0x0, 0x0, 0x12, 0x5, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}, b.Bytes())
+
+	var out []*int
+	err = ser.Read(&b, &out)
+	assert.NoError(t, err)
+	assert.EqualValues(t, in, out)
+}
+
+func TestReadLegacyPointer(t *testing.T) {
+	// pointers written by older versions have no pointer code
+	data := []byte{0xe, 0x2, 0x0, 0x0, 0x0, 0x3, 0x1, 0x0, 0x3, 0x2, 0x0}
+
+	var out []*int16
+	err := New().Read(bytes.NewReader(data), &out)
+	assert.NoError(t, err)
+	one, two := int16(1), int16(2)
+	assert.EqualValues(t, []*int16{&one, &two}, out)
+}
+
+func TestFieldNames(t *testing.T) {
+	type inner struct {
+		X, Y int
+	}
+	type v1 struct {
+		A int
+		B string
+		C []inner
+	}
+	type v2 struct {
+		A int
+		B string
+		C []inner
+		D float64
+	}
+	type v0 struct {
+		B string
+	}
+
+	ser := New().FieldNames()
+	var b bytes.Buffer
+	in := []v1{{A: 1, B: "a", C: []inner{{1, 2}}}, {A: 2, B: "b"}}
+	assert.NoError(t, ser.Write(&b, in))
+	data := b.Bytes()
+
+	// a field was added
+	var added []v2
+	assert.NoError(t, ser.Read(bytes.NewReader(data), &added))
+	assert.EqualValues(t, []v2{{A: 1, B: "a", C: []inner{{1, 2}}}, {A: 2, B: "b"}}, added)
+
+	// fields were removed
+	var removed []v0
+	assert.NoError(t, ser.Read(bytes.NewReader(data), &removed))
+	assert.EqualValues(t, []v0{{B: "a"}, {B: "b"}}, removed)
+
+	// the reading serializer does not need the option
+	var same []v1
+	assert.NoError(t, New().Read(bytes.NewReader(data), &same))
+	assert.EqualValues(t, []v1{{A: 1, B: "a", C: []inner{{1, 2}}}, {A: 2, B: "b"}}, same)
+
+	// data written without field names is still readable
+	b.Reset()
+	assert.NoError(t, New().Write(&b, in))
+	same = nil
+	assert.NoError(t, ser.Read(&b, &same))
+	assert.EqualValues(t, []v1{{A: 1, B: "a", C: []inner{{1, 2}}}, {A: 2, B: "b"}}, same)
+}
+
+func TestFieldNamesSkip(t *testing.T) {
+	type full struct {
+		A   int
+		M   map[string][]int8
+		P   *float32
+		I   any
+		T   time.Time
+		S   struct{ X bool }
+		Arr [2]uint16
+		N   int
+	}
+	type small struct {
+		N int
+	}
+
+	ser := New().FieldNames().Register(Point{})
+	f := float32(1.5)
+	var b bytes.Buffer
+	in := full{A: 1, M: map[string][]int8{"a": {1}}, P: &f, I: &Point{X: 1}, T: time.Now(), Arr: [2]uint16{1, 2}, N: 7}
+	assert.NoError(t, ser.Write(&b, in))
+
+	var out small
+	assert.NoError(t, ser.Read(&b, &out))
+	assert.EqualValues(t, 7, out.N)
+}
+
+func BenchmarkRoundTrip(b *testing.B) {
+	type item struct {
+		N int
+		F float64
+		S string
+	}
+	in := make([]item, 10000)
+	for i := range in {
+		in[i] = item{N: i, F: float64(i) / 2, S: "item"}
+	}
+	ser := New()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		assert.NoError(b, ser.Write(&buf, in))
+		var out []item
+		assert.NoError(b, ser.Read(&buf, &out))
+	}
+}
+
+func TestFramed(t *testing.T) {
+	type rec struct {
+		N int
+		S string
+	}
+	s := New()
+	var w bytes.Buffer
+	assert.NoError(t, s.WriteFramed(&w, &rec{N: 1, S: "a"}))
+	assert.NoError(t, s.WriteFramed(&w, &rec{N: 2, S: "b"}))
+	// records can be appended
+	data := append([]byte{}, w.Bytes()...)
+	w.Reset()
+	assert.NoError(t, s.WriteFramed(&w, &rec{N: 3, S: "c"}))
+	data = append(data, w.Bytes()...)
+
+	r := bytes.NewReader(data)
+	for i := 1; i <= 3; i++ {
+		var e rec
+		assert.NoError(t, s.ReadFramed(r, &e))
+		assert.EqualValues(t, rec{N: i, S: string(rune('a' + i - 1))}, e)
+	}
+	var e rec
+	assert.Equal(t, io.EOF, s.ReadFramed(r, &e))
+
+	// a record of a different type is skipped completely
+	r = bytes.NewReader(data)
+	var str string
+	assert.Error(t, s.ReadFramed(r, &str))
+	assert.NoError(t, s.ReadFramed(r, &e))
+	assert.EqualValues(t, 2, e.N)
+
+	// truncated record
+	r = bytes.NewReader(data[:len(data)-1])
+	assert.NoError(t, s.ReadFramed(r, &e))
+	assert.NoError(t, s.ReadFramed(r, &e))
+	err := s.ReadFramed(r, &e)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestFramedLimit(t *testing.T) {
+	items := []string{"abcd", "efgh", "ijkl", "mnop"}
+
+	// the record is larger than MaxAlloc, which limits only the single values
+	s := New().MaxAlloc(8)
+	var w bytes.Buffer
+	assert.NoError(t, s.WriteFramed(&w, items))
+	var read []string
+	assert.NoError(t, s.ReadFramed(bytes.NewReader(w.Bytes()), &read))
+	assert.EqualValues(t, items, read)
+
+	small := New().MaxFrame(16)
+	assert.Error(t, small.WriteFramed(&bytes.Buffer{}, items))
+	err := small.ReadFramed(bytes.NewReader(w.Bytes()), &read)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds maximum")
+}
+
+func TestReadStrict(t *testing.T) {
+	type rec struct {
+		N int
+		S string
+	}
+	s := New()
+	var w bytes.Buffer
+	assert.NoError(t, s.Write(&w, &rec{N: 1, S: "a"}))
+
+	var r rec
+	assert.NoError(t, s.ReadStrict(bytes.NewReader(w.Bytes()), &r))
+	assert.EqualValues(t, rec{N: 1, S: "a"}, r)
+
+	data := append(w.Bytes(), 1, 2, 3)
+	assert.NoError(t, s.Read(bytes.NewReader(data), &r))
+	assert.ErrorIs(t, s.ReadStrict(bytes.NewReader(data), &r), ErrTrailingData)
+}
+
+func TestReadInto(t *testing.T) {
+	type rec struct {
+		N int
+		S string
+	}
+	s := New()
+	var w bytes.Buffer
+	recs := []rec{{N: 1, S: "a"}, {N: 2, S: "b"}}
+	assert.NoError(t, s.Write(&w, recs))
+	assert.NoError(t, s.Write(&w, rec{N: 3, S: "c"}))
+	assert.NoError(t, s.Write(&w, &rec{N: 4, S: "d"}))
+
+	v, err := s.ReadInto(&w, []rec{})
+	assert.NoError(t, err)
+	assert.EqualValues(t, recs, v)
+
+	sample := rec{N: 5}
+	v, err = s.ReadInto(&w, sample)
+	assert.NoError(t, err)
+	assert.EqualValues(t, rec{N: 3, S: "c"}, v)
+	assert.EqualValues(t, rec{N: 5}, sample)
+
+	v, err = s.ReadInto(&w, (*rec)(nil))
+	assert.NoError(t, err)
+	assert.EqualValues(t, &rec{N: 4, S: "d"}, v)
+
+	_, err = s.ReadInto(&w, rec{})
+	assert.Error(t, err)
+	_, err = s.ReadInto(&w, nil)
+	assert.Error(t, err)
+}
+
+func TestSizeOf(t *testing.T) {
+	type rec struct {
+		N int
+		S string
+		M map[string][]float64
+		P *int
+	}
+	i := 5
+	for _, ser := range []*Serializer{New(), New().FieldNames()} {
+		for _, v := range []any{
+			&rec{N: 1, S: "hello", M: map[string][]float64{"a": {1, 2}}, P: &i},
+			rec{},
+			"text",
+			[]int{1, 2, 3},
+		} {
+			var w bytes.Buffer
+			assert.NoError(t, ser.Write(&w, v))
+			n, err := ser.SizeOf(v)
+			assert.NoError(t, err)
+			assert.EqualValues(t, w.Len(), n)
+		}
+	}
+
+	_, err := New().SizeOf(struct{ C chan int }{})
+	assert.Error(t, err)
+}
+
+func TestCompressed(t *testing.T) {
+	type rec struct {
+		N int
+		S string
+	}
+	w := make([]rec, 1000)
+	for i := range w {
+		w[i] = rec{N: i % 3, S: "repetitive"}
+	}
+
+	s := New()
+	var plain, compressed bytes.Buffer
+	assert.NoError(t, s.Write(&plain, &w))
+	assert.NoError(t, s.WriteCompressed(&compressed, &w))
+	assert.True(t, compressed.Len() < plain.Len()/10)
+
+	var r []rec
+	assert.NoError(t, s.ReadCompressed(&compressed, &r))
+	assert.EqualValues(t, w, r)
+
+	assert.Error(t, s.ReadCompressed(&plain, &r))
+
+	// a corrupt checksum in the gzip trailer is detected
+	compressed.Reset()
+	assert.NoError(t, s.WriteCompressed(&compressed, &w))
+	data := compressed.Bytes()
+	data[len(data)-8] ^= 0xff
+	assert.ErrorIs(t, s.ReadCompressed(bytes.NewReader(data), &r), gzip.ErrChecksum)
+}
+
+func TestRWBytes(t *testing.T) {
+	type st struct {
+		B   []byte
+		Raw json.RawMessage
+		E   []byte
+		N   []byte
+	}
+	w := st{
+		B:   make([]byte, 1000),
+		Raw: json.RawMessage(`{"a":1}`),
+		E:   []byte{},
+	}
+	for i := range w.B {
+		w.B[i] = byte(i)
+	}
+
+	s := New()
+	var b bytes.Buffer
+	assert.NoError(t, s.Write(&b, &w.B))
+	assert.EqualValues(t, 1005, b.Len())
+
+	b.Reset()
+	assert.NoError(t, s.Write(&b, &w))
+	var r st
+	assert.NoError(t, s.Read(&b, &r))
+	assert.EqualValues(t, w, r)
+	assert.NotNil(t, r.E)
+	assert.Nil(t, r.N)
+
+	// byte slices written element by element can still be read
+	legacy := []byte{byte(arrayCode), 2, 0, 0, 0, byte(uint8Code), 7, byte(uint8Code), 8}
+	var lb []byte
+	assert.NoError(t, s.Read(bytes.NewReader(legacy), &lb))
+	assert.EqualValues(t, []byte{7, 8}, lb)
+
+	// and can still be written
+	b.Reset()
+	assert.NoError(t, New().LegacyBytes().Write(&b, &[]byte{7, 8}))
+	assert.EqualValues(t, legacy, b.Bytes())
+}
+
+func TestRWBytesSkip(t *testing.T) {
+	type full struct {
+		B []byte
+		N int
+	}
+	type reduced struct {
+		N int
+	}
+	s := New().FieldNames()
+	var b bytes.Buffer
+	assert.NoError(t, s.Write(&b, &full{B: []byte("data"), N: 3}))
+	var r reduced
+	assert.NoError(t, s.Read(&b, &r))
+	assert.EqualValues(t, 3, r.N)
+}
+
+func BenchmarkBytes(b *testing.B) {
+	const size = 1 << 20
+	block := make([]byte, size)
+	elements := make([]Color, size)
+	for i := range block {
+		block[i] = byte(i)
+		elements[i] = Color(i)
+	}
+	ser := New()
+
+	// a slice of a named byte type is written element by element
+	for _, bm := range []struct {
+		name string
+		in   any
+		out  func() any
+	}{
+		{"block", &block, func() any { return new([]byte) }},
+		{"elements", &elements, func() any { return new([]Color) }},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(size)
+			var buf bytes.Buffer
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				assert.NoError(b, ser.Write(&buf, bm.in))
+				assert.NoError(b, ser.Read(bytes.NewReader(buf.Bytes()), bm.out()))
+			}
+			b.ReportMetric(float64(buf.Len()), "encoded-bytes")
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package objectDB
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats contains some statistics of a table
+type Stats struct {
+	// Size is the number of elements in the table
+	Size int
+	// Version is the current version of the table
+	Version int
+	// Files is the number of distinct files the elements are stored in
+	Files int
+	// WriteDelay is true if the write delay is active
+	WriteDelay bool
+	// DelaySeconds is the write delay in seconds
+	DelaySeconds int
+	// PendingWrites is the number of files with pending delayed writes
+	PendingWrites int
+}
+
+// Stats returns the statistics of the table.
+func (t *Table[E]) Stats() Stats {
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	s := Stats{
+		Size:    len(t.data),
+		Version: t.Version(),
+	}
+
+	if t.nameProvider != nil {
+		files := map[string]struct{}{}
+		for _, en := range t.data {
+			files[t.nameProvider.ToFile(en)] = struct{}{}
+		}
+		s.Files = len(files)
+	}
+
+	if t.delayedWrite != nil {
+		s.WriteDelay = true
+		s.DelaySeconds = int(t.delayedWrite.delay() / time.Second)
+		s.PendingWrites = t.delayedWrite.pending()
+	}
+	return s
+}
+
+// LockOp is the kind of operation which acquired the lock of a table.
+type LockOp string
+
+const (
+	// LockRead is used by all operations which only read the table
+	LockRead LockOp = "read"
+	// LockInsert is used by inserts
+	LockInsert LockOp = "insert"
+	// LockUpdate is used by updates and moves
+	LockUpdate LockOp = "update"
+	// LockDelete is used by deletions
+	LockDelete LockOp = "delete"
+	// LockReplace is used by operations which replace many elements at once
+	LockReplace LockOp = "replace"
+	// LockWrite is used by the delayed and batched writing of files
+	LockWrite LockOp = "write"
+	// LockConfig is used by operations which change the configuration of the
+	// table, like the hooks, the subscriptions, the batch or the WAL
+	LockConfig LockOp = "config"
+)
+
+// LockStat contains the lock statistics of a kind of operation.
+type LockStat struct {
+	// Count is the number of times the lock was acquired
+	Count int
+	// Contended is the number of times the lock was held by another
+	// operation, so that it was necessary to wait
+	Contended int
+	// Wait is the total time spent waiting for the lock
+	Wait time.Duration
+}
+
+type lockStats struct {
+	m     sync.Mutex
+	stats map[LockOp]LockStat
+}
+
+func (l *lockStats) add(op LockOp, contended bool, wait time.Duration) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	s := l.stats[op]
+	s.Count++
+	if contended {
+		s.Contended++
+		s.Wait += wait
+	}
+	l.stats[op] = s
+}
+
+// lock acquires the lock of the table. If the lock statistics are enabled,
+// the acquisition is recorded for the given kind of operation.
+func (t *Table[E]) lock(op LockOp) {
+	if t.lockStats == nil {
+		t.m.Lock()
+		return
+	}
+	if t.m.TryLock() {
+		t.lockStats.add(op, false, 0)
+		return
+	}
+	start := time.Now()
+	t.m.Lock()
+	t.lockStats.add(op, true, time.Since(start))
+}
+
+// LockStats returns the lock statistics for each kind of operation. If the
+// table was not created with WithLockStats, nil is returned.
+func (t *Table[E]) LockStats() map[LockOp]LockStat {
+	if t.lockStats == nil {
+		return nil
+	}
+
+	t.lockStats.m.Lock()
+	defer t.lockStats.m.Unlock()
+
+	stats := make(map[LockOp]LockStat, len(t.lockStats.stats))
+	for op, s := range t.lockStats.stats {
+		stats[op] = s
+	}
+	return stats
+}
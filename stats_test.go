@@ -0,0 +1,100 @@
+package objectDB
+
+import (
+	"github.com/hneemann/objectDB/serialize"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	table, err := New[time.Time](myMonthly, PersistSerializer[time.Time]("testdata", "_db.bin", serialize.New()), nil, nil)
+	assert.NoError(t, err)
+	assert.EqualValues(t, Stats{}, table.Stats())
+
+	table.SetWriteDelay(10)
+	defer table.Shutdown()
+
+	n := time.Now()
+	table.Insert(add(n, -24*30))
+	table.Insert(add(n, 0))
+	table.Insert(add(n, 1))
+
+	assert.EqualValues(t, Stats{
+		Size:          3,
+		Version:       3,
+		Files:         2,
+		WriteDelay:    true,
+		DelaySeconds:  10,
+		PendingWrites: 2,
+	}, table.Stats())
+
+	assert.NoError(t, table.Flush())
+	assert.EqualValues(t, 0, table.Stats().PendingWrites)
+
+	a := table.Match(func(e *time.Time) bool { return true })
+	for range a.Size() {
+		assert.NoError(t, a.Delete(0))
+	}
+	assert.NoError(t, table.Flush())
+	assert.EqualValues(t, 0, table.Stats().Files)
+}
+
+func TestLockStats(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, table.LockStats())
+
+	table, err = New[time.Time](myMonthly, nil, nil, nil, WithLockStats[time.Time]())
+	assert.NoError(t, err)
+	n := time.Now()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				assert.NoError(t, table.Insert(add(n, i)))
+				first := true
+				table.Match(func(*time.Time) bool {
+					if first {
+						// hold the lock for a while
+						time.Sleep(10 * time.Microsecond)
+						first = false
+					}
+					return true
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats := table.LockStats()
+	assert.EqualValues(t, 80, stats[LockInsert].Count)
+	assert.EqualValues(t, 80, stats[LockRead].Count)
+	contended := stats[LockInsert].Contended + stats[LockRead].Contended
+	assert.True(t, contended > 0)
+	assert.True(t, stats[LockInsert].Wait+stats[LockRead].Wait > 0)
+}
+
+func TestLockStatsAllOperations(t *testing.T) {
+	table, err := New[time.Time](myMonthly, nil, nil, nil, WithLockStats[time.Time]())
+	assert.NoError(t, err)
+
+	table.OnAfterInsert(func(*time.Time) {})
+	_, cancel := table.Subscribe()
+	cancel()
+	assert.NoError(t, table.Batch(NewWriteBatch()))
+	assert.EqualValues(t, 4, table.LockStats()[LockConfig].Count)
+
+	assert.NoError(t, table.Insert(date(2024, 1, 1)))
+	r := table.Match(func(*time.Time) bool { return true })
+	_, err = r.Refresh()
+	assert.NoError(t, err)
+	_, err = r.Contains(func(*time.Time) bool { return true })
+	assert.NoError(t, err)
+	table.Stats()
+	assert.EqualValues(t, 4, table.LockStats()[LockRead].Count)
+}
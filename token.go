@@ -0,0 +1,133 @@
+package objectDB
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrElementChanged is returned by UpdateToken and ModifyToken if the element
+// was updated or removed after the token was obtained.
+var ErrElementChanged = errors.New("element has changed")
+
+// Token identifies a single element of a table together with its revision.
+// In contrast to a Result, which becomes invalid by any modification of the
+// table, a token only becomes invalid if its own element is updated or
+// removed. This allows an optimistic locking of single elements, e.g. while
+// a user edits a record. A token is obtained by Result.GetToken.
+type Token[E any] struct {
+	elem     *E
+	index    int
+	revision uint64
+}
+
+// GetToken works like Get, but additionally returns a token which can be used
+// to update the element by UpdateToken or ModifyToken later on.
+func (r *Result[E]) GetToken(dst *E, n int) (Token[E], error) {
+	if n < 0 || n >= len(r.tableIndex) {
+		return Token[E]{}, fmt.Errorf("item: %w", ErrIndexOutOfRange)
+	}
+
+	t := r.table
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	index := r.tableIndex[n]
+	err := t.copyAt(dst, index, r.version)
+	if err != nil {
+		return Token[E]{}, err
+	}
+	return t.token(index), nil
+}
+
+// UpdateToken updates the element identified by the token. Other
+// modifications of the table made after the token was obtained do not matter.
+// If the element itself was updated or removed in the meantime,
+// ErrElementChanged is returned. As with Result.Update, the update must not
+// change the position of the element in the sort order. A new token for the
+// updated element is returned.
+func (t *Table[E]) UpdateToken(token Token[E], e *E) (Token[E], error) {
+	t.lock(LockUpdate)
+	defer t.m.Unlock()
+
+	index, err := t.tokenIndex(token)
+	if err != nil {
+		return Token[E]{}, err
+	}
+	err = t.updateAt(index, e)
+	if err != nil {
+		return Token[E]{}, err
+	}
+	return t.token(index), nil
+}
+
+// ModifyToken works like UpdateToken, but calls mutate with a deep copy of
+// the element instead of replacing it by a given element.
+func (t *Table[E]) ModifyToken(token Token[E], mutate func(*E)) (Token[E], error) {
+	t.lock(LockUpdate)
+	defer t.m.Unlock()
+
+	index, err := t.tokenIndex(token)
+	if err != nil {
+		return Token[E]{}, err
+	}
+	var e E
+	err = t.safeCopy(&e, t.data[index])
+	if err != nil {
+		return Token[E]{}, fmt.Errorf("modify: %w", err)
+	}
+	mutate(&e)
+	err = t.updateAt(index, &e)
+	if err != nil {
+		return Token[E]{}, err
+	}
+	return t.token(index), nil
+}
+
+// token returns the token of the element at the given index. The caller has
+// to hold the lock.
+func (t *Table[E]) token(index int) Token[E] {
+	e := t.data[index]
+	return Token[E]{elem: e, index: index, revision: t.revisions[e]}
+}
+
+// tokenIndex returns the current index of the element identified by the
+// token. The recorded index is checked first, because it is still valid if
+// no elements were inserted or removed in front of the element. The caller
+// has to hold the lock.
+func (t *Table[E]) tokenIndex(token Token[E]) (int, error) {
+	if token.elem == nil {
+		return 0, errors.New("token: invalid token")
+	}
+	index := token.index
+	if index >= len(t.data) || t.data[index] != token.elem {
+		index = slices.Index(t.data, token.elem)
+	}
+	if index < 0 || t.revisions[token.elem] != token.revision {
+		return 0, fmt.Errorf("token: %w", ErrElementChanged)
+	}
+	return index, nil
+}
+
+// bumpRevision increments the revision of the given element. The revisions
+// of removed elements are pruned from time to time. The caller has to hold
+// the lock.
+func (t *Table[E]) bumpRevision(e *E) {
+	if t.revisions == nil {
+		t.revisions = map[*E]uint64{}
+	}
+	t.revisions[e]++
+
+	if len(t.revisions) > t.pruneLimit {
+		stored := make(map[*E]bool, len(t.data))
+		for _, en := range t.data {
+			stored[en] = true
+		}
+		for en := range t.revisions {
+			if !stored[en] {
+				delete(t.revisions, en)
+			}
+		}
+		t.pruneLimit = 2*len(t.revisions) + 16
+	}
+}
@@ -0,0 +1,74 @@
+package objectDB
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestToken(t *testing.T) {
+	byDate := func(a, b *person) bool { return a.Date.Before(b.Date) }
+	table, err := New[person](nil, nil, nil, byDate)
+	assert.NoError(t, err)
+	assert.NoError(t, table.Insert(&person{Date: *date(2024, 1, 2), Name: "Alice"}))
+	assert.NoError(t, table.Insert(&person{Date: *date(2024, 1, 3), Name: "Bob"}))
+	assert.NoError(t, table.Insert(&person{Date: *date(2024, 1, 4), Name: "Carol"}))
+
+	r := table.Match(func(p *person) bool { return p.Name == "Alice" })
+	var alice person
+	token, err := r.GetToken(&alice, 0)
+	assert.NoError(t, err)
+	assert.EqualValues(t, "Alice", alice.Name)
+
+	// unrelated modifications do not invalidate the token
+	ok, err := table.UpdateMatch(func(p *person) bool { return p.Name == "Bob" }, &person{Date: *date(2024, 1, 3), Name: "Robert"})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, table.Insert(&person{Date: *date(2024, 1, 1), Name: "Dave"}))
+
+	alice.Name = "Alicia"
+	token, err = table.UpdateToken(token, &alice)
+	assert.NoError(t, err)
+	found, ok := table.FirstVal(func(p *person) bool { return p.Name == "Alicia" })
+	assert.True(t, ok)
+	assert.EqualValues(t, *date(2024, 1, 2), found.Date)
+
+	// the new token can be used for the next update
+	token, err = table.ModifyToken(token, func(p *person) { p.Name = "Ali" })
+	assert.NoError(t, err)
+
+	// a modification of the element itself invalidates the token
+	ok, err = table.UpdateMatch(func(p *person) bool { return p.Name == "Ali" }, &person{Date: *date(2024, 1, 2), Name: "Alice"})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	_, err = table.UpdateToken(token, &alice)
+	assert.ErrorIs(t, err, ErrElementChanged)
+	_, err = table.ModifyToken(token, func(p *person) {})
+	assert.ErrorIs(t, err, ErrElementChanged)
+
+	// as does its removal
+	r = table.Match(func(p *person) bool { return p.Name == "Carol" })
+	var carol person
+	token, err = r.GetToken(&carol, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Delete(0))
+	_, err = table.ModifyToken(token, func(p *person) { p.Name = "Caroline" })
+	assert.ErrorIs(t, err, ErrElementChanged)
+	assert.EqualValues(t, 3, table.Size())
+
+	_, err = table.UpdateToken(Token[person]{}, &alice)
+	assert.Error(t, err)
+}
+
+func TestTokenPrune(t *testing.T) {
+	byDate := func(a, b *person) bool { return a.Date.Before(b.Date) }
+	table, err := New[person](nil, nil, nil, byDate)
+	assert.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		p := person{Date: *date(2024, 1, 1)}
+		assert.NoError(t, table.Insert(&p))
+		r := table.Match(func(*person) bool { return true })
+		assert.NoError(t, r.Modify(0, func(p *person) { p.Name = "x" }))
+		assert.NoError(t, r.Delete(0))
+	}
+	assert.True(t, len(table.revisions) < 20)
+}
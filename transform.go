@@ -0,0 +1,230 @@
+package objectDB
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/hneemann/objectDB/serialize"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// PersistCompressed returns a Persist that gzip-compresses the files written
+// by the inner Persist. The suffix ".gz" is appended to the file names. The
+// inner Persist has to be file based, see Persist.
+func PersistCompressed[E any](inner Persist[E]) Persist[E] {
+	return wrapStream(inner, gzipTransform{})
+}
+
+type gzipTransform struct{}
+
+func (gzipTransform) suffix() string {
+	return ".gz"
+}
+
+func (gzipTransform) writer(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipTransform) reader(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+// PersistEncrypted returns a Persist that encrypts the files written by the
+// inner Persist using AES-GCM. The key has to be 16, 24 or 32 bytes long to
+// select AES-128, AES-192 or AES-256. A random nonce is created for each file
+// and is stored in front of the ciphertext. The suffix ".enc" is appended to
+// the file names. The inner Persist has to be file based.
+func PersistEncrypted[E any](inner Persist[E], key []byte) Persist[E] {
+	return wrapStream(inner, aesTransform{key: append([]byte{}, key...)})
+}
+
+type aesTransform struct {
+	key []byte
+}
+
+func (aesTransform) suffix() string {
+	return ".enc"
+}
+
+func (a aesTransform) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(a.key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+	return gcm, nil
+}
+
+func (a aesTransform) writer(w io.Writer) (io.WriteCloser, error) {
+	gcm, err := a.aead()
+	if err != nil {
+		return nil, err
+	}
+	return &aesWriter{gcm: gcm, w: w}, nil
+}
+
+// aesWriter collects all data and writes the encrypted data on close.
+type aesWriter struct {
+	gcm cipher.AEAD
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (a *aesWriter) Write(p []byte) (int, error) {
+	return a.buf.Write(p)
+}
+
+func (a *aesWriter) Close() error {
+	nonce := make([]byte, a.gcm.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return fmt.Errorf("could not create nonce: %w", err)
+	}
+	_, err = a.w.Write(a.gcm.Seal(nonce, nonce, a.buf.Bytes(), nil))
+	return err
+}
+
+func (a aesTransform) reader(r io.Reader) (io.Reader, error) {
+	gcm, err := a.aead()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	ns := gcm.NonceSize()
+	if len(data) < ns {
+		return nil, errors.New("encrypted data too short")
+	}
+	plain, err := gcm.Open(nil, data[:ns], data[ns:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt data, wrong key or data corrupted: %w", err)
+	}
+	return bytes.NewReader(plain), nil
+}
+
+// ErrChecksumMismatch is returned by Restore if the checksum stored in a file
+// does not match its content, which means that the file is corrupted.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// PersistChecksum returns a Persist that appends a CRC32 checksum to the files
+// written by the inner Persist. The checksum is verified on Restore, and if it
+// does not match, an error wrapping ErrChecksumMismatch and naming the file is
+// returned. This allows to distinguish a corrupted file from a file which can
+// not be decoded for other reasons. The suffix ".crc" is appended to the file
+// names. The inner Persist has to be file based.
+func PersistChecksum[E any](inner Persist[E]) Persist[E] {
+	return wrapStream(inner, crcTransform{})
+}
+
+type crcTransform struct{}
+
+func (crcTransform) suffix() string {
+	return ".crc"
+}
+
+func (crcTransform) writer(w io.Writer) (io.WriteCloser, error) {
+	return &crcWriter{w: w, crc: crc32.NewIEEE()}, nil
+}
+
+// crcWriter passes the data to the inner writer and writes the checksum on
+// close.
+type crcWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+}
+
+func (c *crcWriter) Write(p []byte) (int, error) {
+	c.crc.Write(p)
+	return c.w.Write(p)
+}
+
+func (c *crcWriter) Close() error {
+	_, err := c.w.Write(c.crc.Sum(nil))
+	return err
+}
+
+func (crcTransform) reader(r io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < crc32.Size {
+		return nil, fmt.Errorf("file too short: %w", ErrChecksumMismatch)
+	}
+	payload := data[:len(data)-crc32.Size]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(data[len(payload):]) {
+		return nil, ErrChecksumMismatch
+	}
+	return bytes.NewReader(payload), nil
+}
+
+// ErrRegistrationMismatch is returned by Restore if a file was written by a
+// serializer having other registered types than the serializer used to read
+// it.
+var ErrRegistrationMismatch = errors.New("registration mismatch")
+
+// PersistFingerprint returns a Persist that stores the fingerprint of the
+// registered types of the serializer in front of each file written by the
+// inner Persist. On Restore, the fingerprint is compared to the one of the
+// serializer, and if they differ, an error wrapping ErrRegistrationMismatch
+// is returned. This detects files written with another set of registered
+// interface types, which could otherwise be decoded to wrong types. The
+// suffix ".fp" is appended to the file names. The inner Persist has to be
+// file based and is expected to use the given serializer.
+func PersistFingerprint[E any](inner Persist[E], serializer *serialize.Serializer) Persist[E] {
+	return wrapStream(inner, fingerprintTransform{serializer: serializer})
+}
+
+type fingerprintTransform struct {
+	serializer *serialize.Serializer
+}
+
+func (fingerprintTransform) suffix() string {
+	return ".fp"
+}
+
+func (f fingerprintTransform) writer(w io.Writer) (io.WriteCloser, error) {
+	fp := f.serializer.Fingerprint()
+	_, err := w.Write(append([]byte{byte(len(fp))}, fp...))
+	if err != nil {
+		return nil, err
+	}
+	return nopCloser{w}, nil
+}
+
+func (f fingerprintTransform) reader(r io.Reader) (io.Reader, error) {
+	var l [1]byte
+	_, err := io.ReadFull(r, l[:])
+	if err != nil {
+		return nil, fmt.Errorf("could not read fingerprint: %w", err)
+	}
+	fp := make([]byte, l[0])
+	_, err = io.ReadFull(r, fp)
+	if err != nil {
+		return nil, fmt.Errorf("could not read fingerprint: %w", err)
+	}
+	if string(fp) != f.serializer.Fingerprint() {
+		return nil, ErrRegistrationMismatch
+	}
+	return r, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
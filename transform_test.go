@@ -0,0 +1,161 @@
+package objectDB
+
+import (
+	"fmt"
+	"github.com/hneemann/objectDB/serialize"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testTransformRoundTrip(t *testing.T, p Persist[time.Time], suffix string, magic []byte) {
+	table, err := New[time.Time](myMonthly, p, nil, nil)
+	assert.NoError(t, err)
+	n := time.Now()
+
+	table.Insert(add(n, -24*30))
+	table.Insert(add(n, 0))
+	table.Insert(add(n, 24*30))
+
+	files, err := os.ReadDir("testdata")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, len(files))
+	for _, f := range files {
+		assert.True(t, strings.HasSuffix(f.Name(), suffix))
+		if magic != nil {
+			b, err := os.ReadFile("testdata/" + f.Name())
+			assert.NoError(t, err)
+			assert.EqualValues(t, magic, b[:len(magic)])
+		}
+	}
+
+	table2, err := New[time.Time](myMonthly, p, nil, func(a, b *time.Time) bool { return a.Before(*b) })
+	assert.NoError(t, err)
+	a := table2.Match(func(e *time.Time) bool { return true })
+	assert.EqualValues(t, 3, a.Size())
+	var e time.Time
+	assert.NoError(t, a.Get(&e, 1))
+	assert.True(t, n.Equal(e))
+	assert.NoError(t, a.Delete(0))
+	assert.NoError(t, a.Delete(0))
+	assert.NoError(t, a.Delete(0))
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func TestCompressedJSON(t *testing.T) {
+	testTransformRoundTrip(t, PersistCompressed(PersistJSON[time.Time]("testdata", "_db.json")), "_db.json.gz", gzipMagic)
+}
+
+func TestCompressedSerializer(t *testing.T) {
+	testTransformRoundTrip(t, PersistCompressed(PersistSerializer[time.Time]("testdata", "_db.bin", serialize.New())), "_db.bin.gz", gzipMagic)
+}
+
+func TestCompressedCorruptTrailer(t *testing.T) {
+	p := PersistCompressed(PersistSerializer[time.Time]("testdata", "_db.bin", serialize.New()))
+	assert.NoError(t, p.Persist("gz", []*time.Time{date(2024, 1, 1), date(2024, 1, 2)}))
+	defer func() { assert.NoError(t, p.Persist("gz", nil)) }()
+	b, err := os.ReadFile("testdata/gz_db.bin.gz")
+	assert.NoError(t, err)
+
+	// the last 8 bytes are the checksum and the size of the data
+	flipped := append([]byte{}, b...)
+	flipped[len(flipped)-8] ^= 0xff
+	for _, data := range [][]byte{flipped, b[:len(b)-8]} {
+		assert.NoError(t, os.WriteFile("testdata/gz_db.bin.gz", data, 0644))
+		_, err = p.Restore()
+		assert.Error(t, err)
+	}
+}
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestEncrypted(t *testing.T) {
+	testTransformRoundTrip(t, PersistEncrypted(PersistSerializer[time.Time]("testdata", "_db.bin", serialize.New()), testKey), "_db.bin.enc", nil)
+}
+
+func TestCompressedEncrypted(t *testing.T) {
+	testTransformRoundTrip(t, PersistEncrypted(PersistCompressed(PersistJSON[time.Time]("testdata", "_db.json")), testKey), "_db.json.gz.enc", nil)
+}
+
+func TestEncryptedFailure(t *testing.T) {
+	p := PersistEncrypted(PersistJSON[time.Time]("testdata", "_db.json"), testKey)
+	n := time.Now()
+	assert.NoError(t, p.Persist("enc", []*time.Time{&n}))
+
+	wrongKey := PersistEncrypted(PersistJSON[time.Time]("testdata", "_db.json"), []byte("fedcba9876543210fedcba9876543210"))
+	_, err := wrongKey.Restore()
+	assert.Error(t, err)
+
+	b, err := os.ReadFile("testdata/enc_db.json.enc")
+	assert.NoError(t, err)
+	b[len(b)-1] ^= 1
+	assert.NoError(t, os.WriteFile("testdata/enc_db.json.enc", b, 0644))
+	_, err = p.Restore()
+	assert.Error(t, err)
+
+	assert.NoError(t, p.Persist("enc", nil))
+
+	_, err = PersistEncrypted(PersistJSON[time.Time]("testdata", "_db.json"), []byte("short")).Restore()
+	assert.NoError(t, err)
+	assert.Error(t, PersistEncrypted(PersistJSON[time.Time]("testdata", "_db.json"), []byte("short")).Persist("enc", []*time.Time{&n}))
+}
+
+func TestChecksum(t *testing.T) {
+	testTransformRoundTrip(t, PersistChecksum(PersistSerializer[time.Time]("testdata", "_db.bin", serialize.New())), "_db.bin.crc", nil)
+	testTransformRoundTrip(t, PersistChecksum(PersistCompressed(PersistJSON[time.Time]("testdata", "_db.json"))), "_db.json.gz.crc", gzipMagic)
+}
+
+func TestChecksumFailure(t *testing.T) {
+	p := PersistChecksum(PersistSerializer[time.Time]("testdata", "_db.bin", serialize.New()))
+	n := time.Now()
+	assert.NoError(t, p.Persist("crc", []*time.Time{&n, &n}))
+
+	b, err := os.ReadFile("testdata/crc_db.bin.crc")
+	assert.NoError(t, err)
+	b[len(b)/2] ^= 1
+	assert.NoError(t, os.WriteFile("testdata/crc_db.bin.crc", b, 0644))
+	_, err = p.Restore()
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.Contains(t, err.Error(), "crc_db.bin.crc")
+
+	assert.NoError(t, os.WriteFile("testdata/crc_db.bin.crc", b[:2], 0644))
+	_, err = p.Restore()
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	assert.NoError(t, p.Persist("crc", nil))
+}
+
+type otherStringer struct {
+	N int
+}
+
+func (o otherStringer) String() string {
+	return fmt.Sprint(o.N)
+}
+
+func TestFingerprint(t *testing.T) {
+	ser := serialize.New().Register(stringer{}).Register(otherStringer{})
+	p := PersistFingerprint(PersistSerializer[withInterface]("testdata", "_db.bin", ser), ser)
+	items := []*withInterface{{N: 1, S: stringer{S: "a"}}, {N: 2, S: otherStringer{N: 3}}}
+	assert.NoError(t, p.Persist("fp", items))
+
+	// the order of the registration does not matter
+	same := serialize.New().Register(otherStringer{}).Register(stringer{})
+	restored, err := PersistFingerprint(PersistSerializer[withInterface]("testdata", "_db.bin", same), same).Restore()
+	assert.NoError(t, err)
+	assert.EqualValues(t, items, restored)
+
+	other := serialize.New().Register(stringer{})
+	_, err = PersistFingerprint(PersistSerializer[withInterface]("testdata", "_db.bin", other), other).Restore()
+	assert.ErrorIs(t, err, ErrRegistrationMismatch)
+	assert.Contains(t, err.Error(), "fp_db.bin.fp")
+
+	renamed := serialize.New().RegisterName("s", stringer{}).Register(otherStringer{})
+	_, err = PersistFingerprint(PersistSerializer[withInterface]("testdata", "_db.bin", renamed), renamed).Restore()
+	assert.ErrorIs(t, err, ErrRegistrationMismatch)
+
+	assert.NoError(t, p.Persist("fp", nil))
+}
@@ -0,0 +1,134 @@
+package objectDB
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrInconsistent is returned by Verify and VerifyFiles if an inconsistency
+// was found.
+var ErrInconsistent = errors.New("inconsistent table")
+
+// Verify checks the invariants of the table: The elements have to be sorted
+// according to the less function, and SameFile of the name provider has to
+// agree with ToFile for all elements. The table is not modified, so Verify can
+// be called periodically, e.g. by a readiness probe. The table is locked
+// during the check. If an inconsistency is found, an error wrapping
+// ErrInconsistent is returned.
+func (t *Table[E]) Verify() error {
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	return t.verify()
+}
+
+// VerifyFiles works like Verify, but additionally restores all files and
+// checks that each file contains the same elements in the same order as the
+// table. Files with pending delayed writes or pending writes of a WriteBatch
+// are skipped. If the Persist is file based, the elements are compared by
+// their encoding in the file, so e.g. the monotonic clock reading of a
+// time.Time, which is not stored, does not cause a difference. Otherwise the
+// elements are compared using reflect.DeepEqual. The table is locked while the
+// files are read.
+func (t *Table[E]) VerifyFiles() error {
+	if t.persist == nil {
+		return errors.New("verify: the table is not persisted")
+	}
+
+	t.lock(LockRead)
+	defer t.m.Unlock()
+
+	err := t.verify()
+	if err != nil {
+		return err
+	}
+
+	restored, err := t.persist.Restore()
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	onDisk := map[string][]*E{}
+	for _, e := range restored {
+		name := t.nameProvider.ToFile(e)
+		onDisk[name] = append(onDisk[name], e)
+	}
+	inMemory := t.groupByFile()
+	equal := func(a, b *E) bool { return reflect.DeepEqual(a, b) }
+	if sp, ok := t.persist.(streamPersist[E]); ok {
+		equal = func(a, b *E) bool { return sameEncoding(sp, a, b) }
+	}
+
+	for name := range onDisk {
+		if _, ok := inMemory[name]; !ok && !t.pending(name) {
+			return fmt.Errorf("verify: file %s is not empty, but the table contains no element of it: %w", name, ErrInconsistent)
+		}
+	}
+	for name, es := range inMemory {
+		if t.pending(name) {
+			continue
+		}
+		disk := onDisk[name]
+		if len(disk) != len(es) {
+			return fmt.Errorf("verify: file %s contains %d elements, but the table contains %d: %w", name, len(disk), len(es), ErrInconsistent)
+		}
+		for i := range es {
+			if !equal(es[i], disk[i]) {
+				return fmt.Errorf("verify: element %d of file %s differs from the table: %w", i, name, ErrInconsistent)
+			}
+		}
+	}
+	return nil
+}
+
+// sameEncoding returns true if both elements are encoded to the same bytes by
+// the codec of the persist.
+func sameEncoding[E any](sp streamPersist[E], a, b *E) bool {
+	var ba, bb bytes.Buffer
+	if sp.encodePlain(&ba, []*E{a}) != nil || sp.encodePlain(&bb, []*E{b}) != nil {
+		return false
+	}
+	return bytes.Equal(ba.Bytes(), bb.Bytes())
+}
+
+// verify checks the order and the name provider. The caller has to hold the
+// lock.
+func (t *Table[E]) verify() error {
+	if t.orderLess != nil {
+		for i := 1; i < len(t.data); i++ {
+			if t.orderLess(t.data[i], t.data[i-1]) {
+				return fmt.Errorf("verify: table not sorted at index %d: %w", i, ErrInconsistent)
+			}
+		}
+	}
+
+	if t.nameProvider != nil {
+		// one element of each file is compared to all elements
+		first := map[string]*E{}
+		for _, e := range t.data {
+			name := t.nameProvider.ToFile(e)
+			if _, ok := first[name]; !ok {
+				first[name] = e
+			}
+		}
+		for _, e := range t.data {
+			name := t.nameProvider.ToFile(e)
+			for n, f := range first {
+				if t.nameProvider.SameFile(e, f) != (n == name) {
+					return fmt.Errorf("verify: SameFile and ToFile disagree for elements of the files %s and %s: %w", name, n, ErrInconsistent)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// pending returns true if there are pending writes of the given file. The
+// caller has to hold the lock.
+func (t *Table[E]) pending(name string) bool {
+	if t.batchFiles[name] {
+		return true
+	}
+	return t.delayedWrite != nil && t.delayedWrite.isPending(name)
+}
@@ -0,0 +1,80 @@
+package objectDB
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	table, err := New[time.Time](myMonthly, nil, nil, less)
+	assert.NoError(t, err)
+	fillTable(table)
+	assert.NoError(t, table.Verify())
+
+	// swap two elements to break the order
+	table.data[2], table.data[3] = table.data[3], table.data[2]
+	err = table.Verify()
+	assert.ErrorIs(t, err, ErrInconsistent)
+	assert.Contains(t, err.Error(), "not sorted at index 3")
+
+	table, err = NewFromData([]*time.Time{date(2024, 5, 1), date(2024, 6, 1)}, nil, nil)
+	assert.NoError(t, err)
+	table.nameProvider = allSame{myMonthly}
+	err = table.Verify()
+	assert.ErrorIs(t, err, ErrInconsistent)
+	assert.Contains(t, err.Error(), "SameFile and ToFile disagree")
+}
+
+func TestVerifyFiles(t *testing.T) {
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	p := PersistJSON[time.Time]("testdata", "_db.json")
+	table, err := New[time.Time](myMonthly, p, nil, less)
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, table.Replace(nil))
+		assert.NoError(t, p.Persist("test_2024_07", nil))
+	}()
+	for m := time.April; m <= time.June; m++ {
+		assert.NoError(t, table.Insert(date(2024, m, 10)))
+		assert.NoError(t, table.Insert(date(2024, m, 20)))
+	}
+	assert.NoError(t, table.VerifyFiles())
+
+	// a file modified outside the table
+	assert.NoError(t, p.Persist("test_2024_05", []*time.Time{date(2024, 5, 10), date(2024, 5, 21)}))
+	err = table.VerifyFiles()
+	assert.ErrorIs(t, err, ErrInconsistent)
+	assert.Contains(t, err.Error(), "element 1 of file test_2024_05")
+
+	assert.NoError(t, p.Persist("test_2024_05", []*time.Time{date(2024, 5, 10)}))
+	err = table.VerifyFiles()
+	assert.ErrorIs(t, err, ErrInconsistent)
+	assert.Contains(t, err.Error(), "file test_2024_05 contains 1 elements")
+
+	assert.NoError(t, p.Persist("test_2024_05", []*time.Time{date(2024, 5, 10), date(2024, 5, 20)}))
+	assert.NoError(t, p.Persist("test_2024_07", []*time.Time{date(2024, 7, 10)}))
+	err = table.VerifyFiles()
+	assert.ErrorIs(t, err, ErrInconsistent)
+	assert.Contains(t, err.Error(), "file test_2024_07 is not empty")
+	assert.NoError(t, p.Persist("test_2024_07", nil))
+
+	// pending writes are not reported
+	table.SetWriteDelay(10)
+	assert.NoError(t, table.Insert(date(2024, 6, 25)))
+	assert.NoError(t, table.VerifyFiles())
+	assert.NoError(t, table.Shutdown())
+
+	// the location and the monotonic clock reading are not stored
+	now := time.Now()
+	assert.NoError(t, table.Insert(&now))
+	cest := time.Date(2024, 4, 15, 8, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	assert.NoError(t, table.Insert(&cest))
+	assert.NoError(t, table.VerifyFiles())
+
+	memory, err := New[time.Time](nil, nil, nil, less)
+	assert.NoError(t, err)
+	assert.NoError(t, memory.Verify())
+	assert.Error(t, memory.VerifyFiles())
+}
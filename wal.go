@@ -0,0 +1,264 @@
+package objectDB
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+)
+
+const (
+	walInsert  = "insert"
+	walDelete  = "delete"
+	walUpdate  = "update"
+	walReplace = "replace"
+	walFlushed = "flushed"
+)
+
+// walRecord is a single entry of the write-ahead log. The elements are
+// stored in JSON format.
+type walRecord[E any] struct {
+	Op   string `json:"op"`
+	File string `json:"file,omitempty"`
+	Old  *E     `json:"old,omitempty"`
+	New  *E     `json:"new,omitempty"`
+	All  []*E   `json:"all,omitempty"`
+}
+
+// EnableWAL enables the write-ahead log stored in the given file. If a write
+// delay is set, each modification is appended to the log before it is
+// applied, so that modifications which are not yet written to the files are
+// not lost if the application crashes. If the log file already exists, the
+// modifications found in it are replayed, and the affected files are written.
+// The log is truncated each time all modified files are written. The elements
+// are stored in JSON format, so they have to be encodable by encoding/json.
+// EnableWAL should be called directly after the table is created.
+func (t *Table[E]) EnableWAL(path string) error {
+	t.lock(LockConfig)
+	defer t.m.Unlock()
+
+	if t.persist == nil {
+		return errors.New("wal: the table is not persisted")
+	}
+	if t.wal != nil {
+		return errors.New("wal: already enabled")
+	}
+
+	names, err := t.replayWAL(path)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		err = t.writeFile(name)
+		if err != nil {
+			return fmt.Errorf("wal: could not write replayed file: %w", err)
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("wal: could not open log: %w", err)
+	}
+	t.wal = f
+	return nil
+}
+
+// replayWAL applies the modifications stored in the log to the table and
+// returns the names of the modified files. A modification is only applied if
+// its file was not written after the modification was logged. The caller has
+// to hold the lock.
+func (t *Table[E]) replayWAL(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("wal: could not open log: %w", err)
+	}
+	defer LogClose(f)
+
+	var records []walRecord[E]
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Println("wal: ignore incomplete last record")
+			}
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("wal: could not read log: %w", err)
+		}
+		var rec walRecord[E]
+		err = json.Unmarshal(line, &rec)
+		if err != nil {
+			return nil, fmt.Errorf("wal: could not decode record %d: %w", len(records), err)
+		}
+		records = append(records, rec)
+	}
+
+	flushed := map[string]int{}
+	for i, rec := range records {
+		if rec.Op == walFlushed {
+			flushed[rec.File] = i
+		}
+	}
+
+	modified := map[string]bool{}
+	pending := func(i int, e *E) bool {
+		name := t.nameProvider.ToFile(e)
+		if f, ok := flushed[name]; ok && f > i {
+			return false
+		}
+		modified[name] = true
+		return true
+	}
+	insert := func(i int, e *E) {
+		if e != nil && pending(i, e) {
+			t.data = append(t.data, e)
+		}
+	}
+	remove := func(i int, e *E) {
+		if e != nil && pending(i, e) {
+			// the elements are compared by their JSON representation, which
+			// is also used to store them in the log
+			b, _ := json.Marshal(e)
+			for j, en := range t.data {
+				if bn, err := json.Marshal(en); err == nil && bytes.Equal(b, bn) {
+					t.remove(j)
+					return
+				}
+			}
+			log.Println("wal: element to delete not found in file", t.nameProvider.ToFile(e))
+		}
+	}
+
+	for i, rec := range records {
+		switch rec.Op {
+		case walInsert:
+			insert(i, rec.New)
+		case walDelete:
+			remove(i, rec.Old)
+		case walUpdate:
+			remove(i, rec.Old)
+			insert(i, rec.New)
+		case walReplace:
+			var data []*E
+			for _, e := range t.data {
+				name := t.nameProvider.ToFile(e)
+				if rec.File != "" && rec.File != name {
+					// only a single file was replaced
+					data = append(data, e)
+				} else if f, ok := flushed[name]; ok && f > i {
+					data = append(data, e)
+				} else {
+					modified[name] = true
+				}
+			}
+			t.data = data
+			for _, e := range rec.All {
+				insert(i, e)
+			}
+		case walFlushed:
+		default:
+			return nil, fmt.Errorf("wal: unknown operation %q in record %d", rec.Op, i)
+		}
+	}
+
+	if len(modified) == 0 {
+		return nil, nil
+	}
+
+	if t.orderLess != nil {
+		sort.SliceStable(t.data, func(i, j int) bool {
+			return t.orderLess(t.data[i], t.data[j])
+		})
+	}
+	t.version.Add(1)
+
+	var names []string
+	for name := range modified {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// logWAL appends a modification to the log, if the log is enabled and a write
+// delay is set. Without a write delay the modifications are written to the
+// files immediately, so there is no need to log them. The caller has to hold
+// the lock.
+func (t *Table[E]) logWAL(rec walRecord[E]) error {
+	if t.wal == nil || t.delayedWrite == nil {
+		return nil
+	}
+	err := t.writeWAL(rec)
+	if err != nil {
+		return err
+	}
+	t.walDirty = true
+	return nil
+}
+
+// walWritten records in the log that the given file was written. Without a
+// write delay nothing is recorded, unless the log still contains modifications,
+// which is the case while the pending files are written at shutdown. The
+// caller has to hold the lock.
+func (t *Table[E]) walWritten(name string) error {
+	if t.wal == nil || (t.delayedWrite == nil && !t.walDirty) {
+		return nil
+	}
+	return t.writeWAL(walRecord[E]{Op: walFlushed, File: name})
+}
+
+func (t *Table[E]) writeWAL(rec walRecord[E]) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("wal: could not encode record: %w", err)
+	}
+	_, err = t.wal.Write(append(b, '\n'))
+	if err != nil {
+		return fmt.Errorf("wal: could not write record: %w", err)
+	}
+	return nil
+}
+
+// truncateWAL truncates the log if all modified files are written.
+func (t *Table[E]) truncateWAL() {
+	t.lock(LockWrite)
+	defer t.m.Unlock()
+
+	t.clearWAL()
+}
+
+// clearWAL truncates the log if all modified files are written. The caller
+// has to hold the lock.
+func (t *Table[E]) clearWAL() {
+	if t.wal == nil || (t.delayedWrite != nil && t.delayedWrite.pending() > 0) {
+		return
+	}
+	err := t.wal.Truncate(0)
+	if err != nil {
+		log.Println("wal: could not truncate log:", err)
+		return
+	}
+	t.walDirty = false
+}
+
+// closeWAL closes the log.
+func (t *Table[E]) closeWAL() {
+	t.lock(LockConfig)
+	defer t.m.Unlock()
+
+	if t.wal != nil {
+		LogClose(t.wal)
+		t.wal = nil
+		t.walDirty = false
+	}
+}
@@ -0,0 +1,141 @@
+package objectDB
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// crash stops the table without writing the modified files.
+func crash[E any](table *Table[E]) {
+	table.m.Lock()
+	dw := table.delayedWrite
+	table.delayedWrite = nil
+	table.m.Unlock()
+	close(dw.done)
+	<-dw.ack
+	table.closeWAL()
+}
+
+func TestWAL(t *testing.T) {
+	const walFile = "testdata/wal.log"
+	defer os.Remove(walFile)
+
+	less := func(a, b *time.Time) bool { return a.Before(*b) }
+	p := PersistJSON[time.Time]("testdata", "_db.json")
+	open := func() *Table[time.Time] {
+		table, err := New[time.Time](myMonthly, p, nil, less)
+		assert.NoError(t, err)
+		return table
+	}
+	contains := func(table *Table[time.Time], e *time.Time) bool {
+		return table.Exists(func(en *time.Time) bool { return en.Equal(*e) })
+	}
+
+	n := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+	updated := n.Add(time.Hour + time.Minute*30)
+	table := open()
+	table.SetWriteDelay(10)
+	assert.NoError(t, table.EnableWAL(walFile))
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, table.Insert(add(n, i)))
+	}
+	assert.NoError(t, table.Insert(add(n, 24*31)))
+	r := table.Match(func(e *time.Time) bool { return e.Equal(*add(n, 1)) })
+	assert.NoError(t, r.Update(0, &updated))
+	r = table.Match(func(e *time.Time) bool { return e.Equal(*add(n, 0)) })
+	assert.NoError(t, r.Delete(0))
+	crash(table)
+
+	// nothing was written to the files
+	assert.EqualValues(t, 0, open().Size())
+
+	table = open()
+	assert.NoError(t, table.EnableWAL(walFile))
+	assert.EqualValues(t, 3, table.Size())
+	assert.True(t, contains(table, &updated))
+	assert.True(t, contains(table, add(n, 2)))
+	assert.True(t, contains(table, add(n, 24*31)))
+	table.Shutdown()
+
+	// the replayed modifications are persisted
+	table = open()
+	assert.EqualValues(t, 3, table.Size())
+
+	// one file was written before the crash, the other was not
+	july := add(n, 24*61)
+	august := add(n, 24*92)
+	table.SetWriteDelay(10)
+	assert.NoError(t, table.EnableWAL(walFile))
+	assert.NoError(t, table.Insert(july))
+	assert.NoError(t, table.Flush())
+	info, err := os.Stat(walFile)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, info.Size())
+
+	assert.NoError(t, table.Insert(add(*july, 1)))
+	assert.NoError(t, table.Insert(august))
+	assert.NoError(t, table.writeFiles(myMonthly.ToFile(july)))
+	crash(table)
+
+	table = open()
+	assert.EqualValues(t, 5, table.Size())
+	assert.NoError(t, table.EnableWAL(walFile))
+	assert.EqualValues(t, 6, table.Size())
+	assert.True(t, contains(table, august))
+	table.Shutdown()
+
+	assert.NoError(t, open().Replace(nil))
+}
+
+func TestWALReplaceFile(t *testing.T) {
+	const walFile = "testdata/wal.log"
+	defer os.Remove(walFile)
+
+	p := PersistJSON[time.Time]("testdata", "_db.json")
+	open := func() *Table[time.Time] {
+		table, err := New[time.Time](myMonthly, p, nil, nil)
+		assert.NoError(t, err)
+		return table
+	}
+
+	table := open()
+	assert.NoError(t, table.Insert(date(2024, 1, 1)))
+	assert.NoError(t, table.Insert(date(2024, 2, 1)))
+	table.SetWriteDelay(10)
+	assert.NoError(t, table.EnableWAL(walFile))
+	assert.NoError(t, table.ReplaceFile("test_2024_02", []*time.Time{date(2024, 2, 2), date(2024, 2, 3)}))
+	crash(table)
+
+	table = open()
+	assert.NoError(t, table.EnableWAL(walFile))
+	assert.EqualValues(t, []string{"test_2024_01", "test_2024_02"}, table.Files())
+	assert.EqualValues(t, 3, table.Size())
+	assert.True(t, table.Exists(func(e *time.Time) bool { return e.Equal(*date(2024, 1, 1)) }))
+	assert.False(t, table.Exists(func(e *time.Time) bool { return e.Equal(*date(2024, 2, 1)) }))
+	table.Shutdown()
+
+	assert.NoError(t, open().Replace(nil))
+}
+
+func TestWALWithoutDelay(t *testing.T) {
+	const walFile = "testdata/wal.log"
+	defer os.Remove(walFile)
+
+	table, err := New[time.Time](myMonthly, PersistJSON[time.Time]("testdata", "_db.json"), nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, table.EnableWAL(walFile))
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, table.ReplaceFile("test_2024_01", []*time.Time{add(*date(2024, 1, 1), i)}))
+	}
+
+	// without a write delay the files are written immediately, so nothing
+	// has to be logged
+	info, err := os.Stat(walFile)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, info.Size())
+
+	table.Shutdown()
+	assert.NoError(t, table.Replace(nil))
+}